@@ -2,10 +2,18 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
 	"tapedeck/internal/database/user"
+	"tapedeck/internal/fsck"
+	"time"
 )
 
 func main() {
@@ -14,11 +22,57 @@ func main() {
 	flag.StringVar(&dbFile, "dbFile", "", "Path to SQLite database file")
 
 	var action string
-	flag.StringVar(&action, "action", "", "Action to run, possible values: user-add, user-delete, upgrade")
+	flag.StringVar(&action, "action", "", "Action to run, possible values: user-add, user-list, user-enable, user-disable, user-role, user-email, user-delete, user-password, user-quota, usage, fsck, station-list, station-seed, station-add, station-edit, station-delete, upgrade, backup, restore")
 
 	var email string
 	flag.StringVar(&email, "email", "", "User's email address for user-xxx actions")
 
+	var role string
+	flag.StringVar(&role, "role", "", "Role for user-role, user or admin")
+
+	var newEmail string
+	flag.StringVar(&newEmail, "newEmail", "", "New email address for user-email")
+
+	var toEmail string
+	flag.StringVar(&toEmail, "toEmail", "", "For user-delete, give the user's tapes and audio files to this user")
+
+	var cascade bool
+	flag.BoolVar(&cascade, "cascade", false, "For user-delete, delete the user's tapes and audio files")
+
+	var userDir string
+	flag.StringVar(&userDir, "userDir", "", "Server's user directory holding the audio files, required for user-delete, usage and fsck")
+
+	var quotaMb int64
+	flag.Int64Var(&quotaMb, "quotaMb", user.QuotaDefault, "For user-quota, the MiB the user may store, 0 is unlimited and -1 the server's quotaMb")
+
+	// fields for station-add and station-edit, station-edit only changes the flags given
+	var st station.Station
+	flag.StringVar(&st.CallLetters, "callLetters", "", "Station's call letters for station-xxx actions")
+	flag.StringVar(&st.Freq, "freq", "", "Station's frequency")
+	flag.StringVar(&st.Desc, "desc", "", "Station's description")
+	flag.StringVar(&st.HomepageUrl, "homepage", "", "Station's homepage URL")
+	flag.StringVar(&st.ArchivesUrl, "archives", "", "Station's archives URL")
+	flag.StringVar(&st.LiveStreamUrl, "liveStream", "", "Station's live stream URL")
+	flag.StringVar(&st.TimeZone, "timeZone", "", "Station's time zone, eg America/New_York")
+
+	var backupDir string
+	flag.StringVar(&backupDir, "backupDir", "", "Directory for backup files, required for backup")
+
+	var keep int
+	flag.IntVar(&keep, "keep", 0, "Number of backups to keep in backupDir, 0 keeps all")
+
+	var backupFile string
+	flag.StringVar(&backupFile, "backupFile", "", "Backup file to restore, required for restore")
+
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dryRun", false, "Print the pending migrations of upgrade without applying them")
+
+	var repair bool
+	flag.BoolVar(&repair, "repair", false, "For fsck, repair the problems found instead of only printing them")
+
+	var stuckHours int
+	flag.IntVar(&stuckHours, "stuckHours", int(fsck.DefaultStuckAfter/time.Hour), "For fsck, hours a tape may be in progress before it is stuck")
+
 	flag.Parse()
 
 	if action == "" {
@@ -36,9 +90,22 @@ func main() {
 	db := database.New(dbFile)
 
 	if action == "upgrade" {
-		db.Open()
-		db.Upgrade()
-		db.Close()
+		must(db.Open())
+		if dryRun {
+			plan, err := db.Plan(context.Background())
+			must(err)
+
+			fmt.Printf("%d pending migrations\n", len(plan))
+			for _, m := range plan {
+				fmt.Println(m)
+				if m.Sql != "" {
+					fmt.Println(m.Sql)
+				}
+			}
+		} else {
+			must(db.Upgrade())
+		}
+		must(db.Close())
 	} else if action == "user-add" {
 		if email == "" {
 			fmt.Println("email required")
@@ -46,17 +113,299 @@ func main() {
 			return
 		}
 
-		db.Open()
+		must(db.Open())
 		u := user.New(email)
 		err := user.Insert(db, u)
 		if err != nil {
 			panic(err)
 		}
-		db.Close()
+		must(db.Close())
+	} else if action == "user-password" {
+		if email == "" {
+			fmt.Println("email required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		u := mustGetUser(db, email)
+
+		// read from stdin to keep the password out of shell history
+		fmt.Printf("New password for %s: ", email)
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			panic(err)
+		}
+
+		err = user.SetPassword(db, u.Id, strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			panic(err)
+		}
+		must(db.Close())
+	} else if action == "user-list" {
+		must(db.Open())
+		users, err := user.GetAll(db)
+		must(err)
+
+		for _, u := range users {
+			tapes, err := tape.GetTapesForUser(u.Id, db)
+			must(err)
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%d tapes\t%d bytes\n", u.Id, u.Email, u.Status, u.Role, u.Created, len(tapes), u.BytesUsed)
+		}
+		must(db.Close())
+	} else if action == "user-enable" || action == "user-disable" {
+		if email == "" {
+			fmt.Println("email required")
+			flag.Usage()
+			return
+		}
+
+		status := user.StatusEnabled
+		if action == "user-disable" {
+			status = user.StatusDisabled
+		}
+
+		must(db.Open())
+		u := mustGetUser(db, email)
+		must(user.SetStatus(db, u.Id, status, cliActor()))
+		fmt.Printf("%s is %s\n", email, status)
+		must(db.Close())
+	} else if action == "user-role" {
+		if email == "" || role == "" {
+			fmt.Println("email and role required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		u := mustGetUser(db, email)
+		must(user.SetRole(db, u.Id, role, cliActor()))
+		fmt.Printf("%s is %s\n", email, role)
+		must(db.Close())
+	} else if action == "user-email" {
+		if email == "" || newEmail == "" {
+			fmt.Println("email and newEmail required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		u := mustGetUser(db, email)
+		must(user.SetEmail(db, u.Id, newEmail))
+		fmt.Printf("%s is now %s\n", email, newEmail)
+		must(db.Close())
 	} else if action == "user-delete" {
-		panic("delete-user is not implemented")
+		if email == "" || userDir == "" {
+			fmt.Println("email and userDir required")
+			flag.Usage()
+			return
+		}
+		if cascade == (toEmail != "") {
+			fmt.Println("either cascade or toEmail required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		u := mustGetUser(db, email)
+
+		var to *user.User
+		if toEmail != "" {
+			to = mustGetUser(db, toEmail)
+			if to.Id == u.Id {
+				panic(fmt.Errorf("cannot give tapes to the deleted user"))
+			}
+		}
+
+		must(deleteUser(db, userDir, u, to))
+		if to != nil {
+			fmt.Printf("Deleted %s, their tapes now belong to %s\n", email, toEmail)
+		} else {
+			fmt.Printf("Deleted %s with their tapes\n", email)
+		}
+		must(db.Close())
+	} else if action == "user-quota" {
+		if email == "" {
+			fmt.Println("email required")
+			flag.Usage()
+			return
+		}
+
+		quota := quotaMb
+		if quota > 0 {
+			quota <<= 20
+		}
+
+		must(db.Open())
+		u := mustGetUser(db, email)
+		must(user.SetQuota(db, u.Id, quota))
+		switch quota {
+		case user.QuotaDefault:
+			fmt.Printf("%s has the server's quota\n", email)
+		case 0:
+			fmt.Printf("%s has no quota\n", email)
+		default:
+			fmt.Printf("%s has a quota of %d MiB\n", email, quotaMb)
+		}
+		must(db.Close())
+	} else if action == "usage" {
+		if userDir == "" {
+			fmt.Println("userDir required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		tapes, users, err := recomputeUsage(db, userDir)
+		must(err)
+		fmt.Printf("Fixed the size of %d tapes and the usage of %d users\n", tapes, users)
+		must(db.Close())
+	} else if action == "fsck" {
+		if userDir == "" {
+			fmt.Println("userDir required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		report, err := fsck.Check(db, fsck.Options{
+			UserDir:    userDir,
+			Repair:     repair,
+			StuckAfter: time.Duration(stuckHours) * time.Hour,
+			Now:        time.Now(),
+		})
+		if report != nil {
+			for _, p := range report.Problems {
+				if p.Repaired {
+					fmt.Println(p.String(), "(repaired)")
+				} else {
+					fmt.Println(p.String())
+				}
+			}
+			fmt.Printf("Checked %d tapes, found %d problems, repaired %d\n", report.Tapes, len(report.Problems), report.Repaired())
+		}
+		must(err)
+		must(db.Close())
+	} else if action == "station-list" {
+		must(db.Open())
+		stations, err := station.GetAll(db, database.AllRows)
+		must(err)
+
+		for _, s := range stations {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\n", s.Id, s.CallLetters, s.Freq, s.TimeZone, s.HomepageUrl)
+		}
+		must(db.Close())
+	} else if action == "station-seed" {
+		must(db.Open())
+		count, err := station.Seed(db)
+		must(err)
+		fmt.Printf("Added %d of %d built-in stations\n", count, len(station.Seeds))
+		must(db.Close())
+	} else if action == "station-add" {
+		must(st.Validate())
+
+		must(db.Open())
+		must(station.Insert(db, &st))
+		fmt.Println("Added", st.String())
+		must(db.Close())
+	} else if action == "station-edit" {
+		if st.CallLetters == "" {
+			fmt.Println("callLetters required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		s := mustGetStation(db, st.CallLetters)
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "freq":
+				s.Freq = st.Freq
+			case "desc":
+				s.Desc = st.Desc
+			case "homepage":
+				s.HomepageUrl = st.HomepageUrl
+			case "archives":
+				s.ArchivesUrl = st.ArchivesUrl
+			case "liveStream":
+				s.LiveStreamUrl = st.LiveStreamUrl
+			case "timeZone":
+				s.TimeZone = st.TimeZone
+			}
+		})
+		must(s.Validate())
+		must(station.Update(db, s))
+		fmt.Println("Saved", s.String())
+		must(db.Close())
+	} else if action == "station-delete" {
+		if st.CallLetters == "" {
+			fmt.Println("callLetters required")
+			flag.Usage()
+			return
+		}
+
+		must(db.Open())
+		s := mustGetStation(db, st.CallLetters)
+		must(station.Delete(db, s.Id))
+		fmt.Println("Deleted", s.String())
+		must(db.Close())
+	} else if action == "backup" {
+		if backupDir == "" {
+			fmt.Println("backupDir required")
+			flag.Usage()
+			return
+		}
+
+		// safe while the server is running
+		must(db.Open())
+		path, err := db.Backup(context.Background(), backupDir, keep)
+		must(err)
+		fmt.Println("Backup written to", path)
+		must(db.Close())
+	} else if action == "restore" {
+		if backupFile == "" {
+			fmt.Println("backupFile required")
+			flag.Usage()
+			return
+		}
+
+		fmt.Println("Stop the server before restoring.")
+		must(database.Restore(backupFile, dbFile))
+		fmt.Printf("Restored %s from %s, the previous file is %s.replaced\n", dbFile, backupFile, dbFile)
 	} else {
 		fmt.Printf("Unknown action value %q\n", action)
 		flag.Usage()
 	}
 }
+
+// must stops the program when a database call fails.
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// mustGetUser stops the program when there is no user with the email.
+func mustGetUser(db database.Runner, email string) *user.User {
+	u, err := user.GetByEmail(db, email)
+	must(err)
+	if u == nil {
+		panic(fmt.Errorf("user not found: %s", email))
+	}
+	return u
+}
+
+// mustGetStation stops the program when there is no station with the call letters.
+func mustGetStation(db database.Runner, callLetters string) *station.Station {
+	s, err := station.GetByCallLetters(db, callLetters)
+	must(err)
+	if s == nil {
+		panic(fmt.Errorf("station not found: %s", callLetters))
+	}
+	return s
+}
+
+// cliActor names who made a change in the audit table.
+func cliActor() string {
+	return fmt.Sprintf("cmd/db (%s)", os.Getenv("USER"))
+}
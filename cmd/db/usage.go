@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+)
+
+// recomputeUsage measures the files of every tape below userDir, saves the
+// sizes that drifted and then every user's total.  It returns the number
+// of tapes and users changed.
+func recomputeUsage(db *database.Database, userDir string) (int, int, error) {
+	tapes, err := tape.GetAll(db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	changed := 0
+	for _, t := range tapes {
+		// never look outside the user directory
+		if t.FsPath == "" || !filepath.IsLocal(t.FsPath) {
+			continue
+		}
+
+		size := file.DirSize(filepath.Join(userDir, t.FsPath))
+		if size == t.Size {
+			continue
+		}
+
+		fmt.Printf("%v was %d bytes, is %d\n", t, t.Size, size)
+		err = tape.SetSize(db, t.Id, size)
+		if err != nil {
+			return changed, 0, err
+		}
+		changed++
+	}
+
+	users, err := user.RecomputeUsage(db)
+	return changed, users, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+)
+
+// deleteUser removes u and everything they own.  When to is set their
+// tapes and audio files are given to that user instead of being deleted.
+// Files are moved while the transaction is open and moved back when it
+// fails, so the database and userDir stay in step.
+func deleteUser(db *database.Database, userDir string, u *user.User, to *user.User) error {
+	undo := []func(){}
+	move := func(from string, dest string) error {
+		err := os.MkdirAll(filepath.Dir(dest), 0o750)
+		if err == nil {
+			err = os.Rename(from, dest)
+		}
+		if os.IsNotExist(err) {
+			// nothing was captured yet
+			return nil
+		}
+		if err == nil {
+			undo = append(undo, func() {
+				if err := os.Rename(dest, from); err != nil {
+					log.Println("could not move back", dest, err)
+				}
+			})
+		}
+		return err
+	}
+
+	trash := filepath.Join(userDir, ".deleted-"+u.Uuid)
+	err := db.WithTx(context.Background(), func(tx *database.Tx) error {
+		if to != nil {
+			// the trash too, ReassignTapes gives those away as well
+			tapes, err := tape.GetAllForUser(tx, u.Id)
+			if err != nil {
+				return err
+			}
+
+			err = user.ReassignTapes(tx, u, to)
+			if err != nil {
+				return err
+			}
+
+			for _, t := range tapes {
+				err = move(filepath.Join(userDir, t.FsPath), filepath.Join(userDir, to.Uuid, filepath.Base(t.FsPath)))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err := user.Delete(tx, u.Id)
+		if err != nil {
+			return err
+		}
+
+		// removed once committed, a rename can be undone
+		return move(filepath.Join(userDir, u.Uuid), trash)
+	})
+
+	if err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return err
+	}
+
+	err = os.RemoveAll(trash)
+	if err != nil {
+		return fmt.Errorf("deleted %v but not the files in %s: %w", u, trash, err)
+	}
+
+	return nil
+}
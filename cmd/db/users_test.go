@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+const dbPath = "./unit-test.db"
+
+// setup returns a user who is leaving, the heir to their tapes and a
+// station to record from.
+func setup(t *testing.T) (*database.Database, *user.User, *user.User, *station.Station) {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"leaving@example.com", "heir@example.com"} {
+		err = user.Insert(db, user.New(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, mustGetUser(db, "leaving@example.com"), mustGetUser(db, "heir@example.com"), &s
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+func TestDeleteUserGivesAwayTrash(t *testing.T) {
+	db, from, to, s := setup(t)
+
+	userDir := t.TempDir()
+	tp := tape.New(from.Id, from.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err == nil {
+		_, err = tape.Trash(db, tp.Id)
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Join(userDir, tp.FsPath), 0o750)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(userDir, tp.FsPath, "a.mp3"), []byte("mp3"), 0o640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = deleteUser(db, userDir, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := tape.GetTape(tp.Id, db)
+	if err != nil || moved == nil || moved.UserId != to.Id {
+		t.Fatalf("expected the trashed tape to belong to the heir, actual %v %v", moved, err)
+	}
+	if _, err := os.Stat(filepath.Join(userDir, moved.FsPath, "a.mp3")); err != nil {
+		t.Fatalf("expected the trashed tape's audio to move, actual %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"time"
+)
+
+// maxAdminJobs is the most queued tapes shown on the admin page.
+const maxAdminJobs = 200
+
+// makeAdminOnly creates a [middleware] function that lets only users with
+// the admin role past.  It must come after [makeUserLookup].
+func makeAdminOnly(tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			if !u.IsAdmin() {
+				log.Printf("%v is not an admin\n", u)
+				writeForbidden(w, r, tmplEngine, "This page is for administrators.")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// makeBodyLimit creates a [middleware] function that fails reading request
+// bodies larger than limit.  It must come before anything that parses a form.
+func makeBodyLimit(limit int64) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// recordAdmin writes an audit record of a change admin made to userId's
+// account, in the transaction of the change.
+func recordAdmin(tx database.Runner, admin *user.User, userId int64, action string, detail string) error {
+	r := audit.New(userId, admin.Email, action, detail)
+	return audit.Insert(tx, &r)
+}
+
+// adminUser is a row of the users table on the admin page.
+type adminUser struct {
+	*user.User
+	Tapes int
+	Size  string
+}
+
+// adminJob is a row of the job queue on the admin page.
+type adminJob struct {
+	*tape.Tape
+	Owner string
+}
+
+// adminPage is the data for admin.html.
+type adminPage struct {
+	Csrf  string
+	Me    *user.User
+	Users []adminUser
+	Jobs  []adminJob
+	// Planned is the dry run of the retention rules, what the next prune
+	// deletes.
+	Planned []prunedTape
+	// TotalSize is the space used by every user's tapes.
+	TotalSize string
+	Message   string
+	Error     string
+}
+
+// makeAdminHandler shows the users with their storage use and the job
+// queue, and handles the forms to change a user's status or role and to
+// retry a failed tape.
+func makeAdminHandler(db *database.Database, quota int64, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeAdminHandler", r.URL.String())
+			defer log.Println("exit MakeAdminHandler")
+
+			admin := getUserFromRequest(w, r)
+			if admin == nil {
+				return
+			}
+
+			page := adminPage{Csrf: csrfToken(r), Me: admin}
+
+			if r.Method == http.MethodPost {
+				id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+				if err == nil {
+					switch r.PostFormValue("action") {
+					case "status":
+						err = changeOwnAccount(admin, id)
+						if err == nil {
+							err = user.SetStatus(db, id, r.PostFormValue("status"), admin.Email)
+						}
+					case "role":
+						err = changeOwnAccount(admin, id)
+						if err == nil {
+							err = user.SetRole(db, id, r.PostFormValue("role"), admin.Email)
+						}
+					case "retry":
+						err = retryJob(db, admin, r, id, quota)
+					default:
+						http.Error(w, "unknown action", http.StatusBadRequest)
+						return
+					}
+				}
+
+				if err != nil {
+					page.Error = err.Error()
+				} else {
+					page.Message = "Saved"
+				}
+			}
+
+			err := loadAdminPage(db, &page)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			bytes, evalErr := tmplEngine.eval("admin.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// changeOwnAccount keeps admins from disabling or demoting themselves,
+// so there is always one admin left to undo a mistake.
+func changeOwnAccount(admin *user.User, id int64) error {
+	if admin.Id == id {
+		return fmt.Errorf("you cannot change your own status or role")
+	}
+	return nil
+}
+
+// retryJob puts the failed tape back in the queue on behalf of admin,
+// unless its owner has used up their quota.
+func retryJob(db *database.Database, admin *user.User, r *http.Request, id int64, quota int64) error {
+	return db.WithTx(r.Context(), func(tx *database.Tx) error {
+		t, err := tape.GetTape(id, tx)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("tape %d not found", id)
+		}
+
+		owner, err := user.GetById(tx, t.UserId)
+		if err != nil {
+			return err
+		}
+		if owner != nil {
+			if err := checkQuota(owner, quota); err != nil {
+				return fmt.Errorf("%s: %w", owner.Email, err)
+			}
+		}
+
+		retried, err := tape.Retry(tx, id)
+		if err != nil {
+			return err
+		}
+		if !retried {
+			return fmt.Errorf("%v is %s, only failed tapes can be retried", t, t.Status)
+		}
+
+		return recordAdmin(tx, admin, t.UserId, audit.ActionJob, fmt.Sprintf("retried %v", t))
+	})
+}
+
+// loadAdminPage fills in the users, their storage use, the job queue and
+// what the next prune deletes.  The storage use is what the tapes' sizes
+// add up to, fsck compares it with the disk.
+func loadAdminPage(db *database.Database, page *adminPage) error {
+	users, err := user.GetAll(db)
+	if err != nil {
+		return err
+	}
+
+	emails := make(map[int64]string, len(users))
+	var total int64
+	for _, u := range users {
+		emails[u.Id] = u.Email
+		total += u.BytesUsed
+
+		tapes, err := tape.GetTapesForUser(u.Id, db)
+		if err != nil {
+			return err
+		}
+
+		page.Users = append(page.Users, adminUser{
+			User:  u,
+			Tapes: len(tapes),
+			Size:  formatBytes(u.BytesUsed),
+		})
+	}
+
+	jobs, err := tape.GetQueue(db, database.Page{Limit: maxAdminJobs})
+	if err != nil {
+		return err
+	}
+	for _, t := range jobs {
+		page.Jobs = append(page.Jobs, adminJob{Tape: t, Owner: emails[t.UserId]})
+	}
+
+	page.Planned, err = planPrune(db, users, time.Now())
+	if err != nil {
+		return err
+	}
+
+	page.TotalSize = formatBytes(total)
+	return nil
+}
+
+// formatBytes shows a size the way du -h does.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+)
+
+// makeAdmin gives the user the admin role.
+func makeAdmin(t *testing.T, db *database.Database, email string) *user.User {
+	u, err := user.GetByEmail(db, email)
+	if err == nil {
+		err = user.SetRole(db, u.Id, user.RoleAdmin, "unit test")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// postForm posts the url encoded form as testEmail.
+func postForm(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-EMAIL", testEmail)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// postStation posts the station form as testEmail with an optional logo.
+func postStation(t *testing.T, handler http.HandlerFunc, fields map[string]string, logo []byte) *httptest.ResponseRecorder {
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if logo != nil {
+		part, err := form.CreateFormFile("logo", "logo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(logo)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/s/admin/stations", body)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-EMAIL", testEmail)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestStationsAdmin(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &authSettings{trust: trust}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only admins get in
+	adminOnly := chain(makeUserLookup(db, auth, tmplEngine), makeAdminOnly(tmplEngine), okHandler)
+	w := httptest.NewRecorder()
+	adminOnly.ServeHTTP(w, newRequest("127.0.0.1:5000", testEmail))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("non admin: expected %d, actual %d", http.StatusForbidden, w.Code)
+	}
+
+	// station changes through the API too
+	mux := http.NewServeMux()
+	registerApiRoutes(mux, db, auth, userDir, 0)
+	r := httptest.NewRequest(http.MethodPost, apiPrefix+"/stations", strings.NewReader(`{"callLetters": "WMBR", "freq": "88.1", "homepageUrl": "https://wmbr.org"}`))
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-EMAIL", testEmail)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("non admin api: expected %d json, actual %d %q", http.StatusForbidden, w.Code, w.Header().Get("Content-Type"))
+	}
+
+	makeAdmin(t, db, testEmail)
+
+	w = httptest.NewRecorder()
+	adminOnly.ServeHTTP(w, newRequest("127.0.0.1:5000", testEmail))
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin: expected %d, actual %d", http.StatusOK, w.Code)
+	}
+
+	handler := chain(makeUserLookup(db, auth, tmplEngine), makeAdminOnly(tmplEngine), makeStationsAdminHandler(db, userDir, tmplEngine))
+
+	logo := new(bytes.Buffer)
+	err = png.Encode(logo, image.NewGray(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]string{
+		"action":      "save",
+		"callLetters": "WMBR",
+		"freq":        "88.1",
+		"homepageUrl": "https://wmbr.org",
+		"timeZone":    "America/New_York",
+	}
+
+	w = postStation(t, handler, fields, []byte("GIF87 is what this pretends to be"))
+	if !strings.Contains(w.Body.String(), "logo must be") {
+		t.Fatalf("expected text logo to be rejected, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = postStation(t, handler, fields, logo.Bytes())
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Saved WMBR") {
+		t.Fatalf("save: expected %d, actual %d %q", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	s, err := station.GetByCallLetters(db, "WMBR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || s.TimeZone != "America/New_York" || filepath.Ext(s.LogoPath) != ".png" {
+		t.Fatalf("unexpected station %+v", s)
+	}
+
+	if _, err := os.Stat(filepath.Join(userDir, s.LogoPath)); err != nil {
+		t.Fatalf("logo not written: %v", err)
+	}
+
+	r = newRequest("127.0.0.1:5000", testEmail)
+	r.SetPathValue("id", fmt.Sprint(s.Id))
+	w = httptest.NewRecorder()
+	chain(makeUserLookup(db, auth, tmplEngine), makeStationLogoHandler(db, userDir)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("logo: expected png, actual %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestAdminPage(t *testing.T) {
+	db := setupDb(t)
+	admin := makeAdmin(t, db, testEmail)
+
+	err := user.Insert(db, user.New("listener@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := user.GetByEmail(db, "listener@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := tape.New(listener.Id, listener.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	failed.Status = tape.StatusError
+	err = tape.Insert(db, &failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tape.SetSize(db, failed.Id, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := chain(makeUserLookup(db, &authSettings{trust: trust}, tmplEngine), makeAdminOnly(tmplEngine), makeAdminHandler(db, 0, tmplEngine))
+
+	w := postForm(handler, "/s/admin", url.Values{"action": {"status"}, "id": {fmt.Sprint(admin.Id)}, "status": {user.StatusDisabled}})
+	if !strings.Contains(w.Body.String(), "cannot change your own") {
+		t.Fatalf("expected admin to be stopped disabling themselves, actual %q", w.Body.String())
+	}
+
+	w = postForm(handler, "/s/admin", url.Values{"action": {"status"}, "id": {fmt.Sprint(listener.Id)}, "status": {user.StatusDisabled}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "2.0 KiB") {
+		t.Fatalf("disable: expected page with storage use, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = postForm(handler, "/s/admin", url.Values{"action": {"retry"}, "id": {fmt.Sprint(failed.Id)}})
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `class="error"`) {
+		t.Fatalf("retry: expected success, actual %d %q", w.Code, w.Body.String())
+	}
+
+	retried, err := tape.GetTape(failed.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != tape.StatusTodo {
+		t.Fatalf("expected retried tape %s, actual %s", tape.StatusTodo, retried.Status)
+	}
+
+	// disabled and retried, each by the admin
+	records, err := audit.GetForUser(db, listener.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Actor != testEmail || records[1].Action != audit.ActionJob {
+		t.Fatalf("unexpected audit records %v", records)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:           "0 B",
+		1023:        "1023 B",
+		2048:        "2.0 KiB",
+		5 << 20:     "5.0 MiB",
+		3 << 30 / 2: "1.5 GiB",
+	}
+
+	for size, expected := range tests {
+		if actual := formatBytes(size); actual != expected {
+			t.Errorf("%d: expected %q, actual %q", size, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,983 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/token"
+	"tapedeck/internal/database/user"
+)
+
+// apiPrefix is the root of version 1 of the JSON API.
+const apiPrefix = "/s/api/v1"
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+	maxApiBodyBytes  = 1 << 20
+)
+
+// apiError is the body of every API response with a 4xx or 5xx status.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiPage is the body of every API list response.  Next is the
+// URL of the following page and is omitted on the last page.
+type apiPage[T any] struct {
+	Items  []T    `json:"items"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Next   string `json:"next,omitempty"`
+}
+
+// registerApiRoutes adds the JSON API routes to the mux.  quota is the
+// server's storage quota in bytes.
+func registerApiRoutes(mux *http.ServeMux, db *database.Database, auth *authSettings, userDir string, quota int64) {
+	api := func(m middleware) http.HandlerFunc {
+		return chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, nil), m)
+	}
+	admin := func(m middleware) http.HandlerFunc {
+		return chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, nil), makeAdminOnly(nil), m)
+	}
+
+	mux.HandleFunc("GET "+apiPrefix+"/tapes", api(makeApiListTapes(db)))
+	mux.HandleFunc("POST "+apiPrefix+"/tapes", api(makeApiCreateTape(db, quota)))
+	mux.HandleFunc("GET "+apiPrefix+"/tapes/{id}", api(makeApiGetTape(db)))
+	mux.HandleFunc("PUT "+apiPrefix+"/tapes/{id}", api(makeApiUpdateTape(db)))
+	mux.HandleFunc("DELETE "+apiPrefix+"/tapes/{id}", api(makeApiDeleteTape(db)))
+
+	mux.HandleFunc("GET "+apiPrefix+"/tapes/{id}/sources", api(makeApiListSources(db)))
+	mux.HandleFunc("POST "+apiPrefix+"/tapes/{id}/sources", api(makeApiCreateSource(db)))
+	mux.HandleFunc("GET "+apiPrefix+"/tapes/{id}/sources/{sourceId}", api(makeApiGetSource(db)))
+	mux.HandleFunc("PUT "+apiPrefix+"/tapes/{id}/sources/{sourceId}", api(makeApiUpdateSource(db)))
+	mux.HandleFunc("DELETE "+apiPrefix+"/tapes/{id}/sources/{sourceId}", api(makeApiDeleteSource(db)))
+
+	mux.HandleFunc("GET "+apiPrefix+"/tapes/{id}/tracks", api(makeApiListTracks(db)))
+	mux.HandleFunc("PUT "+apiPrefix+"/tapes/{id}/tracks", api(makeApiSetTracks(db)))
+
+	mux.HandleFunc("GET "+apiPrefix+"/stations", api(makeApiListStations(db)))
+	mux.HandleFunc("POST "+apiPrefix+"/stations", admin(makeApiCreateStation(db)))
+	mux.HandleFunc("GET "+apiPrefix+"/stations/{id}", api(makeApiGetStation(db)))
+	mux.HandleFunc("PUT "+apiPrefix+"/stations/{id}", admin(makeApiUpdateStation(db)))
+	mux.HandleFunc("DELETE "+apiPrefix+"/stations/{id}", admin(makeApiDeleteStation(db, userDir)))
+
+	mux.HandleFunc("GET "+apiPrefix+"/tokens", api(makeApiListTokens(db)))
+	mux.HandleFunc("POST "+apiPrefix+"/tokens", api(makeApiCreateToken(db)))
+	mux.HandleFunc("DELETE "+apiPrefix+"/tokens/{id}", api(makeApiRevokeToken(db)))
+
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeApiError(w, http.StatusNotFound, "no API route for %s %s", r.Method, r.URL.Path)
+	})
+}
+
+func writeApiError(w http.ResponseWriter, status int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Println("api error", status, msg)
+
+	bytes, err := json.Marshal(apiError{Error: apiErrorDetail{Status: status, Message: msg}})
+	if err != nil {
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// etagFor returns a strong entity tag for the JSON representation of v.
+func etagFor(v any) (string, []byte, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum := sha256.Sum256(bytes)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, bytes, nil
+}
+
+// etagMatches reports whether etag is in the comma separated header value.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeApiJson writes v with an ETag header.  A GET whose If-None-Match
+// header matches the ETag gets a 304 without a body.
+func writeApiJson(w http.ResponseWriter, r *http.Request, status int, v any) {
+	etag, bytes, err := etagFor(v)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, "failed to encode response: %v", err)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+
+	inm := r.Header.Get("If-None-Match")
+	if inm != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// checkIfMatch enforces the If-Match precondition against the current
+// representation of a resource.  It writes a 412 and returns false
+// when the client's copy is stale.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current any) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+
+	etag, _, err := etagFor(current)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, "failed to encode resource: %v", err)
+		return false
+	}
+
+	if !etagMatches(ifMatch, etag) {
+		writeApiError(w, http.StatusPreconditionFailed, "resource has changed, current ETag is %s", etag)
+		return false
+	}
+	return true
+}
+
+// decodeApiBody reads the JSON request body into v.
+func decodeApiBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApiBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid JSON body: %v", err)
+		return false
+	}
+	return true
+}
+
+// parsePage reads the limit and offset query parameters.
+func parsePage(w http.ResponseWriter, r *http.Request) (database.Page, bool) {
+	page := database.Page{Limit: defaultPageLimit}
+	params := r.URL.Query()
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			writeApiError(w, http.StatusBadRequest, "limit must be a number from 1 to %d", maxPageLimit)
+			return page, false
+		}
+		page.Limit = limit
+	}
+
+	if v := params.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeApiError(w, http.StatusBadRequest, "offset must be a positive number")
+			return page, false
+		}
+		page.Offset = offset
+	}
+
+	return page, true
+}
+
+// lookahead returns page with room for one extra row, which
+// [newApiPage] uses to decide if there is a next page.
+func lookahead(page database.Page) database.Page {
+	return database.Page{Limit: page.Limit + 1, Offset: page.Offset}
+}
+
+func newApiPage[T any](r *http.Request, items []T, page database.Page) apiPage[T] {
+	result := apiPage[T]{Items: items, Limit: page.Limit, Offset: page.Offset}
+
+	if len(items) > page.Limit {
+		result.Items = items[:page.Limit]
+
+		params := url.Values{}
+		params.Set("limit", strconv.Itoa(page.Limit))
+		params.Set("offset", strconv.Itoa(page.Offset+page.Limit))
+		result.Next = r.URL.Path + "?" + params.Encode()
+	}
+
+	return result
+}
+
+func parsePathId(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "%s %q is not a number", name, r.PathValue(name))
+		return 0, false
+	}
+	return id, true
+}
+
+func getApiUser(w http.ResponseWriter, r *http.Request) *user.User {
+	u, ok := r.Context().Value(userKey).(*user.User)
+	if !ok {
+		writeApiError(w, http.StatusInternalServerError, "user not found in context")
+		return nil
+	}
+	return u
+}
+
+// loadApiTape returns the tape named by the id path value when the
+// current user has at least the access asked for.  Tapes the user may
+// not see and tapes in the trash are reported as not found.
+func loadApiTape(w http.ResponseWriter, r *http.Request, db *database.Database, need tape.Access) (*user.User, *tape.Tape, bool) {
+	u := getApiUser(w, r)
+	if u == nil {
+		return nil, nil, false
+	}
+
+	id := r.PathValue("id")
+	t, err := tape.GetForUser(db, id, u.Id, need)
+	if err == nil && t.Deleted != "" {
+		err = tape.ErrNotFound
+	}
+
+	if errors.Is(err, tape.ErrNotFound) {
+		writeApiError(w, http.StatusNotFound, "tape %q not found", id)
+		return nil, nil, false
+	}
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, "failed to get tape: %v", err)
+		return nil, nil, false
+	}
+
+	return u, t, true
+}
+
+// checkApiStation verifies the station referenced by a tape exists.
+func checkApiStation(w http.ResponseWriter, db *database.Database, stationId int64) bool {
+	s, err := station.Get(db, stationId)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, "failed to get station: %v", err)
+		return false
+	}
+
+	if s == nil {
+		writeApiError(w, http.StatusBadRequest, "station %d not found", stationId)
+		return false
+	}
+	return true
+}
+
+func makeApiListTapes(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiListTapes", r.URL.String())
+			defer log.Println("exit ApiListTapes")
+
+			u := getApiUser(w, r)
+			if u == nil {
+				return
+			}
+
+			page, ok := parsePage(w, r)
+			if !ok {
+				return
+			}
+
+			tapes, err := tape.GetTapesForUserPage(u.Id, lookahead(page), db)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get tapes: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, newApiPage(r, tapes, page))
+		}
+	}
+}
+
+func makeApiGetTape(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiGetTape", r.URL.String())
+			defer log.Println("exit ApiGetTape")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessRead)
+			if !ok {
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, t)
+		}
+	}
+}
+
+// apiTapeCreate is the body of a create tape request.  The sources are
+// optional and saved in the same transaction as the tape.
+type apiTapeCreate struct {
+	tape.Tape
+	Sources []tape.TapeSource `json:"sources"`
+}
+
+func makeApiCreateTape(db *database.Database, quota int64) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiCreateTape", r.URL.String())
+			defer log.Println("exit ApiCreateTape")
+
+			u := getApiUser(w, r)
+			if u == nil {
+				return
+			}
+
+			var in apiTapeCreate
+			if !decodeApiBody(w, r, &in) {
+				return
+			}
+
+			if err := checkQuota(u, quota); err != nil {
+				writeApiError(w, http.StatusInsufficientStorage, "%v", err)
+				return
+			}
+
+			t := tape.New(u.Id, u.Uuid, in.StationId, in.Title, in.AirDate)
+			t.Desc = in.Desc
+
+			if err := t.Validate(); err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid tape: %v", err)
+				return
+			}
+
+			for i := range in.Sources {
+				in.Sources[i].Id = 0
+				if err := in.Sources[i].Validate(); err != nil {
+					writeApiError(w, http.StatusBadRequest, "invalid source %d: %v", i, err)
+					return
+				}
+			}
+
+			if !checkApiStation(w, db, t.StationId) {
+				return
+			}
+
+			if err := tape.Create(db, &t, in.Sources); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to create tape: %v", err)
+				return
+			}
+
+			created, err := tape.GetTape(t.Id, db)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get tape: %v", err)
+				return
+			}
+
+			w.Header().Set("Location", fmt.Sprintf("%s/tapes/%s", apiPrefix, t.PublicId))
+			writeApiJson(w, r, http.StatusCreated, created)
+		}
+	}
+}
+
+func makeApiUpdateTape(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiUpdateTape", r.URL.String())
+			defer log.Println("exit ApiUpdateTape")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessWrite)
+			if !ok {
+				return
+			}
+
+			if !checkIfMatch(w, r, t) {
+				return
+			}
+
+			var in tape.Tape
+			if !decodeApiBody(w, r, &in) {
+				return
+			}
+
+			t.Title = in.Title
+			t.Desc = in.Desc
+			t.StationId = in.StationId
+			t.AirDate = in.AirDate
+
+			if err := t.Validate(); err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid tape: %v", err)
+				return
+			}
+
+			if !checkApiStation(w, db, t.StationId) {
+				return
+			}
+
+			if err := tape.Update(db, t); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to update tape: %v", err)
+				return
+			}
+
+			updated, err := tape.GetTape(t.Id, db)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get tape: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, updated)
+		}
+	}
+}
+
+func makeApiDeleteTape(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiDeleteTape", r.URL.String())
+			defer log.Println("exit ApiDeleteTape")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessOwner)
+			if !ok {
+				return
+			}
+
+			if !checkIfMatch(w, r, t) {
+				return
+			}
+
+			// the files go with it once its time in the trash is up
+			if _, err := tape.Trash(db, t.Id); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to delete tape: %v", err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// loadApiSource returns the source named by the sourceId path value
+// of a tape the current user has at least the access asked for.
+func loadApiSource(w http.ResponseWriter, r *http.Request, db *database.Database, need tape.Access) (*tape.TapeSource, bool) {
+	_, t, ok := loadApiTape(w, r, db, need)
+	if !ok {
+		return nil, false
+	}
+
+	id, ok := parsePathId(w, r, "sourceId")
+	if !ok {
+		return nil, false
+	}
+
+	s, err := tape.GetSource(db, t.Id, id)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, "failed to get source: %v", err)
+		return nil, false
+	}
+
+	if s == nil {
+		writeApiError(w, http.StatusNotFound, "source %d not found", id)
+		return nil, false
+	}
+
+	return s, true
+}
+
+func makeApiListSources(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiListSources", r.URL.String())
+			defer log.Println("exit ApiListSources")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessRead)
+			if !ok {
+				return
+			}
+
+			sources, err := tape.GetSources(db, t.Id)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get sources: %v", err)
+				return
+			}
+
+			// A tape has a handful of sources so they are not paginated.
+			writeApiJson(w, r, http.StatusOK, newApiPage(r, sources, database.Page{Limit: len(sources)}))
+		}
+	}
+}
+
+func makeApiListTracks(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiListTracks", r.URL.String())
+			defer log.Println("exit ApiListTracks")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessRead)
+			if !ok {
+				return
+			}
+
+			tracks, err := tape.GetTracks(db, t.Id)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get tracks: %v", err)
+				return
+			}
+
+			// The playlist is read and written whole so it is not paginated.
+			writeApiJson(w, r, http.StatusOK, newApiPage(r, tracks, database.Page{Limit: len(tracks)}))
+		}
+	}
+}
+
+// makeApiSetTracks replaces the tape's playlist with the list of
+// tracks in the body, in order.
+func makeApiSetTracks(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiSetTracks", r.URL.String())
+			defer log.Println("exit ApiSetTracks")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessWrite)
+			if !ok {
+				return
+			}
+
+			var tracks []tape.Track
+			if !decodeApiBody(w, r, &tracks) {
+				return
+			}
+
+			for i := range tracks {
+				if err := tracks[i].Validate(); err != nil {
+					writeApiError(w, http.StatusBadRequest, "invalid track %d: %v", i+1, err)
+					return
+				}
+			}
+
+			if err := tape.SetTracks(db, t.Id, tracks); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to set tracks: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, newApiPage(r, tracks, database.Page{Limit: len(tracks)}))
+		}
+	}
+}
+
+func makeApiGetSource(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiGetSource", r.URL.String())
+			defer log.Println("exit ApiGetSource")
+
+			s, ok := loadApiSource(w, r, db, tape.AccessRead)
+			if !ok {
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, s)
+		}
+	}
+}
+
+func makeApiCreateSource(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiCreateSource", r.URL.String())
+			defer log.Println("exit ApiCreateSource")
+
+			_, t, ok := loadApiTape(w, r, db, tape.AccessWrite)
+			if !ok {
+				return
+			}
+
+			var s tape.TapeSource
+			if !decodeApiBody(w, r, &s) {
+				return
+			}
+
+			s.Id = 0
+			s.TapeId = t.Id
+
+			if err := s.Validate(); err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid source: %v", err)
+				return
+			}
+
+			if err := tape.InsertSource(db, &s); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to create source: %v", err)
+				return
+			}
+
+			w.Header().Set("Location", fmt.Sprintf("%s/tapes/%s/sources/%d", apiPrefix, t.PublicId, s.Id))
+			writeApiJson(w, r, http.StatusCreated, s)
+		}
+	}
+}
+
+func makeApiUpdateSource(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiUpdateSource", r.URL.String())
+			defer log.Println("exit ApiUpdateSource")
+
+			s, ok := loadApiSource(w, r, db, tape.AccessWrite)
+			if !ok {
+				return
+			}
+
+			if !checkIfMatch(w, r, s) {
+				return
+			}
+
+			var in tape.TapeSource
+			if !decodeApiBody(w, r, &in) {
+				return
+			}
+
+			in.Id = s.Id
+			in.TapeId = s.TapeId
+			in.DateCreated = s.DateCreated
+			if in.Seq == 0 {
+				in.Seq = s.Seq
+			}
+
+			if err := in.Validate(); err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid source: %v", err)
+				return
+			}
+
+			if err := tape.UpdateSource(db, &in); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to update source: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, in)
+		}
+	}
+}
+
+func makeApiDeleteSource(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiDeleteSource", r.URL.String())
+			defer log.Println("exit ApiDeleteSource")
+
+			s, ok := loadApiSource(w, r, db, tape.AccessWrite)
+			if !ok {
+				return
+			}
+
+			if !checkIfMatch(w, r, s) {
+				return
+			}
+
+			if err := tape.DeleteSource(db, s.TapeId, s.Id); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to delete source: %v", err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func loadApiStation(w http.ResponseWriter, r *http.Request, db *database.Database) (*station.Station, bool) {
+	id, ok := parsePathId(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+
+	s, err := station.Get(db, id)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, "failed to get station: %v", err)
+		return nil, false
+	}
+
+	if s == nil {
+		writeApiError(w, http.StatusNotFound, "station %d not found", id)
+		return nil, false
+	}
+
+	return s, true
+}
+
+func makeApiListStations(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiListStations", r.URL.String())
+			defer log.Println("exit ApiListStations")
+
+			page, ok := parsePage(w, r)
+			if !ok {
+				return
+			}
+
+			stations, err := station.GetAll(db, lookahead(page))
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get stations: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, newApiPage(r, stations, page))
+		}
+	}
+}
+
+func makeApiGetStation(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiGetStation", r.URL.String())
+			defer log.Println("exit ApiGetStation")
+
+			s, ok := loadApiStation(w, r, db)
+			if !ok {
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, s)
+		}
+	}
+}
+
+func makeApiCreateStation(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiCreateStation", r.URL.String())
+			defer log.Println("exit ApiCreateStation")
+
+			var s station.Station
+			if !decodeApiBody(w, r, &s) {
+				return
+			}
+
+			s.Id = 0
+			if err := s.Validate(); err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid station: %v", err)
+				return
+			}
+
+			admin := getUserFromRequest(w, r)
+			if admin == nil {
+				return
+			}
+
+			err := db.WithTx(r.Context(), func(tx *database.Tx) error {
+				if err := station.Insert(tx, &s); err != nil {
+					return err
+				}
+				return recordAdmin(tx, admin, admin.Id, audit.ActionStation, fmt.Sprintf("saved %v", &s))
+			})
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to create station: %v", err)
+				return
+			}
+
+			w.Header().Set("Location", fmt.Sprintf("%s/stations/%d", apiPrefix, s.Id))
+			writeApiJson(w, r, http.StatusCreated, s)
+		}
+	}
+}
+
+func makeApiUpdateStation(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiUpdateStation", r.URL.String())
+			defer log.Println("exit ApiUpdateStation")
+
+			s, ok := loadApiStation(w, r, db)
+			if !ok {
+				return
+			}
+
+			if !checkIfMatch(w, r, s) {
+				return
+			}
+
+			var in station.Station
+			if !decodeApiBody(w, r, &in) {
+				return
+			}
+
+			in.Id = s.Id
+			if err := in.Validate(); err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid station: %v", err)
+				return
+			}
+
+			admin := getUserFromRequest(w, r)
+			if admin == nil {
+				return
+			}
+
+			err := db.WithTx(r.Context(), func(tx *database.Tx) error {
+				if err := station.Update(tx, &in); err != nil {
+					return err
+				}
+				return recordAdmin(tx, admin, admin.Id, audit.ActionStation, fmt.Sprintf("saved %v", &in))
+			})
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to update station: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, in)
+		}
+	}
+}
+
+func makeApiDeleteStation(db *database.Database, userDir string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiDeleteStation", r.URL.String())
+			defer log.Println("exit ApiDeleteStation")
+
+			s, ok := loadApiStation(w, r, db)
+			if !ok {
+				return
+			}
+
+			if !checkIfMatch(w, r, s) {
+				return
+			}
+
+			admin := getUserFromRequest(w, r)
+			if admin == nil {
+				return
+			}
+
+			err := deleteStation(r.Context(), db, userDir, admin, s.Id)
+			if errors.Is(err, station.ErrInUse) {
+				writeApiError(w, http.StatusConflict, "%v", err)
+				return
+			}
+
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to delete station: %v", err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// apiNewToken is the response to creating a token.  This is the
+// only time the secret is available.
+type apiNewToken struct {
+	Token  *token.Token `json:"token"`
+	Secret string       `json:"secret"`
+}
+
+// checkNotToken rejects requests that were authenticated by an API token.
+// Tokens are managed from a browser session so a leaked token cannot
+// be used to mint more tokens.
+func checkNotToken(w http.ResponseWriter, r *http.Request) bool {
+	if r.Context().Value(tokenKey) != nil {
+		writeApiError(w, http.StatusForbidden, "API tokens cannot manage API tokens")
+		return false
+	}
+	return true
+}
+
+func makeApiListTokens(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiListTokens", r.URL.String())
+			defer log.Println("exit ApiListTokens")
+
+			u := getApiUser(w, r)
+			if u == nil || !checkNotToken(w, r) {
+				return
+			}
+
+			tokens, err := token.GetForUser(db, u.Id)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to get tokens: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusOK, newApiPage(r, tokens, database.Page{Limit: len(tokens)}))
+		}
+	}
+}
+
+func makeApiCreateToken(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiCreateToken", r.URL.String())
+			defer log.Println("exit ApiCreateToken")
+
+			u := getApiUser(w, r)
+			if u == nil || !checkNotToken(w, r) {
+				return
+			}
+
+			var in token.Token
+			if !decodeApiBody(w, r, &in) {
+				return
+			}
+
+			t, secret, err := token.New(u.Id, in.Name, in.Scope)
+			if err != nil {
+				writeApiError(w, http.StatusBadRequest, "invalid token: %v", err)
+				return
+			}
+
+			if err := token.Insert(db, &t); err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to create token: %v", err)
+				return
+			}
+
+			writeApiJson(w, r, http.StatusCreated, apiNewToken{Token: &t, Secret: secret})
+		}
+	}
+}
+
+func makeApiRevokeToken(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter ApiRevokeToken", r.URL.String())
+			defer log.Println("exit ApiRevokeToken")
+
+			u := getApiUser(w, r)
+			if u == nil || !checkNotToken(w, r) {
+				return
+			}
+
+			id, ok := parsePathId(w, r, "id")
+			if !ok {
+				return
+			}
+
+			revoked, err := token.Revoke(db, u.Id, id)
+			if err != nil {
+				writeApiError(w, http.StatusInternalServerError, "failed to revoke token: %v", err)
+				return
+			}
+
+			if !revoked {
+				writeApiError(w, http.StatusNotFound, "token %d not found", id)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
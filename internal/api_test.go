@@ -0,0 +1,246 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/token"
+	"tapedeck/internal/database/user"
+	"testing"
+)
+
+// setupApi returns the API routes, three tapes of the test user and a
+// tape of another user.
+func setupApi(t *testing.T) (*database.Database, *http.ServeMux, []*tape.Tape, *tape.Tape) {
+	db := setupDb(t)
+
+	err := user.Insert(db, user.New("listener@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(email string, title string) *tape.Tape {
+		t.Helper()
+		u, err := user.GetByEmail(db, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp := tape.New(u.Id, u.Uuid, s.Id, title, "2026-10-17")
+		err = tape.Insert(db, &tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tp
+	}
+
+	tapes := []*tape.Tape{}
+	for _, title := range []string{"Late Risers Club", "Breakfast of Champions", "Lost and Found"} {
+		tapes = append(tapes, insert(testEmail, title))
+	}
+	other := insert("listener@example.com", "Jazz Train")
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	registerApiRoutes(mux, db, &authSettings{trust: trust}, t.TempDir(), 0)
+	return db, mux, tapes, other
+}
+
+// serveApi sends a request for the test user through the mux.  The
+// headers are name, value pairs.
+func serveApi(mux *http.ServeMux, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, apiPrefix+path, strings.NewReader(body))
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-EMAIL", testEmail)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// decodeApi reads the JSON response body into v.
+func decodeApi(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("%v: %q", err, w.Body.String())
+	}
+}
+
+// checkApiError verifies w is a JSON error with the status.
+func checkApiError(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected %d, actual %d %q", status, w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON error, actual %q", w.Header().Get("Content-Type"))
+	}
+
+	var body apiError
+	decodeApi(t, w, &body)
+	if body.Error.Status != status || body.Error.Message == "" {
+		t.Fatalf("expected error with status %d, actual %+v", status, body)
+	}
+}
+
+func TestApiPages(t *testing.T) {
+	_, mux, _, _ := setupApi(t)
+
+	path := "/tapes?limit=2"
+	titles := []string{}
+	for pages := 0; path != ""; pages++ {
+		if pages > 2 {
+			t.Fatalf("next cursor never ends, last %q", path)
+		}
+
+		w := serveApi(mux, http.MethodGet, path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %d, actual %d %q", path, http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var page apiPage[tape.Tape]
+		decodeApi(t, w, &page)
+		for _, tp := range page.Items {
+			titles = append(titles, tp.Title)
+		}
+
+		path = strings.TrimPrefix(page.Next, apiPrefix)
+		if page.Next != "" && path == page.Next {
+			t.Fatalf("next %q is not an API path", page.Next)
+		}
+	}
+
+	if len(titles) != 3 {
+		t.Fatalf("expected the 3 tapes across pages, actual %v", titles)
+	}
+
+	checkApiError(t, serveApi(mux, http.MethodGet, "/tapes?limit=0", ""), http.StatusBadRequest)
+	checkApiError(t, serveApi(mux, http.MethodGet, "/nothing", ""), http.StatusNotFound)
+}
+
+func TestApiEtags(t *testing.T) {
+	_, mux, tapes, _ := setupApi(t)
+	path := "/tapes/" + tapes[0].PublicId
+
+	w := serveApi(mux, http.MethodGet, path, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected %d with an ETag, actual %d %q", http.StatusOK, w.Code, etag)
+	}
+
+	w = serveApi(mux, http.MethodGet, path, "", "If-None-Match", etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected %d without a body, actual %d %q", http.StatusNotModified, w.Code, w.Body.String())
+	}
+
+	update := `{"title": "Late Risers Club Live", "stationId": 1, "airDate": "2026-10-17"}`
+	w = serveApi(mux, http.MethodPut, path, update, "If-Match", etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, actual %d %q", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// the etag went stale with the update
+	checkApiError(t, serveApi(mux, http.MethodPut, path, update, "If-Match", etag), http.StatusPreconditionFailed)
+	checkApiError(t, serveApi(mux, http.MethodDelete, path, "", "If-Match", etag), http.StatusPreconditionFailed)
+
+	w = serveApi(mux, http.MethodGet, path, "", "If-None-Match", etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, actual %d", http.StatusOK, w.Code)
+	}
+
+	w = serveApi(mux, http.MethodDelete, path, "", "If-Match", w.Header().Get("ETag"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, actual %d %q", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	checkApiError(t, serveApi(mux, http.MethodGet, path, ""), http.StatusNotFound)
+}
+
+func TestApiOtherUsersTape(t *testing.T) {
+	_, mux, _, other := setupApi(t)
+	path := "/tapes/" + other.PublicId
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, path, ""},
+		{http.MethodPut, path, `{"title": "Mine now", "stationId": 1, "airDate": "2026-10-17"}`},
+		{http.MethodDelete, path, ""},
+		{http.MethodGet, path + "/sources", ""},
+		{http.MethodGet, path + "/tracks", ""},
+		{http.MethodPut, path + "/tracks", `[]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			checkApiError(t, serveApi(mux, test.method, test.path, test.body), http.StatusNotFound)
+		})
+	}
+}
+
+func TestApiTokens(t *testing.T) {
+	_, mux, _, _ := setupApi(t)
+
+	checkApiError(t, serveApi(mux, http.MethodPost, "/tokens", `{"name": "laptop", "scope": "everything"}`), http.StatusBadRequest)
+
+	w := serveApi(mux, http.MethodPost, "/tokens", `{"name": "laptop", "scope": "read"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, actual %d %q", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var created apiNewToken
+	decodeApi(t, w, &created)
+	if created.Secret == "" || created.Token.Id == 0 {
+		t.Fatalf("expected a token and its secret, actual %q", w.Body.String())
+	}
+	bearer := "Bearer " + created.Secret
+
+	w = serveApi(mux, http.MethodGet, "/tokens", "")
+	var listed apiPage[token.Token]
+	decodeApi(t, w, &listed)
+	if len(listed.Items) != 1 || listed.Items[0].Name != "laptop" || strings.Contains(w.Body.String(), created.Secret) {
+		t.Fatalf("expected the token without its secret, actual %q", w.Body.String())
+	}
+
+	w = serveApi(mux, http.MethodGet, "/tapes", "", "Authorization", bearer)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d with the token, actual %d", http.StatusOK, w.Code)
+	}
+
+	w = serveApi(mux, http.MethodPost, "/tapes", `{"title": "Jazz Train", "stationId": 1, "airDate": "2026-10-17"}`, "Authorization", bearer)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected %d for a read token, actual %d", http.StatusForbidden, w.Code)
+	}
+
+	checkApiError(t, serveApi(mux, http.MethodGet, "/tokens", "", "Authorization", bearer), http.StatusForbidden)
+
+	revoke := "/tokens/" + strconv.FormatInt(created.Token.Id, 10)
+	w = serveApi(mux, http.MethodDelete, revoke, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, actual %d %q", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	checkApiError(t, serveApi(mux, http.MethodDelete, revoke, ""), http.StatusNotFound)
+
+	w = serveApi(mux, http.MethodGet, "/tapes", "", "Authorization", bearer)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d with a revoked token, actual %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+)
+
+// audioTypes are the content types of the audio files served from a
+// tape's directory, by extension.  Other files are never served.
+var audioTypes = map[string]string{
+	".mp3":  tape.ContentTypeMp3,
+	".aac":  "audio/aac",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+}
+
+// audioFile is one file of a tape's recording.
+type audioFile struct {
+	Name string
+	Size int64
+	Type string
+}
+
+// tapeAudio returns the audio files in the tape's directory in name
+// order, which is the order they are played in.  A tape with no
+// directory yet has no files.
+func tapeAudio(userDir string, t *tape.Tape) ([]audioFile, error) {
+	if t.FsPath == "" || !filepath.IsLocal(t.FsPath) {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(userDir, t.FsPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := []audioFile{}
+	for _, entry := range entries {
+		contentType, ok := audioTypes[strings.ToLower(filepath.Ext(entry.Name()))]
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, audioFile{Name: entry.Name(), Size: info.Size(), Type: contentType})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// audioUrls returns the URL of each file below prefix, in order.
+func audioUrls(prefix string, files []audioFile) []string {
+	urls := make([]string, 0, len(files))
+	for _, f := range files {
+		urls = append(urls, prefix+url.PathEscape(f.Name))
+	}
+	return urls
+}
+
+// serveAudio writes the named audio file of the tape, with support for
+// range requests so players can seek.  Only names listed by [tapeAudio]
+// are served.
+func serveAudio(w http.ResponseWriter, r *http.Request, userDir string, t *tape.Tape, name string) {
+	files, err := tapeAudio(userDir, t)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	for _, f := range files {
+		if f.Name == name {
+			w.Header().Set("Content-Type", f.Type)
+			http.ServeFile(w, r, filepath.Join(userDir, t.FsPath, f.Name))
+			return
+		}
+	}
+
+	log.Printf("no audio file %q in %v\n", name, t)
+	http.NotFound(w, r)
+}
+
+// makeAudioHandler serves the audio files of a tape the user may play.
+func makeAudioHandler(db *database.Database, userDir string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeAudioHandler", r.URL.String())
+			defer log.Println("exit MakeAudioHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			t := getTapeForUser(w, r, db, u, r.PathValue("id"), tape.AccessRead, false)
+			if t == nil {
+				return
+			}
+
+			serveAudio(w, r, userDir, t, r.PathValue("file"))
+		}
+	}
+}
+
+// partTitle names file i of the n files of a tape's recording.
+func partTitle(t *tape.Tape, i int, n int) string {
+	if n == 1 {
+		return t.Title
+	}
+	return fmt.Sprintf("%s (%d of %d)", t.Title, i+1, n)
+}
+
+// playerTrack is one entry of the playlist of the audio player.
+type playerTrack struct {
+	Title string
+	Url   string
+}
+
+// buildPlaylist lists the audio files of the tapes in order, for playing
+// one after the other.  prefix returns the URL path of a tape's files.
+func buildPlaylist(userDir string, tapes []*tape.Tape, prefix func(t *tape.Tape) string) ([]playerTrack, error) {
+	tracks := []playerTrack{}
+	for _, t := range tapes {
+		files, err := tapeAudio(userDir, t)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, u := range audioUrls(prefix(t), files) {
+			tracks = append(tracks, playerTrack{Title: partTitle(t, i, len(files)), Url: u})
+		}
+	}
+	return tracks, nil
+}
+
+// ownAudioPrefix is where a signed in user plays a tape's files.
+func ownAudioPrefix(t *tape.Tape) string {
+	return "/s/audio/" + url.PathEscape(t.PublicId) + "/"
+}
@@ -0,0 +1,337 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/session"
+	"tapedeck/internal/database/user"
+	"time"
+)
+
+const (
+	// authModeProxy trusts the X-EMAIL header set by nginx and oauth2-proxy.
+	authModeProxy = "proxy"
+	// authModeBuiltin signs users in with a password and a session cookie.
+	authModeBuiltin = "builtin"
+)
+
+const (
+	sessionCookieName = "tapedeck_session"
+	// loginCsrfCookieName holds the CSRF token of the sign in form, which
+	// is shown before there is a session.
+	loginCsrfCookieName = "tapedeck_login_csrf"
+	// csrfField is the form field holding the session's CSRF token.
+	csrfField = "csrf"
+	// csrfHeader holds the session's CSRF token for API requests.
+	csrfHeader = "X-CSRF-Token"
+
+	defaultSessionHours = 24 * 14
+)
+
+// sessionKey holds the [session.Session] of requests authenticated by a session cookie.
+const sessionKey contextKey = "session"
+
+// authSettings controls how [makeUserLookup] authenticates requests.
+type authSettings struct {
+	trust        *proxyTrust
+	builtin      bool
+	secureCookie bool
+	sessionTtl   time.Duration
+}
+
+func (a *authSettings) String() string {
+	return fmt.Sprintf("auth builtin %v secure cookie %v session ttl %v, %v", a.builtin, a.secureCookie, a.sessionTtl, a.trust)
+}
+
+func newAuthSettings(config ServerConfig, trust *proxyTrust) (*authSettings, error) {
+	auth := &authSettings{
+		trust:        trust,
+		secureCookie: config.ProductionMode || config.TlsCertFile != "",
+		sessionTtl:   time.Duration(defaultSessionHours) * time.Hour,
+	}
+
+	switch config.AuthMode {
+	case "", authModeProxy:
+	case authModeBuiltin:
+		auth.builtin = true
+	default:
+		return nil, fmt.Errorf("invalid auth mode %q, expected %q or %q", config.AuthMode, authModeProxy, authModeBuiltin)
+	}
+
+	if config.SessionHours > 0 {
+		auth.sessionTtl = time.Duration(config.SessionHours) * time.Hour
+	}
+
+	return auth, nil
+}
+
+// isSafeMethod reports whether the method only reads.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// lookupSessionUser finds the user of the session cookie and checks the CSRF token
+// of state changing requests.  It returns the status to respond with when the request
+// is not allowed.
+func lookupSessionUser(db *database.Database, r *http.Request) (*session.Session, *user.User, int) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		log.Printf("session cookie not set\n")
+		return nil, nil, http.StatusUnauthorized
+	}
+
+	s, err := session.Lookup(db, cookie.Value)
+	if err != nil {
+		log.Printf("error getting session: %v\n", err)
+		return nil, nil, http.StatusInternalServerError
+	}
+
+	if s == nil {
+		log.Printf("session not found or expired\n")
+		return nil, nil, http.StatusUnauthorized
+	}
+
+	if !isSafeMethod(r.Method) {
+		given := r.Header.Get(csrfHeader)
+		if given == "" {
+			given = r.PostFormValue(csrfField)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.Csrf)) != 1 {
+			log.Printf("CSRF token missing or wrong for %v\n", s)
+			return nil, nil, http.StatusForbidden
+		}
+	}
+
+	u, err := user.GetById(db, s.UserId)
+	if err != nil {
+		log.Printf("error getting user object: %v\n", err)
+		return nil, nil, http.StatusInternalServerError
+	}
+
+	if u == nil {
+		log.Printf("user object not found for %v\n", s)
+		return nil, nil, http.StatusUnauthorized
+	}
+
+	return s, u, http.StatusOK
+}
+
+// csrfToken returns the CSRF token templates must include in forms.
+// It is empty unless the request was authenticated by a session cookie.
+func csrfToken(r *http.Request) string {
+	s, ok := r.Context().Value(sessionKey).(*session.Session)
+	if !ok {
+		return ""
+	}
+	return s.Csrf
+}
+
+// safeRedirect returns next when it is a local path, otherwise fallback.
+func safeRedirect(next string, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
+
+// loginPage is the data for login.html.
+type loginPage struct {
+	Csrf  string
+	Email string
+	Next  string
+	Error string
+}
+
+// loginCsrf returns the CSRF token of the sign in form, the one in the
+// login cookie or a new one set in that cookie.  The form must send it
+// back so another site cannot sign the browser in to an account of its
+// choosing.
+func loginCsrf(w http.ResponseWriter, r *http.Request, auth *authSettings) (string, error) {
+	cookie, err := r.Cookie(loginCsrfCookieName)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	csrf, err := session.NewCsrf()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCsrfCookieName,
+		Value:    csrf,
+		Path:     "/login",
+		HttpOnly: true,
+		Secure:   auth.secureCookie,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrf, nil
+}
+
+// checkLoginCsrf reports whether the sign in form sent the token of the
+// login cookie.
+func checkLoginCsrf(r *http.Request) bool {
+	cookie, err := r.Cookie(loginCsrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.PostFormValue(csrfField)), []byte(cookie.Value)) == 1
+}
+
+// makeLoginHandler shows the sign in form and starts a session for valid credentials.
+func makeLoginHandler(db *database.Database, auth *authSettings, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeLoginHandler", r.URL.Path)
+			defer log.Println("exit MakeLoginHandler")
+
+			page := loginPage{Next: safeRedirect(r.FormValue("next"), "/s/list")}
+
+			if !auth.builtin {
+				// oauth2-proxy signs the user in on the way to any secure page.
+				http.Redirect(w, r, page.Next, http.StatusSeeOther)
+				return
+			}
+
+			csrf, err := loginCsrf(w, r, auth)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Csrf = csrf
+
+			if r.Method == http.MethodPost && !checkLoginCsrf(r) {
+				log.Printf("sign in CSRF token missing or wrong for %q\n", r.PostFormValue("email"))
+				page.Email = r.PostFormValue("email")
+				page.Error = "The sign in form expired, please try again."
+				w.WriteHeader(http.StatusForbidden)
+			} else if r.Method == http.MethodPost {
+				page.Email = r.PostFormValue("email")
+
+				u, err := user.GetByEmail(db, page.Email)
+				if err != nil {
+					http.Error(w, err.Error(), 500)
+					return
+				}
+
+				// one comparison, also without a user, so the time taken
+				// does not tell which emails have accounts
+				valid := u.CheckPassword(r.PostFormValue("password"))
+
+				if valid && !u.Enabled() {
+					log.Printf("sign in refused for %v, it is %s\n", u, u.Status)
+					writeForbidden(w, r, tmplEngine, "Your account is disabled.")
+					return
+				}
+
+				if valid {
+					s, secret, err := session.New(u.Id, auth.sessionTtl)
+					if err == nil {
+						err = session.Insert(db, &s)
+					}
+					if err != nil {
+						http.Error(w, err.Error(), 500)
+						return
+					}
+
+					err = session.DeleteExpired(db)
+					if err != nil {
+						log.Println("failed to delete expired sessions", err)
+					}
+
+					http.SetCookie(w, &http.Cookie{
+						Name:     sessionCookieName,
+						Value:    secret,
+						Path:     "/",
+						Expires:  time.Now().Add(auth.sessionTtl),
+						HttpOnly: true,
+						Secure:   auth.secureCookie,
+						SameSite: http.SameSiteLaxMode,
+					})
+					http.SetCookie(w, &http.Cookie{
+						Name:     loginCsrfCookieName,
+						Path:     "/login",
+						MaxAge:   -1,
+						HttpOnly: true,
+						Secure:   auth.secureCookie,
+						SameSite: http.SameSiteStrictMode,
+					})
+
+					log.Println("signed in", u, s.String())
+					http.Redirect(w, r, page.Next, http.StatusSeeOther)
+					return
+				}
+
+				log.Printf("sign in failed for %q\n", page.Email)
+				page.Error = "Invalid email or password."
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+
+			bytes, evalErr := tmplEngine.eval("login.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// logoutPage is the data for logout.html.
+type logoutPage struct {
+	Csrf string
+}
+
+// makeLogoutHandler asks to confirm signing out and ends the session.
+func makeLogoutHandler(db *database.Database, auth *authSettings, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeLogoutHandler", r.URL.Path)
+			defer log.Println("exit MakeLogoutHandler")
+
+			if !auth.builtin {
+				http.Redirect(w, r, "/oauth2/sign_out", http.StatusSeeOther)
+				return
+			}
+
+			if r.Method == http.MethodPost {
+				cookie, err := r.Cookie(sessionCookieName)
+				if err == nil {
+					err = session.Delete(db, cookie.Value)
+					if err != nil {
+						http.Error(w, err.Error(), 500)
+						return
+					}
+				}
+
+				http.SetCookie(w, &http.Cookie{
+					Name:     sessionCookieName,
+					Path:     "/",
+					MaxAge:   -1,
+					HttpOnly: true,
+					Secure:   auth.secureCookie,
+					SameSite: http.SameSiteLaxMode,
+				})
+
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+
+			bytes, evalErr := tmplEngine.eval("logout.html", logoutPage{Csrf: csrfToken(r)})
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
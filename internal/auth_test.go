@@ -0,0 +1,281 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/user"
+	"testing"
+	"time"
+)
+
+const testPassword = "correct horse battery"
+
+// findCookie returns the cookie w sets, nil when it sets none.
+func findCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// postLogin posts the login form with the login cookie, when there is one,
+// and the csrf token.
+func postLogin(t *testing.T, db *database.Database, auth *authSettings, password string, login *http.Cookie, csrf string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {testEmail}, "password": {password}, csrfField: {csrf}}
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if login != nil {
+		r.AddCookie(login)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	chain(makeLoginHandler(db, auth, tmplEngine)).ServeHTTP(w, r)
+	return w
+}
+
+// getLogin shows the login form and returns the login cookie it sets.
+func getLogin(t *testing.T, db *database.Database, auth *authSettings) *http.Cookie {
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	chain(makeLoginHandler(db, auth, tmplEngine)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	login := findCookie(w, loginCsrfCookieName)
+	if w.Code != http.StatusOK || login == nil || !strings.Contains(w.Body.String(), login.Value) {
+		t.Fatalf("expected the form with the login cookie's token, actual %d %v", w.Code, login)
+	}
+	return login
+}
+
+// signIn shows the login form, posts it back and returns the session cookie.
+func signIn(t *testing.T, db *database.Database, auth *authSettings, password string) *http.Cookie {
+	login := getLogin(t, db, auth)
+	w := postLogin(t, db, auth, password, login, login.Value)
+
+	if password != testPassword && w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: expected %d, actual %d", http.StatusUnauthorized, w.Code)
+	}
+
+	return findCookie(w, sessionCookieName)
+}
+
+func setupBuiltin(t *testing.T) (*database.Database, *authSettings) {
+	db := setupDb(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.SetPassword(db, u.Id, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, &authSettings{trust: trust, builtin: true, sessionTtl: time.Hour}
+}
+
+func TestBuiltinLogin(t *testing.T) {
+	db, auth := setupBuiltin(t)
+
+	if signIn(t, db, auth, "wrong password") != nil {
+		t.Fatalf("session created for wrong password")
+	}
+
+	// unknown emails are checked against a hash too, and never match it
+	var nobody *user.User
+	if nobody.CheckPassword(testPassword) || (&user.User{}).CheckPassword("") {
+		t.Fatalf("expected no password to match without a hash")
+	}
+
+	cookie := signIn(t, db, auth, testPassword)
+	if cookie == nil {
+		t.Fatalf("session cookie not set")
+	}
+
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("session cookie not protected: %v", cookie)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/s/list", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	chain(makeUserLookup(db, auth, nil), okHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signed in request: expected %d, actual %d", http.StatusOK, w.Code)
+	}
+
+	// X-EMAIL is ignored in builtin mode
+	r = newRequest("127.0.0.1:5000", testEmail)
+	w = httptest.NewRecorder()
+	chain(makeUserLookup(db, auth, nil), okHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/login") {
+		t.Fatalf("anonymous request: expected redirect to login, actual %d %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestLoginCsrf(t *testing.T) {
+	db, auth := setupBuiltin(t)
+
+	login := getLogin(t, db, auth)
+	if !login.HttpOnly || login.SameSite != http.SameSiteStrictMode || login.Path != "/login" {
+		t.Fatalf("login cookie not protected: %v", login)
+	}
+
+	// another site can post the form but cannot read the cookie
+	tests := []struct {
+		name  string
+		login *http.Cookie
+		csrf  string
+	}{
+		{"no cookie", nil, login.Value},
+		{"no token", login, ""},
+		{"wrong token", login, "forged"},
+	}
+	for _, test := range tests {
+		w := postLogin(t, db, auth, testPassword, test.login, test.csrf)
+		if w.Code != http.StatusForbidden || findCookie(w, sessionCookieName) != nil {
+			t.Fatalf("%s: expected %d without a session, actual %d", test.name, http.StatusForbidden, w.Code)
+		}
+	}
+
+	w := postLogin(t, db, auth, testPassword, login, login.Value)
+	if w.Code != http.StatusSeeOther || findCookie(w, sessionCookieName) == nil {
+		t.Fatalf("expected a session, actual %d", w.Code)
+	}
+	if cleared := findCookie(w, loginCsrfCookieName); cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("expected the login cookie cleared, actual %v", cleared)
+	}
+}
+
+func TestBuiltinCsrf(t *testing.T) {
+	db, auth := setupBuiltin(t)
+
+	cookie := signIn(t, db, auth, testPassword)
+	if cookie == nil {
+		t.Fatalf("session cookie not set")
+	}
+
+	var csrf string
+	r := httptest.NewRequest(http.MethodGet, "/s/tokens", nil)
+	r.AddCookie(cookie)
+	chain(makeUserLookup(db, auth, nil), func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			csrf = csrfToken(r)
+		}
+	}).ServeHTTP(httptest.NewRecorder(), r)
+
+	if csrf == "" {
+		t.Fatalf("csrf token not available to handlers")
+	}
+
+	post := func(token string) int {
+		form := url.Values{csrfField: {token}}
+		r := httptest.NewRequest(http.MethodPost, "/s/tokens", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		chain(makeUserLookup(db, auth, nil), okHandler).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if status := post(""); status != http.StatusForbidden {
+		t.Fatalf("post without csrf: expected %d, actual %d", http.StatusForbidden, status)
+	}
+
+	if status := post("forged"); status != http.StatusForbidden {
+		t.Fatalf("post with wrong csrf: expected %d, actual %d", http.StatusForbidden, status)
+	}
+
+	if status := post(csrf); status != http.StatusOK {
+		t.Fatalf("post with csrf: expected %d, actual %d", http.StatusOK, status)
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := map[string]string{
+		"/s/tokens":           "/s/tokens",
+		"":                    "/s/list",
+		"https://evil.com":    "/s/list",
+		"//evil.com/s/tokens": "/s/list",
+		"/\\evil.com":         "/s/list",
+	}
+
+	for next, expected := range tests {
+		if actual := safeRedirect(next, "/s/list"); actual != expected {
+			t.Errorf("%q: expected %q, actual %q", next, expected, actual)
+		}
+	}
+}
+
+func TestDisabledUser(t *testing.T) {
+	db, auth := setupBuiltin(t)
+
+	cookie := signIn(t, db, auth, testPassword)
+	if cookie == nil {
+		t.Fatalf("session cookie not set")
+	}
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.SetStatus(db, u.Id, user.StatusDisabled, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the existing session no longer works
+	r := httptest.NewRequest(http.MethodGet, "/s/list", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	chain(makeUserLookup(db, auth, tmplEngine), okHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "disabled") {
+		t.Fatalf("disabled session: expected %d page, actual %d %q", http.StatusForbidden, w.Code, w.Body.String())
+	}
+
+	if signIn(t, db, auth, testPassword) != nil {
+		t.Fatalf("session created for disabled user")
+	}
+
+	// nor does the proxy header
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := lookupStatus(t, db, trust, newRequest("127.0.0.1:5000", testEmail)); status != http.StatusForbidden {
+		t.Fatalf("disabled header user: expected %d, actual %d", http.StatusForbidden, status)
+	}
+
+	err = user.SetStatus(db, u.Id, user.StatusEnabled, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := lookupStatus(t, db, trust, newRequest("127.0.0.1:5000", testEmail)); status != http.StatusOK {
+		t.Fatalf("enabled header user: expected %d, actual %d", http.StatusOK, status)
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"log"
+	"tapedeck/internal/database"
+	"time"
+)
+
+// defaultBackupKeep is how many scheduled backups are kept when the config
+// does not say, two weeks of daily backups.
+const defaultBackupKeep = 14
+
+// startBackups backs up the database to dir every interval until ctx is
+// done.  The returned channel is closed once the last backup has finished.
+func startBackups(ctx context.Context, db *database.Database, dir string, interval time.Duration, keep int) <-chan struct{} {
+	log.Println("scheduled backups to", dir, "every", interval, "keeping", keep)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				path, err := db.Backup(ctx, dir, keep)
+				if err != nil {
+					log.Println("scheduled backup failed:", err)
+				} else {
+					log.Println("scheduled backup written to", path)
+				}
+			}
+		}
+	}()
+
+	return done
+}
@@ -0,0 +1,421 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+)
+
+// collectionsPage is the data for collections.html.
+type collectionsPage struct {
+	Csrf        string
+	Collections []*collection.Collection
+	Shared      []*collection.Collection
+	Error       string
+}
+
+// makeCollectionsHandler lists the user's collections and creates new ones.
+func makeCollectionsHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeCollectionsHandler", r.URL.String())
+			defer log.Println("exit MakeCollectionsHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			page := collectionsPage{Csrf: csrfToken(r)}
+
+			if r.Method == http.MethodPost {
+				c := collection.New(u.Id, strings.TrimSpace(r.PostFormValue("name")), strings.TrimSpace(r.PostFormValue("desc")))
+				err := c.Validate()
+				if err == nil {
+					err = collection.Insert(db, &c)
+				}
+				if err == nil {
+					http.Redirect(w, r, "/s/collection?id="+url.QueryEscape(c.PublicId), http.StatusSeeOther)
+					return
+				}
+
+				page.Error = err.Error()
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			collections, err := collection.GetForUser(db, u.Id)
+			if err == nil {
+				page.Shared, err = collection.GetSharedWith(db, u.Id)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Collections = collections
+
+			bytes, evalErr := tmplEngine.eval("collections.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// collectionPage is the data for collection.html.
+type collectionPage struct {
+	Csrf       string
+	Collection *collection.Collection
+	// Access is what the user may do, the page only offers that.
+	Access tape.Access
+	Tapes  []*tape.Tape
+	// Others are the user's tapes that can still be added.
+	Others   []*tape.Tape
+	Playlist []playerTrack
+	ShareUrl string
+	FeedUrl  string
+	Error    string
+}
+
+// makeCollectionHandler shows a collection with its player and changes it.
+// Each change is a POST with an action, after which the browser is sent
+// back to the collection.  Users it was shared with see it too, and those
+// who may edit it can change everything but its tapes and sharing.
+func makeCollectionHandler(db *database.Database, userDir string, trust *proxyTrust, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeCollectionHandler", r.URL.String())
+			defer log.Println("exit MakeCollectionHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			c, err := collection.Get(db, r.FormValue("id"), u.Id, tape.AccessRead)
+			var access tape.Access
+			if err == nil {
+				access, err = collection.AccessFor(db, c, u.Id)
+			}
+			if errors.Is(err, collection.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			page := collectionPage{Csrf: csrfToken(r), Collection: c, Access: access}
+			self := "/s/collection?id=" + url.QueryEscape(c.PublicId)
+
+			if r.Method == http.MethodPost {
+				action := r.PostFormValue("action")
+				need, ok := collectionActions[action]
+				if !ok {
+					http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+					return
+				}
+				if access < need {
+					log.Printf("user %d has access %d to %v, %q needs %d\n", u.Id, access, c, action, need)
+					http.NotFound(w, r)
+					return
+				}
+
+				if action == "delete" {
+					err = collection.Delete(db, c.Id)
+					if err != nil {
+						http.Error(w, err.Error(), 500)
+						return
+					}
+					http.Redirect(w, r, "/s/collections", http.StatusSeeOther)
+					return
+				}
+
+				err = changeCollection(w, r, db, u, c, action)
+				if err == nil {
+					http.Redirect(w, r, self, http.StatusSeeOther)
+					return
+				}
+				if errors.Is(err, tape.ErrNotFound) {
+					http.NotFound(w, r)
+					return
+				}
+
+				page.Error = err.Error()
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			page.Tapes, err = tape.GetInCollection(db, c.Id)
+			if err == nil && access == tape.AccessOwner {
+				page.Others, err = tapesNotIn(db, u.Id, page.Tapes)
+			}
+			if err == nil {
+				page.Playlist, err = buildPlaylist(userDir, page.Tapes, ownAudioPrefix)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			if c.ShareToken != "" && access == tape.AccessOwner {
+				page.ShareUrl = baseUrl(r, trust) + sharePath(c.ShareToken)
+				page.FeedUrl = page.ShareUrl + "/feed.xml"
+			}
+
+			bytes, evalErr := tmplEngine.eval("collection.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// collectionActions is the access each action of the collection page needs.
+// Editors change the details and order, only the owner which tapes are in
+// it.
+var collectionActions = map[string]tape.Access{
+	"save":      tape.AccessWrite,
+	"up":        tape.AccessWrite,
+	"down":      tape.AccessWrite,
+	"remove":    tape.AccessOwner,
+	"add":       tape.AccessOwner,
+	"retention": tape.AccessOwner,
+	"share":     tape.AccessOwner,
+	"unshare":   tape.AccessOwner,
+	"delete":    tape.AccessOwner,
+}
+
+// changeCollection carries out one of the collection page's actions, which
+// [collectionActions] has already checked.  Editors may save the details
+// and move any tape in the collection.  The owner may also add their own
+// tapes, remove any tape, and change the retention, sharing or delete it.
+func changeCollection(w http.ResponseWriter, r *http.Request, db *database.Database, u *user.User, c *collection.Collection, action string) error {
+	switch action {
+	case "save":
+		c.Name = strings.TrimSpace(r.PostFormValue("name"))
+		c.Desc = strings.TrimSpace(r.PostFormValue("desc"))
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		return collection.Update(db, c)
+	case "share":
+		_, err := collection.Share(db, c.Id)
+		return err
+	case "unshare":
+		return collection.Unshare(db, c.Id)
+	case "retention":
+		rule, err := parseRetention(r)
+		if err != nil {
+			return err
+		}
+		return collection.SetRetention(db, c.Id, rule)
+	}
+
+	need := tape.AccessRead
+	if action == "add" {
+		need = tape.AccessOwner
+	}
+	t, err := tape.GetForUser(db, r.PostFormValue("tape"), u.Id, need)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "add":
+		return collection.AddTape(db, c.Id, t.Id)
+	case "remove":
+		return collection.RemoveTape(db, c.Id, t.Id)
+	case "up":
+		return collection.MoveTape(db, c.Id, t.Id, -1)
+	case "down":
+		return collection.MoveTape(db, c.Id, t.Id, 1)
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+// tapesNotIn returns the user's tapes outside the trash that are not
+// among tapes.
+func tapesNotIn(db *database.Database, userId int64, tapes []*tape.Tape) ([]*tape.Tape, error) {
+	all, err := tape.GetTapesForUser(userId, db)
+	if err != nil {
+		return nil, err
+	}
+
+	in := map[int64]bool{}
+	for _, t := range tapes {
+		in[t.Id] = true
+	}
+
+	others := make([]*tape.Tape, 0, len(all))
+	for _, t := range all {
+		if !in[t.Id] {
+			others = append(others, t)
+		}
+	}
+	return others, nil
+}
+
+// sharePath is the path of a shared collection's public page.
+func sharePath(token string) string {
+	return "/share/" + url.PathEscape(token)
+}
+
+// getSharedCollection returns the collection shared with the token in the
+// path and its tapes.  Collections of owners who may no longer use their
+// tapes are not found, just like unknown tokens.
+func getSharedCollection(w http.ResponseWriter, r *http.Request, db *database.Database) (*collection.Collection, []*tape.Tape) {
+	c, err := collection.GetByShareToken(db, r.PathValue("token"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return nil, nil
+	}
+	if c == nil {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+
+	owner, err := user.GetById(db, c.UserId)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return nil, nil
+	}
+	if owner == nil || !owner.Enabled() {
+		log.Println("owner of shared", c, "is not enabled")
+		http.NotFound(w, r)
+		return nil, nil
+	}
+
+	tapes, err := tape.GetInCollection(db, c.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return nil, nil
+	}
+
+	// keep shared links out of search engines
+	w.Header().Set("X-Robots-Tag", "noindex")
+	return c, tapes
+}
+
+// sharedAudioPrefix returns where anyone with the share link plays a
+// tape's files.
+func sharedAudioPrefix(token string) func(t *tape.Tape) string {
+	return func(t *tape.Tape) string {
+		return sharePath(token) + "/audio/" + url.PathEscape(t.PublicId) + "/"
+	}
+}
+
+// sharePage is the data for share.html.
+type sharePage struct {
+	Collection *collection.Collection
+	Tapes      []*tape.Tape
+	Playlist   []playerTrack
+	FeedUrl    string
+}
+
+// makeShareHandler shows a shared collection with its player to anyone
+// with the link, without signing in.
+func makeShareHandler(db *database.Database, userDir string, trust *proxyTrust, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeShareHandler")
+			defer log.Println("exit MakeShareHandler")
+
+			c, tapes := getSharedCollection(w, r, db)
+			if c == nil {
+				return
+			}
+
+			playlist, err := buildPlaylist(userDir, tapes, sharedAudioPrefix(c.ShareToken))
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			page := sharePage{
+				Collection: c,
+				Tapes:      tapes,
+				Playlist:   playlist,
+				FeedUrl:    baseUrl(r, trust) + sharePath(c.ShareToken) + "/feed.xml",
+			}
+
+			bytes, evalErr := tmplEngine.eval("share.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// makeShareFeedHandler writes the RSS feed of a shared collection, with
+// an episode for each audio file in the collection's order.
+func makeShareFeedHandler(db *database.Database, userDir string, trust *proxyTrust) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeShareFeedHandler")
+			defer log.Println("exit MakeShareFeedHandler")
+
+			c, tapes := getSharedCollection(w, r, db)
+			if c == nil {
+				return
+			}
+
+			base := baseUrl(r, trust)
+			prefix := sharedAudioPrefix(c.ShareToken)
+			feed := newFeed(c.Name, base+sharePath(c.ShareToken), c.Desc)
+			for _, t := range tapes {
+				files, err := tapeAudio(userDir, t)
+				if err != nil {
+					http.Error(w, err.Error(), 500)
+					return
+				}
+				feed.addTape(t, base+prefix(t), files)
+			}
+
+			writeFeed(w, feed)
+		}
+	}
+}
+
+// makeShareAudioHandler serves the audio files of the tapes in a shared
+// collection.  Tapes outside the collection are not found.
+func makeShareAudioHandler(db *database.Database, userDir string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeShareAudioHandler")
+			defer log.Println("exit MakeShareAudioHandler")
+
+			c, tapes := getSharedCollection(w, r, db)
+			if c == nil {
+				return
+			}
+
+			for _, t := range tapes {
+				if t.PublicId == r.PathValue("id") {
+					serveAudio(w, r, userDir, t, r.PathValue("file"))
+					return
+				}
+			}
+
+			http.NotFound(w, r)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+)
+
+func TestCollectionPages(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	second := tape.New(u.Id, u.Uuid, s.Id, "Breakfast of Champions", "2026-10-18")
+	for _, tp := range []*tape.Tape{&first, &second} {
+		err = tape.Insert(db, tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		audio := filepath.Join(userDir, tp.FsPath, "part-1.mp3")
+		err = os.MkdirAll(filepath.Dir(audio), 0o750)
+		if err == nil {
+			err = os.WriteFile(audio, []byte("mp3 of "+tp.Title), 0o640)
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(userDir, tp.FsPath, "notes.txt"), []byte("private"), 0o640)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a tape of someone else
+	err = user.Insert(db, user.New("listener@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := user.GetByEmail(db, "listener@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs := tape.New(other.Id, other.Uuid, s.Id, "Hillbilly at Harvard", "2026-10-18")
+	err = tape.Insert(db, &theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := makeUserLookup(db, &authSettings{trust: trust}, tmplEngine)
+	collections := chain(lookup, makeCollectionsHandler(db, tmplEngine))
+	page := chain(lookup, makeCollectionHandler(db, userDir, trust, tmplEngine))
+
+	w := postForm(collections, "/s/collections", url.Values{"name": {""}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "name required") {
+		t.Fatalf("create: expected name required, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = postForm(collections, "/s/collections", url.Values{"name": {"Saturday jazz 2026"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("create: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	created, err := collection.GetForUser(db, u.Id)
+	if err != nil || len(created) != 1 {
+		t.Fatalf("expected one collection, actual %v %v", created, err)
+	}
+	id := created[0].PublicId
+
+	for _, tp := range []tape.Tape{first, second} {
+		w = postForm(page, "/s/collection", url.Values{"id": {id}, "action": {"add"}, "tape": {tp.PublicId}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("add: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+		}
+	}
+
+	// only the user's own tapes can be added
+	w = postForm(page, "/s/collection", url.Values{"id": {id}, "action": {"add"}, "tape": {theirs.PublicId}})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("add: expected %d for another user's tape, actual %d", http.StatusNotFound, w.Code)
+	}
+
+	w = postForm(page, "/s/collection", url.Values{"id": {id}, "action": {"up"}, "tape": {second.PublicId}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("up: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r := newRequest("127.0.0.1:5000", testEmail)
+	r.URL.RawQuery = url.Values{"id": {id}}.Encode()
+	page.ServeHTTP(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || strings.Index(body, "Breakfast of Champions") > strings.Index(body, "Late Risers Club") {
+		t.Fatalf("expected tapes in their new order, actual %d %q", w.Code, body)
+	}
+	if !strings.Contains(body, "/s/audio/"+second.PublicId+"/part-1.mp3") || strings.Contains(body, "notes.txt") {
+		t.Fatalf("expected only audio in the player, actual %q", body)
+	}
+
+	// sharing
+	w = postForm(page, "/s/collection", url.Values{"id": {id}, "action": {"share"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("share: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	shared, err := collection.Get(db, id, u.Id, tape.AccessOwner)
+	if err != nil || shared.ShareToken == "" {
+		t.Fatalf("expected a share token, actual %v %v", shared, err)
+	}
+	token := shared.ShareToken
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /share/{token}", makeShareHandler(db, userDir, trust, tmplEngine)(nil))
+	mux.HandleFunc("GET /share/{token}/feed.xml", makeShareFeedHandler(db, userDir, trust)(nil))
+	mux.HandleFunc("GET /share/{token}/audio/{id}/{file}", makeShareAudioHandler(db, userDir)(nil))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w = get("/share/" + token)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Saturday jazz 2026") {
+		t.Fatalf("share page: expected the collection, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = get("/share/" + token + "/feed.xml")
+	audioPath := "/share/" + token + "/audio/" + first.PublicId + "/part-1.mp3"
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `url="http://example.com`+audioPath+`"`) {
+		t.Fatalf("feed: expected an enclosure, actual %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" {
+		t.Fatalf("feed: unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	w = get(audioPath)
+	if w.Code != http.StatusOK || w.Body.String() != "mp3 of Late Risers Club" {
+		t.Fatalf("audio: expected the file, actual %d %q", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{
+		"/share/" + token + "/audio/" + first.PublicId + "/notes.txt",
+		"/share/" + token + "/audio/" + theirs.PublicId + "/part-1.mp3",
+		"/share/wrong",
+	} {
+		if w = get(path); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected %d, actual %d", path, http.StatusNotFound, w.Code)
+		}
+	}
+
+	// a disabled owner's collections are no longer shared
+	err = user.SetStatus(db, u.Id, user.StatusDisabled, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/share/" + token, "/share/" + token + "/feed.xml", audioPath} {
+		if w = get(path); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected %d for a disabled owner, actual %d", path, http.StatusNotFound, w.Code)
+		}
+	}
+	err = user.SetStatus(db, u.Id, user.StatusEnabled, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = postForm(page, "/s/collection", url.Values{"id": {id}, "action": {"unshare"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unshare: expected %d, actual %d", http.StatusSeeOther, w.Code)
+	}
+	if w = get("/share/" + token); w.Code != http.StatusNotFound {
+		t.Fatalf("expected %d after unsharing, actual %d", http.StatusNotFound, w.Code)
+	}
+
+	w = postForm(page, "/s/collection", url.Values{"id": {id}, "action": {"delete"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("delete: expected %d, actual %d", http.StatusSeeOther, w.Code)
+	}
+	if _, err := collection.Get(db, id, u.Id, tape.AccessOwner); err != collection.ErrNotFound {
+		t.Fatalf("expected the collection to be deleted, actual %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"fmt"
+	"log"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+const (
+	// ActionStatus records a change of a user's status
+	ActionStatus = "status"
+	// ActionRole records a change of a user's role
+	ActionRole = "role"
+	// ActionStation records a station being added, changed or deleted
+	ActionStation = "station"
+	// ActionJob records a tape being put back in the queue by an admin
+	ActionJob = "job"
+	// ActionFsck records the repairs of a storage check
+	ActionFsck = "fsck"
+)
+
+// Record is one administrative change.  USER_ID has no foreign key so
+// records outlive the user.
+type Record struct {
+	Id int64
+	// UserId is the account the change was made to, the tape's owner for
+	// jobs, or the admin's own for shared data such as stations.
+	UserId int64
+	// Actor is who made the change, an email or the name of a tool.
+	Actor   string
+	Action  string
+	Detail  string
+	Created string
+}
+
+func (r *Record) String() string {
+	return fmt.Sprintf("audit %d user %d %s by %s: %s", r.Id, r.UserId, r.Action, r.Actor, r.Detail)
+}
+
+// New creates a record of action taken on the user by actor.
+func New(userId int64, actor string, action string, detail string) Record {
+	return Record{
+		UserId:  userId,
+		Actor:   actor,
+		Action:  action,
+		Detail:  detail,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func recordCreator(stmt *sqlite.Stmt) (*Record, error) {
+	return &Record{
+		Id:      stmt.GetInt64("ID"),
+		UserId:  stmt.GetInt64("USER_ID"),
+		Actor:   stmt.GetText("ACTOR"),
+		Action:  stmt.GetText("ACTION"),
+		Detail:  stmt.GetText("DETAIL"),
+		Created: stmt.GetText("CREATED_AT"),
+	}, nil
+}
+
+func Insert(db database.Runner, r *Record) error {
+	log.Println("enter InsertAudit", r)
+	defer log.Println("exit InsertAudit")
+
+	return db.RunQuery(database.Query{
+		Name:           "InsertAudit",
+		Sql:            "INSERT INTO AUDIT (USER_ID, ACTOR, ACTION, DETAIL, CREATED_AT) VALUES(:userId, :actor, :action, :detail, :created) RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":userId":  r.UserId,
+			":actor":   r.Actor,
+			":action":  r.Action,
+			":detail":  r.Detail,
+			":created": r.Created,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			r.Id = stmt.GetInt64("ID")
+			return nil
+		},
+	})
+}
+
+// GetForUser returns the user's records, oldest first.
+func GetForUser(db database.Runner, userId int64) ([]*Record, error) {
+	log.Println("enter GetAuditForUser", userId)
+	defer log.Println("exit GetAuditForUser")
+
+	records := make([]*Record, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetAuditForUser",
+		Sql:            "SELECT * FROM AUDIT WHERE USER_ID=:userId ORDER BY ID;",
+		Named:          map[string]any{":userId": userId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			r, err := recordCreator(stmt)
+			if err == nil {
+				records = append(records, r)
+			}
+			return err
+		},
+	})
+
+	return records, err
+}
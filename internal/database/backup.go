@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// backupTimeFormat sorts by name in time order.  The nanoseconds keep two
+// backups in the same second, such as a manual one during the scheduled
+// one, apart.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// backupParseFormat reads backupTimeFormat and the names of older
+// backups, which stop at the second.
+const backupParseFormat = "20060102T150405Z"
+
+// backupName is the file name of a backup of dbFile taken at t,
+// such as tapedeck-20261019T060000.000000000Z.db.
+func backupName(dbFile string, t time.Time) string {
+	base := strings.TrimSuffix(filepath.Base(dbFile), filepath.Ext(dbFile))
+	return fmt.Sprintf("%s-%s%s", base, t.UTC().Format(backupTimeFormat), filepath.Ext(dbFile))
+}
+
+// Backup writes a consistent copy of the open database to a timestamped
+// file in dir while other connections keep reading and writing.  Only the
+// newest keep backups are kept, zero keeps them all.  It returns the path
+// of the new backup.
+func (db *Database) Backup(ctx context.Context, dir string, keep int) (string, error) {
+	log.Println("enter Backup", dir, keep)
+	defer log.Println("exit Backup")
+
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupName(db.filePath, time.Now()))
+
+	err = db.RunQueryContext(ctx, Query{
+		Name:           "Backup",
+		Sql:            "VACUUM INTO :path;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":path": path},
+	})
+	if err != nil {
+		return "", fmt.Errorf("backup to %s failed: %w", path, err)
+	}
+
+	err = CheckIntegrity(path)
+	if err != nil {
+		return path, err
+	}
+
+	if keep > 0 {
+		err = pruneBackups(db.filePath, dir, keep)
+	}
+
+	return path, err
+}
+
+// ListBackups returns the backups of dbFile in dir, oldest first.
+func ListBackups(dbFile string, dir string) ([]string, error) {
+	base := strings.TrimSuffix(filepath.Base(dbFile), filepath.Ext(dbFile))
+	matches, err := filepath.Glob(filepath.Join(dir, base+"-*"+filepath.Ext(dbFile)))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), base+"-"), filepath.Ext(dbFile))
+		if _, err := time.Parse(backupParseFormat, stamp); err == nil {
+			backups = append(backups, match)
+		}
+	}
+
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// pruneBackups removes all but the newest keep backups of dbFile in dir.
+func pruneBackups(dbFile string, dir string, keep int) error {
+	backups, err := ListBackups(dbFile, dir)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		log.Println("remove old backup", backups[0])
+		err = os.Remove(backups[0])
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// CheckIntegrity runs "PRAGMA integrity_check" on the file, read only.
+func CheckIntegrity(path string) (err error) {
+	conn, err := sqlite.OpenConn(path, sqlite.OpenReadOnly)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := conn.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	problems := []string{}
+	err = sqlitex.ExecuteTransient(conn, "PRAGMA integrity_check;", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			if result := stmt.ColumnText(0); result != "ok" {
+				problems = append(problems, result)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("integrity check of %s failed: %w", path, err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", path, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Restore replaces dbFile with the backup after checking its integrity.
+// The server must be stopped first.  The replaced file is kept next to
+// dbFile with a ".replaced" suffix until the next restore.
+func Restore(backup string, dbFile string) error {
+	log.Println("enter Restore", backup, dbFile)
+	defer log.Println("exit Restore")
+
+	err := CheckIntegrity(backup)
+	if err != nil {
+		return err
+	}
+
+	// copy first so a failure leaves dbFile as it was
+	tmp := dbFile + ".restore"
+	err = copyFile(backup, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the write ahead log belongs with the replaced file
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(dbFile + ".replaced" + suffix)
+		err = os.Rename(dbFile+suffix, dbFile+".replaced"+suffix)
+		if err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	return os.Rename(tmp, dbFile)
+}
+
+func copyFile(from string, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupName(t *testing.T) {
+	at := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+	actual := backupName("/var/local/tapedeck/tapedeck.db", at)
+	if actual != "tapedeck-20261019T060000.000000000Z.db" {
+		t.Fatalf("unexpected backup name %q", actual)
+	}
+}
+
+func TestBackupTwiceInOneSecond(t *testing.T) {
+	db := setupDb(t)
+	dir := t.TempDir()
+
+	paths := map[string]bool{}
+	for range 2 {
+		path, err := db.Backup(context.Background(), dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths[path] = true
+	}
+
+	backups, err := ListBackups(dbPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || len(backups) != 2 {
+		t.Fatalf("expected two backups, actual %v", backups)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	db := setupDb(t)
+	dir := t.TempDir()
+
+	err := db.RunQuery(stationQuery("WMBR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := db.Backup(context.Background(), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changes after the backup are lost by the restore
+	err = db.RunQuery(stationQuery("WHRB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "tapedeck.db")
+	err = copyFile(dbPath, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Restore(path, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(target + ".replaced"); err != nil {
+		t.Fatalf("replaced file not kept: %v", err)
+	}
+
+	restored := New(target)
+	err = restored.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	expectStations(t, restored, 1)
+}
+
+func TestBackupRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	// older backups and unrelated files
+	for _, name := range []string{
+		"unit-test-20260101T000000Z.db",
+		"unit-test-20260102T000000Z.db",
+		"unit-test-20260103T000000.000000000Z.db",
+		"unit-test-notes.db",
+		"other-20260101T000000Z.db",
+	} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0o640)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := setupDb(t)
+
+	_, err := db.Backup(context.Background(), dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups(dbPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 2 || filepath.Base(backups[0]) != "unit-test-20260103T000000.000000000Z.db" {
+		t.Fatalf("unexpected backups after pruning %v", backups)
+	}
+
+	for _, name := range []string{"unit-test-notes.db", "other-20260101T000000Z.db"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s should not be pruned: %v", name, err)
+		}
+	}
+}
+
+func TestRestoreRejectsCorruptBackup(t *testing.T) {
+	dir := t.TempDir()
+	backup := filepath.Join(dir, "tapedeck-20260101T000000Z.db")
+	target := filepath.Join(dir, "tapedeck.db")
+
+	err := os.WriteFile(backup, []byte("not a database, just some text that is long enough"), 0o640)
+	if err == nil {
+		err = os.WriteFile(target, []byte("current"), 0o640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Restore(backup, target)
+	if err == nil {
+		t.Fatalf("expected integrity error")
+	}
+
+	current, err := os.ReadFile(target)
+	if err != nil || string(current) != "current" {
+		t.Fatalf("target changed by failed restore: %q %v", current, err)
+	}
+}
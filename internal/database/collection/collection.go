@@ -0,0 +1,440 @@
+package collection
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// maxNameLength keeps collection names short enough for a page title.
+const maxNameLength = 200
+
+// ErrNotFound is returned for a collection that does not exist and for
+// one the user may not see.
+var ErrNotFound = errors.New("collection not found")
+
+// Collection is a user's ordered group of their tapes, such as
+// "Saturday jazz 2026".
+type Collection struct {
+	// Id is only used inside the server, URLs use PublicId.
+	Id       int64  `json:"-"`
+	PublicId string `json:"id"`
+	UserId   int64  `json:"-"`
+	Name     string `json:"name"`
+	Desc     string `json:"desc"`
+	// ShareToken lets anyone with the link play the collection and
+	// subscribe to its feed.  Empty while it is not shared.
+	ShareToken string `json:"-"`
+	// Tapes is the number of tapes in the collection, including those in
+	// the trash.
+	Tapes int `json:"tapes"`
+	// Retention is the rule for the tapes in the collection, where
+	// KeepLast counts the whole collection.
+	Retention tape.Retention `json:"retention"`
+	Created   string         `json:"created"`
+	Updated   string         `json:"updated"`
+}
+
+func (c *Collection) String() string {
+	return fmt.Sprintf("collection %d %.20q", c.Id, c.Name)
+}
+
+// randomHex returns n random bytes as hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// New creates an empty collection owned by userId.
+func New(userId int64, name string, desc string) Collection {
+	return Collection{
+		PublicId: randomHex(12),
+		UserId:   userId,
+		Name:     name,
+		Desc:     desc,
+		Created:  time.Now().Format(time.RFC3339),
+	}
+}
+
+// Validate checks the name.
+func (c *Collection) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name required")
+	}
+	if len(c.Name) > maxNameLength {
+		return fmt.Errorf("name is longer than %d characters", maxNameLength)
+	}
+	return nil
+}
+
+const collectionSelectSql = "SELECT C.ID, C.PUBLIC_ID, C.USER_ID, C.NAME, C.DESC, C.SHARE_TOKEN, C.KEEP_LAST, C.KEEP_DAYS, C.CREATED_AT, C.UPDATED_AT, " +
+	"(SELECT COUNT(*) FROM COLLECTION_TAPE M WHERE M.COLLECTION_ID = C.ID) AS TAPES FROM COLLECTION C"
+
+func collectionCreator(stmt *sqlite.Stmt) (*Collection, error) {
+	return &Collection{
+		Id:         stmt.GetInt64("ID"),
+		PublicId:   stmt.GetText("PUBLIC_ID"),
+		UserId:     stmt.GetInt64("USER_ID"),
+		Name:       stmt.GetText("NAME"),
+		Desc:       stmt.GetText("DESC"),
+		ShareToken: stmt.GetText("SHARE_TOKEN"),
+		Tapes:      int(stmt.GetInt64("TAPES")),
+		Retention: tape.Retention{
+			KeepLast: int(stmt.GetInt64("KEEP_LAST")),
+			KeepDays: int(stmt.GetInt64("KEEP_DAYS")),
+		},
+		Created: stmt.GetText("CREATED_AT"),
+		Updated: stmt.GetText("UPDATED_AT"),
+	}, nil
+}
+
+func getOne(db database.Runner, name string, where string, named map[string]any) (*Collection, error) {
+	var collection *Collection
+	err := db.RunQuery(database.Query{
+		Name:           name,
+		Sql:            collectionSelectSql + where,
+		Named:          named,
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			c, err := collectionCreator(stmt)
+			collection = c
+			return err
+		},
+	})
+
+	return collection, err
+}
+
+// GetForUser returns the user's collections by name.
+func GetForUser(db database.Runner, userId int64) ([]*Collection, error) {
+	log.Println("enter GetCollectionsForUser", userId)
+	defer log.Println("exit GetCollectionsForUser")
+
+	collections := make([]*Collection, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetCollectionsForUser",
+		Sql:            collectionSelectSql + " WHERE C.USER_ID=:userId ORDER BY C.NAME COLLATE NOCASE, C.ID;",
+		Named:          map[string]any{":userId": userId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			c, err := collectionCreator(stmt)
+			if err == nil {
+				collections = append(collections, c)
+			}
+			return err
+		},
+	})
+
+	return collections, err
+}
+
+// Get returns the collection with the public id when the user has at least
+// the access asked for, and [ErrNotFound] otherwise.
+func Get(db database.Runner, publicId string, userId int64, need tape.Access) (*Collection, error) {
+	log.Println("enter GetCollection", publicId, userId)
+	defer log.Println("exit GetCollection")
+
+	c, err := getOne(db, "GetCollection", " WHERE C.PUBLIC_ID=:publicId;", map[string]any{":publicId": publicId})
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrNotFound
+	}
+
+	access, err := AccessFor(db, c, userId)
+	if err != nil {
+		return nil, err
+	}
+	if access < need || access == tape.AccessNone {
+		log.Printf("user %d has access %d to %v, needs %d\n", userId, access, c, need)
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// AccessFor returns what the user may do with the collection, as its owner
+// or through a grant.  Grants stop working while the owner is disabled,
+// 'enabled' is user.StatusEnabled.
+func AccessFor(db database.Runner, c *Collection, userId int64) (tape.Access, error) {
+	if c.UserId == userId {
+		return tape.AccessOwner, nil
+	}
+
+	access := tape.AccessNone
+	err := db.RunQuery(database.Query{
+		Name: "GetCollectionAccess",
+		Sql: "SELECT G.ACCESS FROM SHARE_GRANT G WHERE G.USER_ID=:userId AND G.COLLECTION_ID=:id " +
+			"AND EXISTS (SELECT 1 FROM USER O WHERE O.ID=:ownerId AND O.STATUS='enabled');",
+		Named:          map[string]any{":userId": userId, ":id": c.Id, ":ownerId": c.UserId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			access = tape.AccessRead
+			if stmt.GetText("ACCESS") == "edit" {
+				access = tape.AccessWrite
+			}
+			return nil
+		},
+	})
+
+	return access, err
+}
+
+// GetSharedWith returns the collections other users gave the user access
+// to by name, leaving out those of disabled owners.
+func GetSharedWith(db database.Runner, userId int64) ([]*Collection, error) {
+	log.Println("enter GetCollectionsSharedWith", userId)
+	defer log.Println("exit GetCollectionsSharedWith")
+
+	collections := make([]*Collection, 0)
+	err := db.RunQuery(database.Query{
+		Name: "GetCollectionsSharedWith",
+		Sql: collectionSelectSql + " WHERE C.ID IN (SELECT G.COLLECTION_ID FROM SHARE_GRANT G WHERE G.USER_ID=:userId)" +
+			" AND EXISTS (SELECT 1 FROM USER O WHERE O.ID = C.USER_ID AND O.STATUS='enabled') ORDER BY C.NAME COLLATE NOCASE, C.ID;",
+		Named:          map[string]any{":userId": userId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			c, err := collectionCreator(stmt)
+			if err == nil {
+				collections = append(collections, c)
+			}
+			return err
+		},
+	})
+
+	return collections, err
+}
+
+// GetByShareToken returns the shared collection with the token, or nil.
+func GetByShareToken(db database.Runner, token string) (*Collection, error) {
+	log.Println("enter GetCollectionByShareToken")
+	defer log.Println("exit GetCollectionByShareToken")
+
+	if token == "" {
+		return nil, nil
+	}
+	return getOne(db, "GetCollectionByShareToken", " WHERE C.SHARE_TOKEN=:token;", map[string]any{":token": token})
+}
+
+// Insert adds the collection and sets its Id.
+func Insert(db database.Runner, c *Collection) error {
+	log.Println("enter InsertCollection", c)
+	defer log.Println("exit InsertCollection")
+
+	return db.RunQuery(database.Query{
+		Name:           "InsertCollection",
+		Sql:            "INSERT INTO COLLECTION (PUBLIC_ID, USER_ID, NAME, DESC, CREATED_AT) VALUES(:publicId, :userId, :name, :desc, :created) RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":publicId": c.PublicId,
+			":userId":   c.UserId,
+			":name":     c.Name,
+			":desc":     c.Desc,
+			":created":  c.Created,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			c.Id = stmt.GetInt64("ID")
+			return nil
+		},
+	})
+}
+
+// Update saves the name and description and sets Updated.
+func Update(db database.Runner, c *Collection) error {
+	log.Println("enter UpdateCollection", c)
+	defer log.Println("exit UpdateCollection")
+
+	c.Updated = time.Now().Format(time.RFC3339)
+
+	return db.RunQuery(database.Query{
+		Name:           "UpdateCollection",
+		Sql:            "UPDATE COLLECTION SET NAME=:name, DESC=:desc, UPDATED_AT=:updated WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": c.Id, ":name": c.Name, ":desc": c.Desc, ":updated": c.Updated},
+	})
+}
+
+// SetRetention changes the rule for the tapes in the collection.
+func SetRetention(db database.Runner, id int64, r tape.Retention) error {
+	log.Println("enter SetCollectionRetention", id, r)
+	defer log.Println("exit SetCollectionRetention")
+
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	return db.RunQuery(database.Query{
+		Name:           "SetCollectionRetention",
+		Sql:            "UPDATE COLLECTION SET KEEP_LAST=:keepLast, KEEP_DAYS=:keepDays, UPDATED_AT=:now WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":keepLast": r.KeepLast, ":keepDays": r.KeepDays, ":now": time.Now().Format(time.RFC3339)},
+	})
+}
+
+// GetWithRetention returns every collection with a retention rule, for the
+// pruner.
+func GetWithRetention(db database.Runner) ([]*Collection, error) {
+	log.Println("enter GetCollectionsWithRetention")
+	defer log.Println("exit GetCollectionsWithRetention")
+
+	collections := make([]*Collection, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetCollectionsWithRetention",
+		Sql:            collectionSelectSql + " WHERE C.KEEP_LAST > 0 OR C.KEEP_DAYS > 0 ORDER BY C.ID;",
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			c, err := collectionCreator(stmt)
+			if err == nil {
+				collections = append(collections, c)
+			}
+			return err
+		},
+	})
+
+	return collections, err
+}
+
+// Delete removes the collection.  Its tapes are left alone.
+func Delete(db database.Runner, id int64) error {
+	log.Println("enter DeleteCollection", id)
+	defer log.Println("exit DeleteCollection")
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		return tx.RunQueries(database.Query{
+			Name:           "DeleteCollectionGrants",
+			Sql:            "DELETE FROM SHARE_GRANT WHERE COLLECTION_ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteCollectionTapes",
+			Sql:            "DELETE FROM COLLECTION_TAPE WHERE COLLECTION_ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteCollection",
+			Sql:            "DELETE FROM COLLECTION WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		})
+	})
+}
+
+// Share gives the collection a new share token, which stops the previous
+// link from working, and returns it.
+func Share(db database.Runner, id int64) (string, error) {
+	log.Println("enter ShareCollection", id)
+	defer log.Println("exit ShareCollection")
+
+	token := randomHex(24)
+	err := setShareToken(db, "ShareCollection", id, token)
+	return token, err
+}
+
+// Unshare stops the collection's share link from working.
+func Unshare(db database.Runner, id int64) error {
+	log.Println("enter UnshareCollection", id)
+	defer log.Println("exit UnshareCollection")
+
+	return setShareToken(db, "UnshareCollection", id, nil)
+}
+
+func setShareToken(db database.Runner, name string, id int64, token any) error {
+	return db.RunQuery(database.Query{
+		Name:           name,
+		Sql:            "UPDATE COLLECTION SET SHARE_TOKEN=:token, UPDATED_AT=:now WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":token": token, ":now": time.Now().Format(time.RFC3339)},
+	})
+}
+
+// AddTape puts the tape at the end of the collection.  A tape already in
+// the collection keeps its place.
+func AddTape(db database.Runner, id int64, tapeId int64) error {
+	log.Println("enter AddTapeToCollection", id, tapeId)
+	defer log.Println("exit AddTapeToCollection")
+
+	return db.RunQuery(database.Query{
+		Name: "AddTapeToCollection",
+		Sql: "INSERT INTO COLLECTION_TAPE (COLLECTION_ID, TAPE_ID, SEQ) " +
+			"VALUES(:id, :tapeId, (SELECT COALESCE(MAX(SEQ), 0) + 1 FROM COLLECTION_TAPE WHERE COLLECTION_ID=:id)) " +
+			"ON CONFLICT DO NOTHING;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":tapeId": tapeId},
+	})
+}
+
+// RemoveTape takes the tape out of the collection.
+func RemoveTape(db database.Runner, id int64, tapeId int64) error {
+	log.Println("enter RemoveTapeFromCollection", id, tapeId)
+	defer log.Println("exit RemoveTapeFromCollection")
+
+	return db.RunQuery(database.Query{
+		Name:           "RemoveTapeFromCollection",
+		Sql:            "DELETE FROM COLLECTION_TAPE WHERE COLLECTION_ID=:id AND TAPE_ID=:tapeId;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":tapeId": tapeId},
+	})
+}
+
+// MoveTape moves the tape by offset places, up the list when negative.
+// Moving past either end stops at the end.
+func MoveTape(db database.Runner, id int64, tapeId int64, offset int) error {
+	log.Println("enter MoveTapeInCollection", id, tapeId, offset)
+	defer log.Println("exit MoveTapeInCollection")
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		tapeIds := []int64{}
+		err := tx.RunQuery(database.Query{
+			Name:           "GetCollectionOrder",
+			Sql:            "SELECT TAPE_ID FROM COLLECTION_TAPE WHERE COLLECTION_ID=:id ORDER BY SEQ;",
+			Named:          map[string]any{":id": id},
+			PerformsUpdate: false,
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				tapeIds = append(tapeIds, stmt.GetInt64("TAPE_ID"))
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		from := -1
+		for i, t := range tapeIds {
+			if t == tapeId {
+				from = i
+			}
+		}
+		if from < 0 {
+			return fmt.Errorf("tape %d is not in collection %d", tapeId, id)
+		}
+
+		to := min(max(from+offset, 0), len(tapeIds)-1)
+		tapeIds = append(tapeIds[:from], tapeIds[from+1:]...)
+		tapeIds = append(tapeIds[:to], append([]int64{tapeId}, tapeIds[to:]...)...)
+
+		for i, t := range tapeIds {
+			err = tx.RunQuery(database.Query{
+				Name:           "SetCollectionOrder",
+				Sql:            "UPDATE COLLECTION_TAPE SET SEQ=:seq WHERE COLLECTION_ID=:id AND TAPE_ID=:tapeId;",
+				Named:          map[string]any{":id": id, ":tapeId": t, ":seq": i + 1},
+				PerformsUpdate: true,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,180 @@
+package collection_test
+
+import (
+	"errors"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+const dbPath = "./unit-test.db"
+
+func setup(t *testing.T) (*database.Database, *user.User, []*tape.Tape) {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Insert(db, user.New("tapedeck.us@gmail.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.GetByEmail(db, "tapedeck.us@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tapes := []*tape.Tape{}
+	for _, title := range []string{"Late Risers Club", "Breakfast of Champions", "Lost and Found"} {
+		tp := tape.New(u.Id, u.Uuid, s.Id, title, "2026-10-17")
+		err = tape.Insert(db, &tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tapes = append(tapes, &tp)
+	}
+
+	return db, u, tapes
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+// titles returns the titles of the collection's tapes in order.
+func titles(t *testing.T, db *database.Database, c *collection.Collection) []string {
+	t.Helper()
+	tapes, err := tape.GetInCollection(db, c.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, tp := range tapes {
+		actual = append(actual, tp.Title)
+	}
+	return actual
+}
+
+func TestCollection(t *testing.T) {
+	db, u, tapes := setup(t)
+
+	c := collection.New(u.Id, "", "")
+	if c.Validate() == nil {
+		t.Fatalf("expected name to be required")
+	}
+
+	c.Name = "Saturday jazz 2026"
+	err := collection.Insert(db, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tp := range append(tapes, tapes[0]) {
+		err = collection.AddTape(db, c.Id, tp.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = collection.MoveTape(db, c.Id, tapes[2].Id, -5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = collection.MoveTape(db, c.Id, tapes[0].Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Lost and Found, Breakfast of Champions, Late Risers Club"
+	if actual := strings.Join(titles(t, db, &c), ", "); actual != expected {
+		t.Fatalf("expected %s, actual %s", expected, actual)
+	}
+
+	// trashed tapes drop out of the collection until restored
+	_, err = tape.Trash(db, tapes[1].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := titles(t, db, &c); len(actual) != 2 {
+		t.Fatalf("expected 2 tapes, actual %v", actual)
+	}
+
+	all, err := collection.GetForUser(db, u.Id)
+	if err != nil || len(all) != 1 || all[0].Tapes != 3 {
+		t.Fatalf("expected one collection of 3 tapes, actual %v %v", all, err)
+	}
+
+	_, err = collection.Get(db, c.PublicId, u.Id+1, tape.AccessRead)
+	if !errors.Is(err, collection.ErrNotFound) {
+		t.Fatalf("expected %v for another user, actual %v", collection.ErrNotFound, err)
+	}
+
+	// a new token replaces the old one
+	first, err := collection.Share(db, c.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := collection.Share(db, c.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, err := collection.GetByShareToken(db, first); err != nil || shared != nil {
+		t.Fatalf("expected old token to stop working, actual %v %v", shared, err)
+	}
+	if shared, err := collection.GetByShareToken(db, second); err != nil || shared == nil || shared.Id != c.Id {
+		t.Fatalf("expected shared collection, actual %v %v", shared, err)
+	}
+
+	err = collection.Unshare(db, c.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, err := collection.GetByShareToken(db, second); err != nil || shared != nil {
+		t.Fatalf("expected unshared collection, actual %v %v", shared, err)
+	}
+
+	// deleting a tape takes it out, deleting the collection leaves the tapes
+	err = tape.Delete(db, tapes[2].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = collection.Delete(db, c.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, err := tape.GetTapesForUser(u.Id, db)
+	if err != nil || len(left) != 1 {
+		t.Fatalf("expected one tape left outside the trash, actual %v %v", left, err)
+	}
+
+	// the user takes their collections with them
+	other := collection.New(u.Id, "Best of WMBR", "")
+	err = collection.Insert(db, &other)
+	if err == nil {
+		err = collection.AddTape(db, other.Id, tapes[0].Id)
+	}
+	if err == nil {
+		err = user.Delete(db, u.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,25 +3,31 @@ package database
 
 import (
 	"context"
-	_ "embed"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strings"
+	"sync"
 	"tapedeck/internal/file"
+	"time"
 
 	"zombiezen.com/go/sqlite"
-	"zombiezen.com/go/sqlite/sqlitemigration"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 const DatabaseFileName = "tapedeck.db"
 
-// This contains the full database schema including
-// additional changes for incremental upgrades.
-//
-//go:embed schema.sql
-var sql string
+// Errors returned by the lifecycle methods, test for them with [errors.Is].
+var (
+	// ErrNotFound means the database file does not exist.
+	ErrNotFound = errors.New("database file not found")
+	// ErrWrongState means a method was called out of order, such as a query before Open.
+	ErrWrongState = errors.New("database in wrong state")
+	// ErrMigration means the schema could not be upgraded.
+	ErrMigration = errors.New("database migration failed")
+	// ErrSchemaVersion means the schema of the file does not match this build.
+	ErrSchemaVersion = errors.New("database schema version mismatch")
+)
 
 type State int
 
@@ -31,56 +37,183 @@ const (
 	closed
 )
 
+func (s State) String() string {
+	switch s {
+	case new:
+		return "new"
+	case opened:
+		return "opened"
+	case closed:
+		return "closed"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+// poolSize is the number of connections kept open to the database file.
+const poolSize = 10
+
+// busyTimeout is how long a connection waits for a lock held by another
+// connection before failing with SQLITE_BUSY.
+const busyTimeout = 5 * time.Second
+
+// Database is safe for concurrent use once opened.  Queries share a pool
+// of connections so each query no longer pays for opening the file.
 type Database struct {
-	filePath   string
-	schemaData string
-	state      State
+	filePath string
+	// migrations is the full schema, or migrationsErr when it could not be loaded.
+	migrations    []Migration
+	migrationsErr error
+
+	// mu guards state and pool.
+	mu    sync.Mutex
+	state State
+	pool  *sqlitex.Pool
 }
 
 func (db *Database) String() string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.describe()
+}
+
+// describe is String for callers already holding mu.
+func (db *Database) describe() string {
 	return fmt.Sprintf("database %q %v", db.filePath, db.state)
 }
 
 func New(filePath string) *Database {
+	migrations, err := embeddedMigrations()
 	return &Database{
-		filePath:   filePath,
-		schemaData: sql,
-		state:      new,
+		filePath:      filePath,
+		migrations:    migrations,
+		migrationsErr: err,
+		state:         new,
 	}
 }
 
-func (db *Database) Open() {
-	db.open(false)
+// Open checks the database file exists and opens the connection pool.
+// It returns [ErrNotFound] when there is no file and [ErrWrongState]
+// when the database was already opened.
+func (db *Database) Open() error {
+	return db.open(false)
 }
 
 // open will prepare the database for use by checking
-// for an existing file and performing any schema upgrades.
-// It will return upon success or panic when an error occurs.
-func (db *Database) open(create bool) {
+// for an existing file, creating it when asked to, and
+// opening the connection pool.
+func (db *Database) open(create bool) error {
 	log.Println("enter open", db, create)
 	defer log.Println("exit open")
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	if db.state != new {
-		log.Panicf("database not in state new: %v\n", db)
+		return fmt.Errorf("%w: expected new, %v", ErrWrongState, db.describe())
 	}
 
 	_, err := os.Stat(db.filePath)
 	if err != nil {
-		if create {
-			log.Printf("creating database file\n")
-			_, err = file.Touch(db.filePath)
-			if err != nil {
-				log.Panicf("could not create database file at %v, %v\n", db.filePath, err)
-			}
-		} else {
-			log.Panicf("database file not found: %v\n", err)
+		if !create {
+			return fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+
+		log.Printf("creating database file\n")
+		_, err = file.Touch(db.filePath)
+		if err != nil {
+			return fmt.Errorf("could not create database file at %v: %w", db.filePath, err)
 		}
 	}
+
+	pool, err := db.newPool()
+	if err != nil {
+		return err
+	}
+
+	db.pool = pool
 	db.state = opened
+	return nil
+}
+
+func (db *Database) newPool() (*sqlitex.Pool, error) {
+	// OpenCreate is left out as the file is checked by open.
+	pool, err := sqlitex.NewPool(db.filePath, sqlitex.PoolOptions{
+		Flags:       sqlite.OpenReadWrite | sqlite.OpenWAL,
+		PoolSize:    poolSize,
+		PrepareConn: prepareConn,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not open connection pool for %v: %w", db.filePath, err)
+	}
+	return pool, nil
 }
 
-// print the internal schema table or panic
-func schemaReport(conn *sqlite.Conn) {
+// reopenPool replaces every connection once the schema has changed.  A
+// connection that read the old schema can prepare "SELECT *" with the old
+// column names, which then no longer match the rows it returns.
+func (db *Database) reopenPool() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.state != opened {
+		return fmt.Errorf("%w: expected open, %v", ErrWrongState, db.describe())
+	}
+
+	err := db.pool.Close()
+	if err != nil {
+		log.Println("failed to close connection pool!", err)
+	}
+
+	pool, err := db.newPool()
+	if err != nil {
+		db.pool = nil
+		db.state = closed
+		return err
+	}
+
+	db.pool = pool
+	return nil
+}
+
+// prepareConn sets up each pooled connection before its first use.
+// WAL mode is set by the open flags and is stored in the file itself.
+func prepareConn(conn *sqlite.Conn) error {
+	conn.SetBusyTimeout(busyTimeout)
+	return sqlitex.ExecuteTransient(conn, "PRAGMA foreign_keys = ON;", nil)
+}
+
+// take returns a connection from the pool and the function that gives it back.
+// It fails if the database is not open or ctx is done before a connection is free.
+//
+// The put function does not lock mu as close holds it while waiting for
+// connections in use to be given back.
+func (db *Database) take(ctx context.Context) (*sqlite.Conn, func(), error) {
+	db.mu.Lock()
+	state, pool := db.state, db.pool
+	db.mu.Unlock()
+
+	if state != opened {
+		return nil, nil, fmt.Errorf("%w: expected open, database %q %v", ErrWrongState, db.filePath, state)
+	}
+
+	// report the context's error rather than the interrupted connection setup
+	err := ctx.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := pool.Take(ctx)
+	if err != nil {
+		log.Println("failed to take connection!", err)
+		return nil, nil, err
+	}
+
+	return conn, func() { pool.Put(conn) }, nil
+}
+
+// print the internal schema table
+func schemaReport(conn *sqlite.Conn) error {
 	const listSchemaQuery = `SELECT TYPE, NAME FROM sqlite_schema ORDER BY 1, 2;`
 	err := sqlitex.ExecuteTransient(conn, listSchemaQuery, &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
@@ -90,95 +223,185 @@ func schemaReport(conn *sqlite.Conn) {
 	})
 
 	if err != nil {
-		log.Panicf("sqlite_schema query failed: %v\n", err)
+		return fmt.Errorf("sqlite_schema query failed: %w", err)
 	}
+	return nil
 }
 
-// upgrade performs a database upgrade and returns on success or panic
-func (db *Database) Upgrade() {
-	log.Println("enter upgrade", db)
-	defer log.Println("exit upgrade")
+// SchemaVersion compares the migrations applied to the file with the ones
+// known to this build.
+type SchemaVersion struct {
+	// Current is the user_version of the file, the number of migrations applied.
+	Current int `json:"current"`
+	// Latest is the number of migrations in this build.
+	Latest int `json:"latest"`
+}
 
-	if db.state != opened {
-		log.Panicf("database not in state open: %v\n", db)
+func (v SchemaVersion) String() string {
+	return fmt.Sprintf("schema version %d of %d", v.Current, v.Latest)
+}
+
+// Pending reports whether Upgrade has migrations to apply.
+func (v SchemaVersion) Pending() bool {
+	return v.Current < v.Latest
+}
+
+// Newer reports whether the file was migrated by a later build.
+func (v SchemaVersion) Newer() bool {
+	return v.Current > v.Latest
+}
+
+// SchemaVersion reads the user_version kept in the file.
+func (db *Database) SchemaVersion(ctx context.Context) (SchemaVersion, error) {
+	v := SchemaVersion{Latest: len(db.migrations)}
+
+	if db.migrationsErr != nil {
+		return v, fmt.Errorf("%w: %w", ErrMigration, db.migrationsErr)
 	}
 
-	rawLines := strings.Split(db.schemaData, "\n")
+	conn, put, err := db.take(ctx)
+	if err != nil {
+		return v, err
+	}
+	defer put()
 
-	// remove SQL comments
-	schemaLines := make([]string, 0)
-	for _, line := range rawLines {
-		if !strings.HasPrefix(line, "--") {
-			schemaLines = append(schemaLines, line)
-		}
+	v.Current, err = userVersion(conn)
+	if err != nil {
+		return v, fmt.Errorf("failed to read user_version: %w", err)
 	}
 
-	log.Printf("schema lines: %d\n", len(schemaLines))
+	return v, nil
+}
+
+// reportSchema logs the tables and indexes of the database.
+func (db *Database) reportSchema(ctx context.Context) error {
+	conn, put, err := db.take(ctx)
+	if err != nil {
+		return err
+	}
+	defer put()
+
+	return schemaReport(conn)
+}
+
+// Upgrade applies the migrations the database file has not seen yet, each
+// in its own transaction.  Failures are returned as [ErrMigration], or
+// [ErrWrongState] when the database is not open.
+func (db *Database) Upgrade() error {
+	log.Println("enter upgrade", db)
+	defer log.Println("exit upgrade")
+
+	ctx := context.TODO()
 
-	if len(schemaLines) == 0 {
-		panic("missing schema data")
+	plan, err := db.Plan(ctx)
+	if errors.Is(err, ErrWrongState) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("%w: %w", ErrMigration, err)
 	}
 
-	schema := sqlitemigration.Schema{
-		Migrations: schemaLines,
+	log.Printf("pending migrations: %d\n", len(plan))
+	if len(plan) == 0 {
+		return nil
 	}
 
-	conn, err := openConn(db, true)
+	log.Printf("schema before upgrade:\n")
+	err = db.reportSchema(ctx)
 	if err != nil {
-		log.Panicf("upgrade failed, openConn: %v\n", err)
+		return fmt.Errorf("%w: %w", ErrMigration, err)
 	}
 
-	defer func() {
-		deferErr := closeConn(conn, err)
-		if deferErr != nil {
-			panic(deferErr)
+	for _, m := range plan {
+		err = db.apply(ctx, m)
+		if err != nil {
+			break
 		}
-	}()
-
-	log.Printf("schema before upgrade:\n")
-	schemaReport(conn)
+	}
 
-	err = sqlitemigration.Migrate(context.TODO(), conn, schema)
-	if err != nil {
-		log.Panicf("upgrade failed, migration: %v\n", err)
+	// even after a failure, earlier migrations may have changed the schema
+	reopenErr := db.reopenPool()
+	if err != nil || reopenErr != nil {
+		return fmt.Errorf("%w: %w", ErrMigration, errors.Join(err, reopenErr))
 	}
 
 	log.Printf("schema after upgrade:\n")
-	schemaReport(conn)
+	err = db.reportSchema(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMigration, err)
+	}
+	return nil
 }
 
-func (db *Database) Close() {
-	db.close(false)
+// Close waits for queries in progress and closes every connection.
+// It returns [ErrWrongState] when the database is not open.
+func (db *Database) Close() error {
+	return db.close(false)
 }
 
-func (db *Database) close(delete bool) {
+func (db *Database) close(delete bool) error {
 	log.Println("enter close", db, delete)
 	defer log.Println("exit close")
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	if db.state != opened {
-		log.Panicf("database not in state open: %v\n", db)
+		return fmt.Errorf("%w: expected open, %v", ErrWrongState, db.describe())
 	}
 
+	// blocks until queries in progress give back their connections
+	err := db.pool.Close()
+	if err != nil {
+		log.Println("failed to close connection pool!", err)
+	}
+	db.pool = nil
+	db.state = closed
+
 	if delete {
-		err := file.Delete(db.filePath)
-		if err != nil {
-			panic(err)
+		deleteErr := file.Delete(db.filePath)
+		if deleteErr != nil {
+			return errors.Join(err, deleteErr)
 		}
 	}
-	db.state = closed
+
+	return err
+}
+
+// RunQuery runs the query with a background context.  See [Database.RunQueryContext].
+func (db *Database) RunQuery(query Query) error {
+	return db.RunQueryContext(context.Background(), query)
 }
 
-func (db *Database) RunQuery(query Query) (err error) {
+// RunQueryContext runs the query on a pooled connection.  Waiting for a
+// connection and the query itself are interrupted when ctx is done.
+func (db *Database) RunQueryContext(ctx context.Context, query Query) (err error) {
 	log.Println("enter RunQuery", query.Name)
 	defer log.Println("exit RunQuery", query.Name)
 
-	conn, err := openConn(db, query.PerformsUpdate)
+	conn, put, err := db.take(ctx)
 	if err != nil {
 		return
 	}
-	defer func() {
-		err = closeConn(conn, err)
-	}()
+	defer put()
+
+	return runQuery(conn, query)
+}
+
+// runQuery executes the query on conn.  Queries that do not perform
+// updates run with query_only set so a mistake cannot change data.
+func runQuery(conn *sqlite.Conn, query Query) (err error) {
+	if !query.PerformsUpdate {
+		err = sqlitex.ExecuteTransient(conn, "PRAGMA query_only = ON;", nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			resetErr := sqlitex.ExecuteTransient(conn, "PRAGMA query_only = OFF;", nil)
+			if err == nil {
+				err = resetErr
+			}
+		}()
+	}
 
 	log.Println("execute query", query.Sql, query.Named)
 
@@ -206,53 +429,15 @@ func (query *Query) String() string {
 	return fmt.Sprintf("query %v %v", query.Name, query.Sql)
 }
 
-func openConn(db *Database, update bool) (*sqlite.Conn, error) {
-	log.Println("enter openConn")
-	defer log.Println("exit openConn")
-
-	var flags sqlite.OpenFlags
-	if update {
-		flags = sqlite.OpenReadWrite
-	} else {
-		flags = sqlite.OpenReadOnly
-	}
-
-	conn, err := sqlite.OpenConn(db.filePath, flags)
-	if err != nil {
-		log.Println("failed to open connection!", err)
-		return nil, err
-	}
-
-	return conn, nil
+// Page limits the rows returned by a list query.
+type Page struct {
+	Limit  int
+	Offset int
 }
 
-// Close the connection and return either the current error
-// or the error that occurred during close. If both errors
-// exist then return current which represents the first problem
-// and should not be masked by the subsequent close failure.
-//
-// This function is structured in this way so it can be easily
-// called from a defer block in a function that is using a named
-// return value for error.  That allows the error to pass back to
-// caller via defer.
-//
-//	func doSomething() (err error) {
-//	  con := . . .
-//	  defer func() { err = closeConn(conn, err) }()
-//	  . . .
-//	  return // using named 'err'
-//	}
-func closeConn(conn *sqlite.Conn, currentErr error) error {
-	log.Println("enter closeConn")
-	defer log.Println("exit closeConn")
-
-	closeErr := conn.Close()
-	if closeErr != nil {
-		log.Println("failed to close connection!", closeErr)
-		if currentErr == nil {
-			return closeErr
-		}
-	}
-
-	return currentErr
+func (p Page) String() string {
+	return fmt.Sprintf("page limit %d offset %d", p.Limit, p.Offset)
 }
+
+// AllRows is a [Page] that returns every row.
+var AllRows = Page{Limit: -1}
@@ -1,9 +1,16 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
 	"tapedeck/internal/file"
 	"testing"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 const dbPath = "./unit-test.db"
@@ -50,13 +57,6 @@ func TestDbCreate(t *testing.T) {
 }
 
 func TestDbNoCreate(t *testing.T) {
-	defer func() {
-		r := recover()
-		if r == nil {
-			t.Fatalf("expected panic, but got none")
-		}
-	}()
-
 	// verify db file is not there
 	_, err := os.Stat(dbPath)
 	if err == nil {
@@ -68,6 +68,226 @@ func TestDbNoCreate(t *testing.T) {
 		t.Fatalf("database not in state new\n")
 	}
 
-	// should panic
-	db.Open()
+	err = db.Open()
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, actual %v", err)
+	}
+
+	if db.state != new {
+		t.Fatalf("database not in state new after failed open\n")
+	}
+}
+
+func TestDbWrongState(t *testing.T) {
+	db := New(dbPath)
+
+	err := db.Upgrade()
+	if !errors.Is(err, ErrWrongState) {
+		t.Fatalf("upgrade before open: expected ErrWrongState, actual %v", err)
+	}
+
+	err = db.Close()
+	if !errors.Is(err, ErrWrongState) {
+		t.Fatalf("close before open: expected ErrWrongState, actual %v", err)
+	}
+
+	err = db.open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	err = db.Open()
+	if !errors.Is(err, ErrWrongState) {
+		t.Fatalf("second open: expected ErrWrongState, actual %v", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RunQuery(Query{Name: "AfterClose", Sql: "SELECT 1;"})
+	if !errors.Is(err, ErrWrongState) {
+		t.Fatalf("query after close: expected ErrWrongState, actual %v", err)
+	}
+}
+
+func TestUpgradeMigrationError(t *testing.T) {
+	db := New(dbPath)
+	db.migrations = []Migration{{Version: 1, Name: "broken", Sql: "CREATE TABLE BROKEN (;"}}
+
+	err := db.open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.close(true)
+
+	err = db.Upgrade()
+	if !errors.Is(err, ErrMigration) {
+		t.Fatalf("expected ErrMigration, actual %v", err)
+	}
+}
+
+func setupDb(t testing.TB) *Database {
+	db := New(dbPath)
+	err := db.open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.close(true) })
+
+	err = db.Upgrade()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func insertStation(db *Database, ctx context.Context, callLetters string) error {
+	return db.RunQueryContext(ctx, Query{
+		Name:           "InsertTestStation",
+		Sql:            "INSERT INTO STATION (CALL_LETTERS, FREQ, HOMEPAGE_URL) VALUES(:call, '88.1', 'https://example.com');",
+		PerformsUpdate: true,
+		Named:          map[string]any{":call": callLetters},
+	})
+}
+
+func countStations(db *Database) (int, error) {
+	count := 0
+	err := db.RunQuery(Query{
+		Name: "CountTestStations",
+		Sql:  "SELECT COUNT(*) FROM STATION;",
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count = stmt.ColumnInt(0)
+			return nil
+		},
+	})
+	return count, err
+}
+
+// TestConcurrentQueries is meant to be run with -race.
+func TestConcurrentQueries(t *testing.T) {
+	db := setupDb(t)
+
+	const workers = 8
+	const perWorker = 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker*2)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				errs <- insertStation(db, context.Background(), fmt.Sprintf("W%d-%d", w, i))
+				_, err := countStations(db)
+				errs <- err
+				_ = db.String()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := countStations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != workers*perWorker {
+		t.Fatalf("expected %d stations, actual %d", workers*perWorker, count)
+	}
+}
+
+func TestQueryCancelled(t *testing.T) {
+	db := setupDb(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := insertStation(db, ctx, "WMBR")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, actual %v", err)
+	}
+}
+
+func TestQueryOnlyWithoutUpdate(t *testing.T) {
+	db := setupDb(t)
+
+	err := db.RunQuery(Query{
+		Name: "InsertWithoutUpdate",
+		Sql:  "INSERT INTO STATION (CALL_LETTERS, FREQ, HOMEPAGE_URL) VALUES('WMBR', '88.1', 'https://wmbr.org');",
+	})
+	if err == nil {
+		t.Fatalf("expected error for insert without PerformsUpdate")
+	}
+
+	// the connection must be writable again for the next query
+	for i := 0; i < poolSize; i++ {
+		err = insertStation(db, context.Background(), "WMBR")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestForeignKeysEnforced(t *testing.T) {
+	db := setupDb(t)
+
+	err := db.RunQuery(Query{
+		Name:           "InsertOrphanSource",
+		Sql:            "INSERT INTO TAPE_SOURCE (TAPE_ID, SEQ, TYPE, URL, CREATED_AT) VALUES(42, 0, 'url', 'https://example.com', 'now');",
+		PerformsUpdate: true,
+	})
+	if err == nil {
+		t.Fatalf("expected foreign key error")
+	}
+}
+
+// BenchmarkRunQuery reads through the pool.  Compare with
+// BenchmarkConnPerQuery which opens a connection for every query
+// as RunQuery used to.
+func BenchmarkRunQuery(b *testing.B) {
+	db := setupDb(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := countStations(db)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkConnPerQuery(b *testing.B) {
+	setupDb(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := sqlite.OpenConn(dbPath, sqlite.OpenReadOnly)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			err = sqlitex.Execute(conn, "SELECT COUNT(*) FROM STATION;", &sqlitex.ExecOptions{
+				ResultFunc: func(stmt *sqlite.Stmt) error { return nil },
+			})
+			conn.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
@@ -0,0 +1,182 @@
+package grant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+const (
+	// AccessView lets the user play a tape or collection.
+	AccessView = "view"
+	// AccessEdit also lets the user change its details.  For a collection
+	// that is the name, description and order, never the tapes in it.
+	AccessEdit = "edit"
+)
+
+// Grant gives another user access to one tape or one collection, which the
+// owner can take back at any time.  Exactly one of TapeId and
+// CollectionId is set.
+type Grant struct {
+	Id     int64
+	UserId int64
+	// Email is the email of the user given access.  Set by the Get
+	// functions only.
+	Email        string
+	TapeId       int64
+	CollectionId int64
+	Access       string
+	// GrantedBy is the email of the owner who gave access.
+	GrantedBy string
+	Created   string
+}
+
+func (g *Grant) String() string {
+	return fmt.Sprintf("grant %d user %d tape %d collection %d %s", g.Id, g.UserId, g.TapeId, g.CollectionId, g.Access)
+}
+
+// ForTape gives userId access to the tape.
+func ForTape(tapeId int64, userId int64, access string, grantedBy string) Grant {
+	return Grant{UserId: userId, TapeId: tapeId, Access: access, GrantedBy: grantedBy, Created: time.Now().Format(time.RFC3339)}
+}
+
+// ForCollection gives userId access to the collection.
+func ForCollection(collectionId int64, userId int64, access string, grantedBy string) Grant {
+	return Grant{UserId: userId, CollectionId: collectionId, Access: access, GrantedBy: grantedBy, Created: time.Now().Format(time.RFC3339)}
+}
+
+// Validate checks the access level.
+func (g *Grant) Validate() error {
+	if g.Access != AccessView && g.Access != AccessEdit {
+		return fmt.Errorf("access must be %q or %q", AccessView, AccessEdit)
+	}
+	if (g.TapeId == 0) == (g.CollectionId == 0) {
+		return fmt.Errorf("a grant is for a tape or a collection")
+	}
+	return nil
+}
+
+// nullable stores the zero id as NULL.
+func nullable(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// Put saves the grant, replacing the user's earlier grant for the same tape
+// or collection, and sets its Id.
+func Put(db database.Runner, g *Grant) error {
+	log.Println("enter PutGrant", g)
+	defer log.Println("exit PutGrant")
+
+	tapeId, collectionId := nullable(g.TapeId), nullable(g.CollectionId)
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		return tx.RunQueries(database.Query{
+			Name:           "DeleteEarlierGrant",
+			Sql:            "DELETE FROM SHARE_GRANT WHERE USER_ID=:userId AND TAPE_ID IS :tapeId AND COLLECTION_ID IS :collectionId;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":userId": g.UserId, ":tapeId": tapeId, ":collectionId": collectionId},
+		}, database.Query{
+			Name: "InsertGrant",
+			Sql: "INSERT INTO SHARE_GRANT (USER_ID, TAPE_ID, COLLECTION_ID, ACCESS, GRANTED_BY, CREATED_AT) " +
+				"VALUES(:userId, :tapeId, :collectionId, :access, :grantedBy, :created) RETURNING ID;",
+			PerformsUpdate: true,
+			Named: map[string]any{
+				":userId":       g.UserId,
+				":tapeId":       tapeId,
+				":collectionId": collectionId,
+				":access":       g.Access,
+				":grantedBy":    g.GrantedBy,
+				":created":      g.Created,
+			},
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				g.Id = stmt.GetInt64("ID")
+				return nil
+			},
+		})
+	})
+}
+
+const grantSelectSql = "SELECT G.ID, G.USER_ID, U.EMAIL, G.TAPE_ID, G.COLLECTION_ID, G.ACCESS, G.GRANTED_BY, G.CREATED_AT " +
+	"FROM SHARE_GRANT G INNER JOIN USER U ON U.ID = G.USER_ID"
+
+func grantCreator(stmt *sqlite.Stmt) (*Grant, error) {
+	return &Grant{
+		Id:           stmt.GetInt64("ID"),
+		UserId:       stmt.GetInt64("USER_ID"),
+		Email:        stmt.GetText("EMAIL"),
+		TapeId:       stmt.GetInt64("TAPE_ID"),
+		CollectionId: stmt.GetInt64("COLLECTION_ID"),
+		Access:       stmt.GetText("ACCESS"),
+		GrantedBy:    stmt.GetText("GRANTED_BY"),
+		Created:      stmt.GetText("CREATED_AT"),
+	}, nil
+}
+
+func getAll(db database.Runner, name string, where string, named map[string]any) ([]*Grant, error) {
+	grants := make([]*Grant, 0)
+	err := db.RunQuery(database.Query{
+		Name:           name,
+		Sql:            grantSelectSql + where,
+		Named:          named,
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			g, err := grantCreator(stmt)
+			if err == nil {
+				grants = append(grants, g)
+			}
+			return err
+		},
+	})
+
+	return grants, err
+}
+
+// GetForTape returns who was given access to the tape, by email.
+func GetForTape(db database.Runner, tapeId int64) ([]*Grant, error) {
+	log.Println("enter GetGrantsForTape", tapeId)
+	defer log.Println("exit GetGrantsForTape")
+
+	return getAll(db, "GetGrantsForTape", " WHERE G.TAPE_ID=:tapeId ORDER BY U.EMAIL;", map[string]any{":tapeId": tapeId})
+}
+
+// GetForCollection returns who was given access to the collection, by
+// email.
+func GetForCollection(db database.Runner, collectionId int64) ([]*Grant, error) {
+	log.Println("enter GetGrantsForCollection", collectionId)
+	defer log.Println("exit GetGrantsForCollection")
+
+	return getAll(db, "GetGrantsForCollection", " WHERE G.COLLECTION_ID=:collectionId ORDER BY U.EMAIL;", map[string]any{":collectionId": collectionId})
+}
+
+// DeleteForTape takes back the grant when it is for the tape.
+func DeleteForTape(db database.Runner, tapeId int64, id int64) error {
+	log.Println("enter DeleteGrantForTape", tapeId, id)
+	defer log.Println("exit DeleteGrantForTape")
+
+	return db.RunQuery(database.Query{
+		Name:           "DeleteGrantForTape",
+		Sql:            "DELETE FROM SHARE_GRANT WHERE ID=:id AND TAPE_ID=:tapeId;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":tapeId": tapeId},
+	})
+}
+
+// DeleteForCollection takes back the grant when it is for the collection.
+func DeleteForCollection(db database.Runner, collectionId int64, id int64) error {
+	log.Println("enter DeleteGrantForCollection", collectionId, id)
+	defer log.Println("exit DeleteGrantForCollection")
+
+	return db.RunQuery(database.Query{
+		Name:           "DeleteGrantForCollection",
+		Sql:            "DELETE FROM SHARE_GRANT WHERE ID=:id AND COLLECTION_ID=:collectionId;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":collectionId": collectionId},
+	})
+}
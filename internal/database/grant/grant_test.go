@@ -0,0 +1,241 @@
+package grant_test
+
+import (
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/grant"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+const dbPath = "./unit-test.db"
+
+func setup(t *testing.T) (*database.Database, *user.User, *user.User, *station.Station) {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []*user.User{}
+	for _, email := range []string{"tapedeck.us@gmail.com", "listener@example.com"} {
+		err = user.Insert(db, user.New(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := user.GetByEmail(db, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, users[0], users[1], &s
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+func accessFor(t *testing.T, db *database.Database, tp *tape.Tape, userId int64) tape.Access {
+	t.Helper()
+	read, err := tape.GetTape(tp.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := tape.AccessFor(db, read, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return access
+}
+
+func TestTapeGrants(t *testing.T) {
+	db, owner, listener, s := setup(t)
+
+	tp := tape.New(owner.Id, owner.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessNone {
+		t.Fatalf("expected no access before sharing, actual %d", access)
+	}
+
+	g := grant.ForTape(tp.Id, listener.Id, "listen", owner.Email)
+	if err := g.Validate(); err == nil {
+		t.Fatal("expected an unknown access to be invalid")
+	}
+
+	g = grant.ForTape(tp.Id, listener.Id, grant.AccessView, owner.Email)
+	err = grant.Put(db, &g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessRead {
+		t.Fatalf("expected read access, actual %d", access)
+	}
+
+	// a second grant replaces the first
+	g = grant.ForTape(tp.Id, listener.Id, grant.AccessEdit, owner.Email)
+	err = grant.Put(db, &g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := grant.GetForTape(db, tp.Id)
+	if err != nil || len(grants) != 1 || grants[0].Access != grant.AccessEdit || grants[0].Email != listener.Email {
+		t.Fatalf("expected one edit grant, actual %v %v", grants, err)
+	}
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessWrite {
+		t.Fatalf("expected write access, actual %d", access)
+	}
+
+	shared, err := tape.GetSharedWith(db, listener.Id)
+	if err != nil || len(shared) != 1 || shared[0].Id != tp.Id {
+		t.Fatalf("expected the tape to be shared, actual %v %v", shared, err)
+	}
+	shared, err = tape.GetSharedWith(db, owner.Id)
+	if err != nil || len(shared) != 0 {
+		t.Fatalf("expected nothing shared with the owner, actual %v %v", shared, err)
+	}
+
+	// grants stop working while the owner is disabled
+	err = user.SetStatus(db, owner.Id, user.StatusDisabled, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessNone {
+		t.Fatalf("expected no access to a disabled owner's tape, actual %d", access)
+	}
+	err = user.SetStatus(db, owner.Id, user.StatusEnabled, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the owner sees the trash
+	_, err = tape.Trash(db, tp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessNone {
+		t.Fatalf("expected no access to a trashed tape, actual %d", access)
+	}
+	_, err = tape.Restore(db, tp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the id must match the tape
+	err = grant.DeleteForTape(db, tp.Id+1, g.Id)
+	if err == nil {
+		err = grant.DeleteForTape(db, tp.Id, g.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessNone {
+		t.Fatalf("expected no access after removing the grant, actual %d", access)
+	}
+
+	// deleting the tape deletes its grants
+	g = grant.ForTape(tp.Id, listener.Id, grant.AccessView, owner.Email)
+	err = grant.Put(db, &g)
+	if err == nil {
+		err = tape.Delete(db, tp.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err = grant.GetForTape(db, tp.Id)
+	if err != nil || len(grants) != 0 {
+		t.Fatalf("expected no grants left, actual %v %v", grants, err)
+	}
+}
+
+func TestCollectionGrants(t *testing.T) {
+	db, owner, listener, s := setup(t)
+
+	tp := tape.New(owner.Id, owner.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := collection.New(owner.Id, "Saturday jazz 2026", "")
+	err = collection.Insert(db, &c)
+	if err == nil {
+		err = collection.AddTape(db, c.Id, tp.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = collection.Get(db, c.PublicId, listener.Id, tape.AccessRead)
+	if err != collection.ErrNotFound {
+		t.Fatalf("expected %v before sharing, actual %v", collection.ErrNotFound, err)
+	}
+
+	g := grant.ForCollection(c.Id, listener.Id, grant.AccessEdit, owner.Email)
+	err = grant.Put(db, &g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := collection.Get(db, c.PublicId, listener.Id, tape.AccessWrite)
+	if err != nil || read.Id != c.Id {
+		t.Fatalf("expected write access to the collection, actual %v %v", read, err)
+	}
+	_, err = collection.Get(db, c.PublicId, listener.Id, tape.AccessOwner)
+	if err != collection.ErrNotFound {
+		t.Fatalf("expected %v for owner access, actual %v", collection.ErrNotFound, err)
+	}
+
+	// editing a collection does not allow editing its tapes
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessRead {
+		t.Fatalf("expected read access through the collection, actual %d", access)
+	}
+
+	collections, err := collection.GetSharedWith(db, listener.Id)
+	if err != nil || len(collections) != 1 || collections[0].Id != c.Id {
+		t.Fatalf("expected the collection to be shared, actual %v %v", collections, err)
+	}
+	shared, err := tape.GetSharedWith(db, listener.Id)
+	if err != nil || len(shared) != 1 || shared[0].Id != tp.Id {
+		t.Fatalf("expected the tape to be shared, actual %v %v", shared, err)
+	}
+
+	// a tape out of the collection is no longer shared
+	err = collection.RemoveTape(db, c.Id, tp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access := accessFor(t, db, &tp, listener.Id); access != tape.AccessNone {
+		t.Fatalf("expected no access after removing the tape, actual %d", access)
+	}
+
+	// deleting the user deletes the grants given to them
+	err = user.Delete(db, listener.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := grant.GetForCollection(db, c.Id)
+	if err != nil || len(grants) != 0 {
+		t.Fatalf("expected no grants left, actual %v %v", grants, err)
+	}
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// This contains the full database schema as numbered migration files.
+// See migrations/README.md for the rules.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// goMigrations are data migrations written in Go.  Each has its own
+// version number which must not be used by a file.
+var goMigrations = []Migration{}
+
+// Migration is one step of the schema.  It sets the user_version of
+// the database file to Version once applied.
+type Migration struct {
+	Version int
+	Name    string
+	// Sql is a script of one or more statements.
+	Sql string
+	// Go is used instead of Sql for data migrations.
+	Go func(tx *Tx) error
+}
+
+func (m Migration) String() string {
+	kind := "sql"
+	if m.Go != nil {
+		kind = "go"
+	}
+	return fmt.Sprintf("migration %04d %s (%s)", m.Version, m.Name, kind)
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.sql$`)
+
+// loadMigrations reads the numbered files of fsys and merges them with
+// the Go migrations.  Versions must run from 1 with no gaps or repeats.
+func loadMigrations(fsys fs.FS, dir string, goSteps []Migration) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := append([]Migration(nil), goSteps...)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %q is not named NNNN_name.sql", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		bytes, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: match[2], Sql: string(bytes)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("expected migration %04d, found %v", i+1, m)
+		}
+		if (m.Sql == "") == (m.Go == nil) {
+			return nil, fmt.Errorf("%v must have either SQL or a Go function", m)
+		}
+	}
+
+	return migrations, nil
+}
+
+// embeddedMigrations loads the migrations built into the binary.
+func embeddedMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations", goMigrations)
+}
+
+// Plan returns the migrations Upgrade would apply, without applying them.
+func (db *Database) Plan(ctx context.Context) ([]Migration, error) {
+	v, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Newer() {
+		return nil, fmt.Errorf("%w: %v", ErrSchemaVersion, v)
+	}
+
+	return db.migrations[v.Current:], nil
+}
+
+// apply runs one migration and records its version in the same transaction,
+// so a failed migration leaves the file at the previous version.
+func (db *Database) apply(ctx context.Context, m Migration) error {
+	log.Println("apply", m)
+
+	return db.WithTx(ctx, func(tx *Tx) error {
+		var err error
+		if m.Go != nil {
+			err = m.Go(tx)
+		} else {
+			err = sqlitex.ExecScript(tx.conn, m.Sql)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", m, err)
+		}
+
+		// PRAGMA does not take parameters
+		return sqlitex.ExecuteTransient(tx.conn, fmt.Sprintf("PRAGMA user_version = %d;", m.Version), nil)
+	})
+}
+
+// userVersion reads the number of migrations applied to the file.
+func userVersion(conn *sqlite.Conn) (int, error) {
+	version := 0
+	err := sqlitex.ExecuteTransient(conn, "PRAGMA user_version;", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			version = stmt.ColumnInt(0)
+			return nil
+		},
+	})
+	return version, err
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"zombiezen.com/go/sqlite"
+)
+
+const multiLineMigration = `-- a table and a trigger spread over several lines
+CREATE TABLE NOTE (
+  ID INTEGER PRIMARY KEY,
+  BODY TEXT NOT NULL,
+  UPDATED_AT TEXT
+) STRICT;
+
+CREATE TRIGGER NOTE_UPDATED AFTER UPDATE OF BODY ON NOTE
+BEGIN
+  UPDATE NOTE SET UPDATED_AT = 'touched' WHERE ID = NEW.ID;
+END;
+`
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema.sql lines these files replaced
+	if len(migrations) < 7 {
+		t.Fatalf("expected at least 7 migrations, actual %d", len(migrations))
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	noop := func(tx *Tx) error { return nil }
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		goSteps []Migration
+	}{
+		{"gap", fstest.MapFS{
+			"m/0001_a.sql": {Data: []byte("SELECT 1;")},
+			"m/0003_c.sql": {Data: []byte("SELECT 1;")},
+		}, nil},
+		{"bad name", fstest.MapFS{
+			"m/1_a.sql": {Data: []byte("SELECT 1;")},
+		}, nil},
+		{"repeat", fstest.MapFS{
+			"m/0001_a.sql": {Data: []byte("SELECT 1;")},
+		}, []Migration{{Version: 1, Name: "a", Go: noop}}},
+		{"empty", fstest.MapFS{
+			"m/0001_a.sql": {Data: []byte("")},
+		}, nil},
+	}
+
+	for _, test := range tests {
+		_, err := loadMigrations(test.fsys, "m", test.goSteps)
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func setupMigrations(t *testing.T, fsys fstest.MapFS, goSteps []Migration) *Database {
+	migrations, err := loadMigrations(fsys, "m", goSteps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := New(dbPath)
+	db.migrations = migrations
+
+	err = db.open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.close(true) })
+
+	return db
+}
+
+func TestMultiStatementAndGoMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_create_note.sql": {Data: []byte(multiLineMigration)},
+	}
+	goSteps := []Migration{{Version: 2, Name: "seed_note", Go: func(tx *Tx) error {
+		return tx.RunQuery(Query{
+			Name:           "SeedNote",
+			Sql:            "INSERT INTO NOTE (BODY) VALUES('hello');",
+			PerformsUpdate: true,
+		})
+	}}}
+
+	db := setupMigrations(t, fsys, goSteps)
+
+	plan, err := db.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) != 2 || plan[1].Go == nil {
+		t.Fatalf("unexpected plan %v", plan)
+	}
+
+	// Plan is a dry run
+	v, err := db.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Current != 0 || v.Latest != 2 {
+		t.Fatalf("expected version 0 of 2, actual %v", v)
+	}
+
+	err = db.Upgrade()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = db.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Current != 2 || v.Pending() {
+		t.Fatalf("expected version 2 of 2, actual %v", v)
+	}
+
+	// the trigger body was created in one piece
+	updated := ""
+	err = db.RunQueries(context.Background(), Query{
+		Name:           "UpdateNote",
+		Sql:            "UPDATE NOTE SET BODY='changed';",
+		PerformsUpdate: true,
+	}, Query{
+		Name: "GetNote",
+		Sql:  "SELECT UPDATED_AT FROM NOTE;",
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			updated = stmt.ColumnText(0)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated != "touched" {
+		t.Fatalf("trigger did not run, UPDATED_AT %q", updated)
+	}
+}
+
+func TestFailedMigrationKeepsVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_create_note.sql": {Data: []byte(multiLineMigration)},
+		"m/0002_broken.sql":      {Data: []byte("INSERT INTO NOTE (BODY) VALUES('x');\nCREATE TABLE BROKEN (;")},
+	}
+
+	db := setupMigrations(t, fsys, nil)
+
+	err := db.Upgrade()
+	if !errors.Is(err, ErrMigration) {
+		t.Fatalf("expected ErrMigration, actual %v", err)
+	}
+
+	v, err := db.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Current != 1 {
+		t.Fatalf("expected version 1, actual %v", v)
+	}
+
+	// the first statement of the failed file was rolled back
+	count := -1
+	err = db.RunQuery(Query{
+		Name: "CountNotes",
+		Sql:  "SELECT COUNT(*) FROM NOTE;",
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count = stmt.ColumnInt(0)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no notes, actual %d", count)
+	}
+}
@@ -0,0 +1 @@
+CREATE TABLE USER (ID INTEGER PRIMARY KEY, UUID TEXT UNIQUE NOT NULL, EMAIL TEXT NOT NULL UNIQUE, EXTERNAL_ID TEXT, PROVIDER TEXT NOT NULL, STATUS TEXT NOT NULL, CREATED_AT TEXT NOT NULL) STRICT;
@@ -0,0 +1 @@
+CREATE TABLE TAPE_SOURCE (ID INTEGER PRIMARY KEY, TAPE_ID INTEGER REFERENCES TAPE (ID) NOT NULL, SEQ INTEGER NOT NULL, TYPE TEXT NOT NULL, URL TEXT NOT NULL, FILE_EXTENSION TEXT, CONTENT_TYPE TEXT, CONTENT TEXT, CREATED_AT TEXT NOT NULL, UPDATED_AT TEXT) STRICT;
@@ -0,0 +1 @@
+CREATE TABLE STATION (ID INTEGER PRIMARY KEY, CALL_LETTERS TEXT NOT NULL, FREQ TEXT NOT NULL, DESC TEXT, HOMEPAGE_URL TEXT NOT NULL, ARCHIVES_URL TEXT, LIVE_STREAM_URL TEXT) STRICT;
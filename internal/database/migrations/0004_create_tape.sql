@@ -0,0 +1 @@
+CREATE TABLE TAPE (ID INTEGER PRIMARY KEY, USER_ID INTEGER REFERENCES USER (ID) NOT NULL, TITLE TEXT NOT NULL, DESC TEXT, STATION_ID INTEGER REFERENCES STATION (ID) NOT NULL, AIR_DATE TEXT NOT NULL, STATUS TEXT NOT NULL, STATUS_MSG TEXT, CREATED_AT TEXT NOT NULL, UPDATED_AT TEXT, FS_PATH TEXT NOT NULL) STRICT;
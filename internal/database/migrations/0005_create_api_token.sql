@@ -0,0 +1 @@
+CREATE TABLE API_TOKEN (ID INTEGER PRIMARY KEY, USER_ID INTEGER REFERENCES USER (ID) NOT NULL, NAME TEXT NOT NULL, HASH TEXT NOT NULL UNIQUE, SCOPE TEXT NOT NULL, CREATED_AT TEXT NOT NULL, LAST_USED_AT TEXT, REVOKED_AT TEXT) STRICT;
@@ -0,0 +1 @@
+ALTER TABLE USER ADD COLUMN PASSWORD_HASH TEXT;
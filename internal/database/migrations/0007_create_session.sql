@@ -0,0 +1 @@
+CREATE TABLE SESSION (ID INTEGER PRIMARY KEY, USER_ID INTEGER REFERENCES USER (ID) NOT NULL, HASH TEXT NOT NULL UNIQUE, CSRF TEXT NOT NULL, CREATED_AT TEXT NOT NULL, EXPIRES_AT TEXT NOT NULL) STRICT;
@@ -0,0 +1 @@
+CREATE TABLE AUDIT (ID INTEGER PRIMARY KEY, USER_ID INTEGER NOT NULL, ACTOR TEXT NOT NULL, ACTION TEXT NOT NULL, DETAIL TEXT, CREATED_AT TEXT NOT NULL) STRICT;
@@ -0,0 +1,2 @@
+ALTER TABLE STATION ADD COLUMN LOGO_PATH TEXT;
+ALTER TABLE STATION ADD COLUMN TIME_ZONE TEXT;
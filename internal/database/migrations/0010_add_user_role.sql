@@ -0,0 +1 @@
+ALTER TABLE USER ADD COLUMN ROLE TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,2 @@
+CREATE TABLE TAPE_TRACK (ID INTEGER PRIMARY KEY, TAPE_ID INTEGER REFERENCES TAPE (ID) NOT NULL, SEQ INTEGER NOT NULL, ARTIST TEXT NOT NULL, TITLE TEXT NOT NULL) STRICT;
+CREATE INDEX TAPE_TRACK_TAPE_ID ON TAPE_TRACK (TAPE_ID, SEQ);
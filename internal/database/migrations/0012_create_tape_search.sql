@@ -0,0 +1,56 @@
+-- Full-text index of each tape, the rowid is the TAPE.ID.
+-- TRACKS holds one "artist - title" line per track in playlist order.
+CREATE VIRTUAL TABLE TAPE_SEARCH USING fts5(TITLE, DESC, STATION, TRACKS, tokenize = 'unicode61 remove_diacritics 2');
+
+CREATE TRIGGER TAPE_SEARCH_TAPE_INSERT AFTER INSERT ON TAPE
+BEGIN
+  INSERT INTO TAPE_SEARCH (rowid, TITLE, DESC, STATION, TRACKS)
+  VALUES (NEW.ID, NEW.TITLE, COALESCE(NEW.DESC, ''), (SELECT CALL_LETTERS FROM STATION WHERE ID = NEW.STATION_ID), '');
+END;
+
+CREATE TRIGGER TAPE_SEARCH_TAPE_UPDATE AFTER UPDATE OF TITLE, DESC, STATION_ID ON TAPE
+BEGIN
+  UPDATE TAPE_SEARCH
+  SET TITLE = NEW.TITLE, DESC = COALESCE(NEW.DESC, ''), STATION = (SELECT CALL_LETTERS FROM STATION WHERE ID = NEW.STATION_ID)
+  WHERE rowid = NEW.ID;
+END;
+
+CREATE TRIGGER TAPE_SEARCH_TAPE_DELETE AFTER DELETE ON TAPE
+BEGIN
+  DELETE FROM TAPE_SEARCH WHERE rowid = OLD.ID;
+END;
+
+CREATE TRIGGER TAPE_SEARCH_STATION_UPDATE AFTER UPDATE OF CALL_LETTERS ON STATION
+BEGIN
+  UPDATE TAPE_SEARCH SET STATION = NEW.CALL_LETTERS
+  WHERE rowid IN (SELECT ID FROM TAPE WHERE STATION_ID = NEW.ID);
+END;
+
+CREATE TRIGGER TAPE_SEARCH_TRACK_INSERT AFTER INSERT ON TAPE_TRACK
+BEGIN
+  UPDATE TAPE_SEARCH
+  SET TRACKS = (SELECT COALESCE(group_concat(ARTIST || ' - ' || TITLE, char(10)), '')
+                FROM (SELECT ARTIST, TITLE FROM TAPE_TRACK WHERE TAPE_ID = NEW.TAPE_ID ORDER BY SEQ))
+  WHERE rowid = NEW.TAPE_ID;
+END;
+
+CREATE TRIGGER TAPE_SEARCH_TRACK_UPDATE AFTER UPDATE ON TAPE_TRACK
+BEGIN
+  UPDATE TAPE_SEARCH
+  SET TRACKS = (SELECT COALESCE(group_concat(ARTIST || ' - ' || TITLE, char(10)), '')
+                FROM (SELECT ARTIST, TITLE FROM TAPE_TRACK WHERE TAPE_ID = NEW.TAPE_ID ORDER BY SEQ))
+  WHERE rowid = NEW.TAPE_ID;
+END;
+
+CREATE TRIGGER TAPE_SEARCH_TRACK_DELETE AFTER DELETE ON TAPE_TRACK
+BEGIN
+  UPDATE TAPE_SEARCH
+  SET TRACKS = (SELECT COALESCE(group_concat(ARTIST || ' - ' || TITLE, char(10)), '')
+                FROM (SELECT ARTIST, TITLE FROM TAPE_TRACK WHERE TAPE_ID = OLD.TAPE_ID ORDER BY SEQ))
+  WHERE rowid = OLD.TAPE_ID;
+END;
+
+-- index the tapes that already exist, they have no tracks yet
+INSERT INTO TAPE_SEARCH (rowid, TITLE, DESC, STATION, TRACKS)
+SELECT T.ID, T.TITLE, COALESCE(T.DESC, ''), S.CALL_LETTERS, ''
+FROM TAPE T INNER JOIN STATION S ON T.STATION_ID = S.ID;
@@ -0,0 +1,2 @@
+ALTER TABLE TAPE ADD COLUMN DURATION INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX TAPE_USER_AIR_DATE ON TAPE (USER_ID, AIR_DATE, ID);
@@ -0,0 +1,2 @@
+ALTER TABLE TAPE ADD COLUMN DELETED_AT TEXT;
+CREATE INDEX TAPE_DELETED_AT ON TAPE (DELETED_AT) WHERE DELETED_AT IS NOT NULL;
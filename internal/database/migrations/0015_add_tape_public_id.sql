@@ -0,0 +1,3 @@
+ALTER TABLE TAPE ADD COLUMN PUBLIC_ID TEXT;
+UPDATE TAPE SET PUBLIC_ID = lower(hex(randomblob(12)));
+CREATE UNIQUE INDEX TAPE_PUBLIC_ID ON TAPE (PUBLIC_ID);
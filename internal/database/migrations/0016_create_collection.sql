@@ -0,0 +1,4 @@
+CREATE TABLE COLLECTION (ID INTEGER PRIMARY KEY, PUBLIC_ID TEXT NOT NULL UNIQUE, USER_ID INTEGER REFERENCES USER (ID) NOT NULL, NAME TEXT NOT NULL, DESC TEXT NOT NULL DEFAULT '', SHARE_TOKEN TEXT UNIQUE, CREATED_AT TEXT NOT NULL, UPDATED_AT TEXT) STRICT;
+CREATE INDEX COLLECTION_USER_ID ON COLLECTION (USER_ID);
+CREATE TABLE COLLECTION_TAPE (COLLECTION_ID INTEGER REFERENCES COLLECTION (ID) NOT NULL, TAPE_ID INTEGER REFERENCES TAPE (ID) NOT NULL, SEQ INTEGER NOT NULL, PRIMARY KEY (COLLECTION_ID, TAPE_ID)) STRICT;
+CREATE INDEX COLLECTION_TAPE_TAPE_ID ON COLLECTION_TAPE (TAPE_ID);
@@ -0,0 +1,4 @@
+CREATE TABLE SHARE_GRANT (ID INTEGER PRIMARY KEY, USER_ID INTEGER REFERENCES USER (ID) NOT NULL, TAPE_ID INTEGER REFERENCES TAPE (ID), COLLECTION_ID INTEGER REFERENCES COLLECTION (ID), ACCESS TEXT NOT NULL CHECK (ACCESS IN ('view', 'edit')), GRANTED_BY TEXT NOT NULL, CREATED_AT TEXT NOT NULL, CHECK ((TAPE_ID IS NULL) <> (COLLECTION_ID IS NULL))) STRICT;
+CREATE UNIQUE INDEX SHARE_GRANT_TAPE ON SHARE_GRANT (TAPE_ID, USER_ID) WHERE TAPE_ID IS NOT NULL;
+CREATE UNIQUE INDEX SHARE_GRANT_COLLECTION ON SHARE_GRANT (COLLECTION_ID, USER_ID) WHERE COLLECTION_ID IS NOT NULL;
+CREATE INDEX SHARE_GRANT_USER_ID ON SHARE_GRANT (USER_ID);
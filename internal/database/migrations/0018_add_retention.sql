@@ -0,0 +1,5 @@
+ALTER TABLE TAPE ADD COLUMN STARRED INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE USER ADD COLUMN KEEP_LAST INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE USER ADD COLUMN KEEP_DAYS INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE COLLECTION ADD COLUMN KEEP_LAST INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE COLLECTION ADD COLUMN KEEP_DAYS INTEGER NOT NULL DEFAULT 0;
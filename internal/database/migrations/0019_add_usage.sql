@@ -0,0 +1,3 @@
+ALTER TABLE TAPE ADD COLUMN SIZE INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE USER ADD COLUMN BYTES_USED INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE USER ADD COLUMN QUOTA_BYTES INTEGER NOT NULL DEFAULT -1;
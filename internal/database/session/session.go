@@ -0,0 +1,158 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// Session is a signed in browser for the built-in authentication mode.
+// The session secret lives in a cookie and only its hash is stored.
+type Session struct {
+	Id     int64
+	UserId int64
+	Hash   string
+	// Csrf must accompany every state changing request of the session.
+	Csrf    string
+	Created string
+	Expires string
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("session %d user %d expires %s", s.Id, s.UserId, s.Expires)
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// NewCsrf returns a CSRF token for a form shown before there is a session.
+func NewCsrf() (string, error) {
+	return randomString()
+}
+
+// New creates a session for the user lasting ttl and returns it with its secret.
+func New(userId int64, ttl time.Duration) (Session, string, error) {
+	secret, err := randomString()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	csrf, err := randomString()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	// UTC so timestamps compare correctly as text in SQL.
+	now := time.Now().UTC()
+	session := Session{
+		UserId:  userId,
+		Hash:    Hash(secret),
+		Csrf:    csrf,
+		Created: now.Format(time.RFC3339),
+		Expires: now.Add(ttl).Format(time.RFC3339),
+	}
+
+	return session, secret, nil
+}
+
+// Hash returns the value stored in the database for a session secret.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionCreator(stmt *sqlite.Stmt) (*Session, error) {
+	return &Session{
+		Id:      stmt.GetInt64("ID"),
+		UserId:  stmt.GetInt64("USER_ID"),
+		Hash:    stmt.GetText("HASH"),
+		Csrf:    stmt.GetText("CSRF"),
+		Created: stmt.GetText("CREATED_AT"),
+		Expires: stmt.GetText("EXPIRES_AT"),
+	}, nil
+}
+
+// Insert adds the session and sets its Id.
+func Insert(db database.Runner, s *Session) error {
+	log.Println("enter InsertSession", s)
+	defer log.Println("exit InsertSession")
+
+	return db.RunQuery(database.Query{
+		Name:           "InsertSession",
+		Sql:            "INSERT INTO SESSION (USER_ID, HASH, CSRF, CREATED_AT, EXPIRES_AT) VALUES(:userId, :hash, :csrf, :created, :expires) RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":userId":  s.UserId,
+			":hash":    s.Hash,
+			":csrf":    s.Csrf,
+			":created": s.Created,
+			":expires": s.Expires,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s.Id = stmt.GetInt64("ID")
+			return nil
+		},
+	})
+}
+
+// Lookup returns the unexpired session matching the secret or nil when not found.
+func Lookup(db database.Runner, secret string) (*Session, error) {
+	log.Println("enter LookupSession")
+	defer log.Println("exit LookupSession")
+
+	var session *Session
+	err := db.RunQuery(database.Query{
+		Name:           "LookupSession",
+		Sql:            "SELECT * FROM SESSION WHERE HASH=:hash AND EXPIRES_AT > :now;",
+		Named:          map[string]any{":hash": Hash(secret), ":now": time.Now().UTC().Format(time.RFC3339)},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s, err := sessionCreator(stmt)
+			if err == nil {
+				session = s
+			}
+			return err
+		},
+	})
+
+	return session, err
+}
+
+// Delete ends the session with the given secret.
+func Delete(db database.Runner, secret string) error {
+	log.Println("enter DeleteSession")
+	defer log.Println("exit DeleteSession")
+
+	return db.RunQuery(database.Query{
+		Name:           "DeleteSession",
+		Sql:            "DELETE FROM SESSION WHERE HASH=:hash;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":hash": Hash(secret)},
+	})
+}
+
+// DeleteExpired removes every session that has expired.
+func DeleteExpired(db database.Runner) error {
+	log.Println("enter DeleteExpiredSessions")
+	defer log.Println("exit DeleteExpiredSessions")
+
+	return db.RunQuery(database.Query{
+		Name:           "DeleteExpiredSessions",
+		Sql:            "DELETE FROM SESSION WHERE EXPIRES_AT <= :now;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":now": time.Now().UTC().Format(time.RFC3339)},
+	})
+}
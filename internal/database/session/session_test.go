@@ -0,0 +1,103 @@
+package session_test
+
+import (
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/session"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+	"time"
+)
+
+const dbPath = "./unit-test.db"
+
+func setup(t *testing.T) *database.Database {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// owner of the rows under test, ID 1
+	err = user.Insert(db, user.New("tapedeck.us@gmail.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	db := setup(t)
+
+	s, secret, err := session.New(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := session.Lookup(db, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found == nil || found.Id != s.Id || found.Csrf == "" {
+		t.Fatalf("session not found by secret: %v", found)
+	}
+
+	err = session.Delete(db, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = session.Lookup(db, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found != nil {
+		t.Fatalf("deleted session still found: %v", found)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	db := setup(t)
+
+	s, secret, err := session.New(1, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := session.Lookup(db, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found != nil {
+		t.Fatalf("expired session found: %v", found)
+	}
+
+	err = session.DeleteExpired(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package station
+
+import (
+	"context"
+	"log"
+	"tapedeck/internal/database"
+)
+
+// Seeds are the stations described in RADIO.md.
+var Seeds = []Station{
+	{
+		CallLetters:   "WMBR",
+		Freq:          "88.1",
+		Desc:          "MIT campus radio, Cambridge MA",
+		HomepageUrl:   "https://wmbr.org",
+		ArchivesUrl:   "https://wmbr.org/cgi-bin/arch",
+		LiveStreamUrl: "https://wmbr.org:8002/hi",
+		TimeZone:      "America/New_York",
+	},
+	{
+		CallLetters:   "WHRB",
+		Freq:          "95.3",
+		Desc:          "Harvard Radio Broadcasting, Cambridge MA",
+		HomepageUrl:   "https://whrb.org",
+		ArchivesUrl:   "https://whrb.org/stream-archive/",
+		LiveStreamUrl: "https://stream.whrb.org/whrb-he-aac",
+		TimeZone:      "America/New_York",
+	},
+	{
+		CallLetters:   "WUMB",
+		Freq:          "91.9",
+		Desc:          "UMass Boston folk radio",
+		HomepageUrl:   "https://wumb.org",
+		ArchivesUrl:   "http://50.241.124.209:8080/cgi-bin/archive/archive1.pl",
+		LiveStreamUrl: "https://wumb.streamguys1.com/wumb919fast",
+		TimeZone:      "America/New_York",
+	},
+	{
+		CallLetters:   "WOMR",
+		Freq:          "92.1",
+		Desc:          "Outermost Community Radio, Provincetown MA",
+		HomepageUrl:   "https://womr.org",
+		ArchivesUrl:   "https://womr.org/schedule/broadcast-archive/",
+		LiveStreamUrl: "https://womr.streamguys1.com/live",
+		TimeZone:      "America/New_York",
+	},
+	{
+		CallLetters:   "WCUW",
+		Freq:          "91.3",
+		Desc:          "Community radio, Worcester MA",
+		HomepageUrl:   "https://wcuw.org",
+		ArchivesUrl:   "https://spinitron.com/WCUW/calendar",
+		LiveStreamUrl: "https://peridot.streamguys1.com:5495/live",
+		TimeZone:      "America/New_York",
+	},
+}
+
+// Seed inserts the [Seeds] whose call letters are not in the table yet,
+// so it is safe to run again.  It returns the number of stations added.
+func Seed(db database.Runner) (int, error) {
+	log.Println("enter SeedStations")
+	defer log.Println("exit SeedStations")
+
+	count := 0
+	err := db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		for _, seed := range Seeds {
+			existing, err := GetByCallLetters(tx, seed.CallLetters)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				continue
+			}
+
+			s := seed
+			err = Insert(tx, &s)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
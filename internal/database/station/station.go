@@ -0,0 +1,236 @@
+package station
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// ErrInUse is returned when deleting a station that tapes still reference.
+var ErrInUse = errors.New("station in use")
+
+// Station is a radio station that tapes are recorded from.
+type Station struct {
+	Id          int64  `json:"id"`
+	CallLetters string `json:"callLetters"`
+	Freq        string `json:"freq"`
+	Desc        string `json:"desc"`
+	HomepageUrl string `json:"homepageUrl"`
+	// ArchivesUrl is the page listing the station's archived shows.
+	ArchivesUrl string `json:"archivesUrl"`
+	// LiveStreamUrl is the station's live audio stream.
+	LiveStreamUrl string `json:"liveStreamUrl"`
+	// TimeZone is the IANA name of the zone the station's schedule and
+	// air dates are in, such as America/New_York.
+	TimeZone string `json:"timeZone"`
+	// LogoPath is the logo image relative to the server's user directory.
+	// It is only changed by [SetLogo].
+	LogoPath string `json:"-"`
+}
+
+func (s *Station) String() string {
+	return fmt.Sprintf("station %d %q", s.Id, s.CallLetters)
+}
+
+// Validate checks that the required fields are populated.
+func (s *Station) Validate() error {
+	if s.CallLetters == "" {
+		return fmt.Errorf("call letters required")
+	}
+	if s.Freq == "" {
+		return fmt.Errorf("frequency required")
+	}
+	if s.HomepageUrl == "" {
+		return fmt.Errorf("homepage url required")
+	}
+	if s.TimeZone != "" {
+		if _, err := time.LoadLocation(s.TimeZone); err != nil {
+			return fmt.Errorf("unknown time zone %q", s.TimeZone)
+		}
+	}
+	return nil
+}
+
+const stationSelectSql = "SELECT ID, CALL_LETTERS, FREQ, DESC, HOMEPAGE_URL, ARCHIVES_URL, LIVE_STREAM_URL, TIME_ZONE, LOGO_PATH FROM STATION"
+
+func stationCreator(stmt *sqlite.Stmt) (*Station, error) {
+	return &Station{
+		Id:            stmt.GetInt64("ID"),
+		CallLetters:   stmt.GetText("CALL_LETTERS"),
+		Freq:          stmt.GetText("FREQ"),
+		Desc:          stmt.GetText("DESC"),
+		HomepageUrl:   stmt.GetText("HOMEPAGE_URL"),
+		ArchivesUrl:   stmt.GetText("ARCHIVES_URL"),
+		LiveStreamUrl: stmt.GetText("LIVE_STREAM_URL"),
+		TimeZone:      stmt.GetText("TIME_ZONE"),
+		LogoPath:      stmt.GetText("LOGO_PATH"),
+	}, nil
+}
+
+// GetAll returns the stations ordered by call letters.
+func GetAll(db database.Runner, page database.Page) ([]*Station, error) {
+	log.Println("enter GetAllStations", page)
+	defer log.Println("exit GetAllStations")
+
+	stations := make([]*Station, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetAllStations",
+		Sql:            stationSelectSql + " ORDER BY CALL_LETTERS, ID LIMIT :limit OFFSET :offset;",
+		Named:          map[string]any{":limit": page.Limit, ":offset": page.Offset},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s, err := stationCreator(stmt)
+			if err == nil {
+				stations = append(stations, s)
+			}
+			return err
+		},
+	})
+
+	return stations, err
+}
+
+// Get returns the station with the given id or nil when not found.
+func Get(db database.Runner, id int64) (*Station, error) {
+	log.Println("enter GetStation", id)
+	defer log.Println("exit GetStation", id)
+
+	var station *Station
+	err := db.RunQuery(database.Query{
+		Name:           "GetStation",
+		Sql:            stationSelectSql + " WHERE ID=:id;",
+		Named:          map[string]any{":id": id},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s, err := stationCreator(stmt)
+			if err == nil {
+				station = s
+			}
+			return err
+		},
+	})
+
+	return station, err
+}
+
+// Insert adds the station and sets its Id.
+func Insert(db database.Runner, s *Station) error {
+	log.Println("enter InsertStation", s)
+	defer log.Println("exit InsertStation")
+
+	return db.RunQuery(database.Query{
+		Name: "InsertStation",
+		Sql: "INSERT INTO STATION (CALL_LETTERS, FREQ, DESC, HOMEPAGE_URL, ARCHIVES_URL, LIVE_STREAM_URL, TIME_ZONE) " +
+			"VALUES(:callLetters, :freq, :desc, :homepage, :archives, :live, :timeZone) RETURNING ID;",
+		PerformsUpdate: true,
+		Named:          stationParams(s),
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s.Id = stmt.GetInt64("ID")
+			return nil
+		},
+	})
+}
+
+// Update saves all fields of the station except its logo.
+func Update(db database.Runner, s *Station) error {
+	log.Println("enter UpdateStation", s)
+	defer log.Println("exit UpdateStation")
+
+	named := stationParams(s)
+	named[":id"] = s.Id
+
+	return db.RunQuery(database.Query{
+		Name: "UpdateStation",
+		Sql: "UPDATE STATION SET CALL_LETTERS=:callLetters, FREQ=:freq, DESC=:desc, HOMEPAGE_URL=:homepage, " +
+			"ARCHIVES_URL=:archives, LIVE_STREAM_URL=:live, TIME_ZONE=:timeZone WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          named,
+	})
+}
+
+// GetByCallLetters returns the station with the call letters or nil when not found.
+func GetByCallLetters(db database.Runner, callLetters string) (*Station, error) {
+	log.Println("enter GetStationByCallLetters", callLetters)
+	defer log.Println("exit GetStationByCallLetters", callLetters)
+
+	var station *Station
+	err := db.RunQuery(database.Query{
+		Name:           "GetStationByCallLetters",
+		Sql:            stationSelectSql + " WHERE CALL_LETTERS=:callLetters ORDER BY ID LIMIT 1;",
+		Named:          map[string]any{":callLetters": callLetters},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s, err := stationCreator(stmt)
+			if err == nil {
+				station = s
+			}
+			return err
+		},
+	})
+
+	return station, err
+}
+
+// SetLogo records the station's logo file, relative to the user directory.
+func SetLogo(db database.Runner, id int64, logoPath string) error {
+	log.Println("enter SetStationLogo", id, logoPath)
+	defer log.Println("exit SetStationLogo")
+
+	return db.RunQuery(database.Query{
+		Name:           "SetStationLogo",
+		Sql:            "UPDATE STATION SET LOGO_PATH=:logoPath WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":logoPath": logoPath},
+	})
+}
+
+// Delete removes the station.  It fails when tapes still reference it.
+func Delete(db database.Runner, id int64) error {
+	log.Println("enter DeleteStation", id)
+	defer log.Println("exit DeleteStation")
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		var count int64
+		err := tx.RunQuery(database.Query{
+			Name:           "CountStationTapes",
+			Sql:            "SELECT COUNT(*) FROM TAPE WHERE STATION_ID=:id;",
+			Named:          map[string]any{":id": id},
+			PerformsUpdate: false,
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				count = stmt.ColumnInt64(0)
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return fmt.Errorf("%w: station %d is used by %d tapes", ErrInUse, id, count)
+		}
+
+		return tx.RunQuery(database.Query{
+			Name:           "DeleteStation",
+			Sql:            "DELETE FROM STATION WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		})
+	})
+}
+
+func stationParams(s *Station) map[string]any {
+	return map[string]any{
+		":callLetters": s.CallLetters,
+		":freq":        s.Freq,
+		":desc":        s.Desc,
+		":homepage":    s.HomepageUrl,
+		":archives":    s.ArchivesUrl,
+		":live":        s.LiveStreamUrl,
+		":timeZone":    s.TimeZone,
+	}
+}
@@ -0,0 +1,178 @@
+package station_test
+
+import (
+	"errors"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+const dbPath = "./unit-test.db"
+
+func setup(t *testing.T) *database.Database {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+func TestStationCrud(t *testing.T) {
+	db := setup(t)
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err := station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Id == 0 {
+		t.Fatalf("station id not set after insert")
+	}
+
+	s.Desc = "MIT radio"
+	err = station.Update(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := station.Get(db, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil || got.Desc != s.Desc {
+		t.Fatalf("station not updated: expected %q, actual %v", s.Desc, got)
+	}
+
+	all, err := station.GetAll(db, database.AllRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 1 {
+		t.Fatalf("expected 1 station, actual %d", len(all))
+	}
+
+	err = station.Delete(db, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = station.Get(db, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != nil {
+		t.Fatalf("station not deleted: %v", got)
+	}
+}
+
+func TestStationDeleteInUse(t *testing.T) {
+	db := setup(t)
+
+	s := station.Station{CallLetters: "WHRB", Freq: "95.3", HomepageUrl: "https://whrb.org"}
+	err := station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Insert(db, user.New("tapedeck.us@gmail.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RunQuery(database.Query{
+		Name:           "InsertTestTape",
+		Sql:            "INSERT INTO TAPE (USER_ID, TITLE, STATION_ID, AIR_DATE, STATUS, CREATED_AT, FS_PATH) VALUES(1, 'x', :id, 'now', 'todo', 'now', 'x');",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": s.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = station.Delete(db, s.Id)
+	if !errors.Is(err, station.ErrInUse) {
+		t.Fatalf("expected ErrInUse, actual %v", err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	db := setup(t)
+
+	count, err := station.Seed(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(station.Seeds) {
+		t.Fatalf("expected %d stations seeded, actual %d", len(station.Seeds), count)
+	}
+
+	// a second run adds nothing
+	count, err = station.Seed(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no stations seeded twice, actual %d", count)
+	}
+
+	s, err := station.GetByCallLetters(db, "WHRB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || s.TimeZone != "America/New_York" {
+		t.Fatalf("unexpected seeded station %v", s)
+	}
+}
+
+func TestTimeZoneAndLogo(t *testing.T) {
+	db := setup(t)
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org", TimeZone: "Mars/Olympus_Mons"}
+	if s.Validate() == nil {
+		t.Fatalf("expected unknown time zone to be rejected")
+	}
+
+	s.TimeZone = "America/New_York"
+	err := station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = station.SetLogo(db, s.Id, "station/wmbr.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Update keeps the logo
+	s.Desc = "MIT radio"
+	err = station.Update(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := station.Get(db, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LogoPath != "station/wmbr.png" || actual.TimeZone != "America/New_York" || actual.Desc != "MIT radio" {
+		t.Fatalf("unexpected station %+v", actual)
+	}
+}
@@ -0,0 +1,164 @@
+package tape
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"tapedeck/internal/database"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// ErrNotFound is returned for a tape that does not exist and for one the
+// user may not see, so the ids of other users' tapes cannot be probed.
+var ErrNotFound = errors.New("tape not found")
+
+// Access is what a user may do with a tape.  Each level allows everything
+// the ones below it do.
+type Access int
+
+const (
+	AccessNone Access = iota
+	// AccessRead allows playing the tape and reading its details.
+	AccessRead
+	// AccessWrite allows changing the details, sources and tracks.
+	AccessWrite
+	// AccessOwner also allows moving the tape to the trash and back.
+	AccessOwner
+)
+
+// CanWrite reports whether the access allows changing the tape, for
+// templates.
+func (a Access) CanWrite() bool {
+	return a >= AccessWrite
+}
+
+// IsOwner reports whether the access is the owner's, for templates.
+func (a Access) IsOwner() bool {
+	return a == AccessOwner
+}
+
+// newPublicId returns a random id for the tape's URLs, in the same format
+// as the ids given to existing tapes by migration 0015.
+func newPublicId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessSql finds the best grant the user has to the tape, given directly
+// or through one of the owner's collections it is in.  Collections only
+// give read access.  Grants stop working while the owner is disabled,
+// 'enabled' is user.StatusEnabled.
+const accessSql = "SELECT MAX(CASE WHEN G.TAPE_ID IS NOT NULL AND G.ACCESS='edit' THEN 2 ELSE 1 END) AS ACCESS FROM SHARE_GRANT G " +
+	"WHERE G.USER_ID=:userId AND (G.TAPE_ID=:tapeId OR G.COLLECTION_ID IN " +
+	"(SELECT M.COLLECTION_ID FROM COLLECTION_TAPE M INNER JOIN COLLECTION C ON C.ID = M.COLLECTION_ID WHERE M.TAPE_ID=:tapeId AND C.USER_ID=:ownerId)) " +
+	"AND EXISTS (SELECT 1 FROM USER O WHERE O.ID=:ownerId AND O.STATUS='enabled');"
+
+// AccessFor returns what the user may do with the tape, as its owner or
+// through the grants of SHARE_GRANT.  Only the owner sees a tape in the
+// trash.
+func AccessFor(db database.Runner, t *Tape, userId int64) (Access, error) {
+	if t.UserId == userId {
+		return AccessOwner, nil
+	}
+	if t.Deleted != "" {
+		return AccessNone, nil
+	}
+
+	access := AccessNone
+	err := db.RunQuery(database.Query{
+		Name:           "GetTapeAccess",
+		Sql:            accessSql,
+		Named:          map[string]any{":userId": userId, ":tapeId": t.Id, ":ownerId": t.UserId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			switch stmt.GetInt64("ACCESS") {
+			case 1:
+				access = AccessRead
+			case 2:
+				access = AccessWrite
+			}
+			return nil
+		},
+	})
+
+	return access, err
+}
+
+// GetSharedWith returns the tapes other users gave the user access to,
+// directly or through a collection, newest first.  Tapes in the trash and
+// those of disabled owners are left out.
+func GetSharedWith(db database.Runner, userId int64) ([]*Tape, error) {
+	log.Println("enter GetTapesSharedWith", userId)
+	defer log.Println("exit GetTapesSharedWith")
+
+	tapes := make([]*Tape, 0)
+	err := db.RunQuery(database.Query{
+		Name: "GetTapesSharedWith",
+		Sql: tapeSelectSql + " WHERE T.USER_ID<>:userId AND T.DELETED_AT IS NULL" +
+			" AND EXISTS (SELECT 1 FROM USER O WHERE O.ID = T.USER_ID AND O.STATUS='enabled')" +
+			" AND (T.ID IN (SELECT G.TAPE_ID FROM SHARE_GRANT G WHERE G.USER_ID=:userId)" +
+			" OR T.ID IN (SELECT M.TAPE_ID FROM SHARE_GRANT G INNER JOIN COLLECTION C ON C.ID = G.COLLECTION_ID" +
+			" INNER JOIN COLLECTION_TAPE M ON M.COLLECTION_ID = C.ID WHERE G.USER_ID=:userId AND C.USER_ID = T.USER_ID))" +
+			" ORDER BY T.AIR_DATE DESC, T.ID DESC;",
+		Named:          map[string]any{":userId": userId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tape, err := tapeCreator(stmt)
+			if err == nil {
+				tapes = append(tapes, tape)
+			}
+			return err
+		},
+	})
+
+	return tapes, err
+}
+
+// GetByPublicId returns the tape with the public id, or nil.
+func GetByPublicId(db database.Runner, publicId string) (*Tape, error) {
+	log.Println("enter GetTapeByPublicId", publicId)
+	defer log.Println("exit GetTapeByPublicId")
+
+	var tape *Tape
+	err := db.RunQuery(database.Query{
+		Name:           "GetTapeByPublicId",
+		Sql:            tapeSelectSql + " WHERE T.PUBLIC_ID=:publicId;",
+		PerformsUpdate: false,
+		Named:          map[string]any{":publicId": publicId},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t, err := tapeCreator(stmt)
+			tape = t
+			return err
+		},
+	})
+
+	return tape, err
+}
+
+// GetForUser returns the tape with the public id when the user has at
+// least the access asked for, and [ErrNotFound] otherwise.
+func GetForUser(db database.Runner, publicId string, userId int64, need Access) (*Tape, error) {
+	t, err := GetByPublicId(db, publicId)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrNotFound
+	}
+
+	access, err := AccessFor(db, t, userId)
+	if err != nil {
+		return nil, err
+	}
+	if access < need || access == AccessNone {
+		log.Printf("user %d has access %d to %v, needs %d\n", userId, access, t, need)
+		return nil, ErrNotFound
+	}
+
+	return t, nil
+}
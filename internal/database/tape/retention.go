@@ -0,0 +1,110 @@
+package tape
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// maxKeepLast and maxKeepDays keep typos from reading as "forever".
+	maxKeepLast = 1000
+	maxKeepDays = 100 * 365
+)
+
+// Retention is a rule for how long tapes are kept before the pruner
+// deletes them with their audio.  The zero value keeps everything.
+// Starred tapes and tapes still waiting or being recorded are always
+// kept.
+type Retention struct {
+	// KeepLast keeps the newest tapes of each group by air date and
+	// prunes the older ones, 0 keeps them all.
+	KeepLast int `json:"keepLast"`
+	// KeepDays prunes tapes that aired more than this many days ago, 0
+	// keeps them all.
+	KeepDays int `json:"keepDays"`
+}
+
+// Validate checks the rule's limits.
+func (r Retention) Validate() error {
+	if r.KeepLast < 0 || r.KeepLast > maxKeepLast {
+		return fmt.Errorf("keep last must be between 0 and %d", maxKeepLast)
+	}
+	if r.KeepDays < 0 || r.KeepDays > maxKeepDays {
+		return fmt.Errorf("keep days must be between 0 and %d", maxKeepDays)
+	}
+	return nil
+}
+
+// Active reports whether the rule prunes anything at all.
+func (r Retention) Active() bool {
+	return r.KeepLast > 0 || r.KeepDays > 0
+}
+
+// Expired is a tape a rule prunes, with the reason for the report.
+type Expired struct {
+	*Tape
+	Reason string
+}
+
+// Show groups tapes by the show they are an episode of, the tapes of a
+// station with the same title.
+func Show(t *Tape) string {
+	return fmt.Sprintf("%d %s", t.StationId, t.Title)
+}
+
+// Expired returns the tapes the rule prunes at now, in the order given.
+// group names the group of each tape [Retention.KeepLast] counts in.
+func (r Retention) Expired(tapes []*Tape, now time.Time, group func(t *Tape) string) []Expired {
+	if !r.Active() {
+		return nil
+	}
+
+	newest := make([]*Tape, len(tapes))
+	copy(newest, tapes)
+	sort.SliceStable(newest, func(i, j int) bool {
+		if newest[i].AirDate != newest[j].AirDate {
+			return newest[i].AirDate > newest[j].AirDate
+		}
+		return newest[i].Id > newest[j].Id
+	})
+
+	reasons := map[int64]string{}
+	if r.KeepLast > 0 {
+		seen := map[string]int{}
+		for _, t := range newest {
+			key := group(t)
+			seen[key]++
+			if seen[key] > r.KeepLast {
+				reasons[t.Id] = fmt.Sprintf("older than the last %d", r.KeepLast)
+			}
+		}
+	}
+	if r.KeepDays > 0 {
+		cutoff := now.AddDate(0, 0, -r.KeepDays).Format(time.DateOnly)
+		for _, t := range newest {
+			if t.AirDate < cutoff {
+				reasons[t.Id] = fmt.Sprintf("aired more than %d days ago", r.KeepDays)
+			}
+		}
+	}
+
+	expired := []Expired{}
+	for _, t := range tapes {
+		reason, ok := reasons[t.Id]
+		if !ok || !t.Prunable() {
+			continue
+		}
+		expired = append(expired, Expired{Tape: t, Reason: reason})
+	}
+	return expired
+}
+
+// Prunable reports whether retention rules may delete the tape.  Starred
+// tapes, tapes in the trash and tapes not done recording never are.
+func (t *Tape) Prunable() bool {
+	if t.Starred || t.Deleted != "" {
+		return false
+	}
+	return t.Status != StatusTodo && t.Status != StatusInProgress && t.Status != StatusPaused
+}
@@ -0,0 +1,315 @@
+package tape
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// MarkStart and MarkEnd surround the matched words in [Result.Snippet].
+// They are control characters so they survive HTML escaping and cannot
+// be typed into a title.
+const (
+	MarkStart = "\x02"
+	MarkEnd   = "\x03"
+)
+
+// ErrCursor is returned by [Search] for a page cursor it did not make or
+// that was made for another sort.
+var ErrCursor = errors.New("invalid page cursor")
+
+// snippetWords is about how many words a snippet shows around a match.
+const snippetWords = 12
+
+// The orders [Search] can return tapes in.
+const (
+	// SortRelevance puts the best matches of the query first.
+	SortRelevance = "relevance"
+	SortAirDate   = "airDate"
+	SortCreated   = "created"
+	SortTitle     = "title"
+	SortDuration  = "duration"
+)
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// sortColumns maps each sort to the expression it orders by.
+var sortColumns = map[string]string{
+	SortRelevance: "TAPE_SEARCH.rank",
+	SortAirDate:   "T.AIR_DATE",
+	SortCreated:   "T.CREATED_AT",
+	SortTitle:     "T.TITLE COLLATE NOCASE",
+	SortDuration:  "T.DURATION",
+}
+
+// Filter narrows the tapes returned by [Search] and sets their order.
+// Empty fields match every tape.
+type Filter struct {
+	// Query is the words to look for in the title, description, station
+	// and tracks.  Each word also matches longer words it starts.
+	Query     string
+	StationId int64
+	// From and To are the first and last air date, as YYYY-MM-DD.
+	From   string
+	To     string
+	Status string
+	// Sort is one of the Sort constants.  It defaults to [SortRelevance]
+	// with a query and [SortAirDate] without.
+	Sort string
+	// Order is [OrderAsc] or [OrderDesc].  It defaults to A to Z for
+	// titles and newest or longest first otherwise.  The best matches
+	// always come first with [SortRelevance].
+	Order string
+}
+
+// Validate checks the dates, status and sort.
+func (f *Filter) Validate() error {
+	for _, date := range []string{f.From, f.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return fmt.Errorf("date %q is not YYYY-MM-DD", date)
+		}
+	}
+
+	if _, ok := sortColumns[f.Sort]; f.Sort != "" && !ok {
+		return fmt.Errorf("unknown sort %q", f.Sort)
+	}
+	if f.Sort == SortRelevance && matchQuery(f.Query) == "" {
+		return fmt.Errorf("sorting by relevance needs a query")
+	}
+	if f.Order != "" && f.Order != OrderAsc && f.Order != OrderDesc {
+		return fmt.Errorf("order must be %q or %q", OrderAsc, OrderDesc)
+	}
+
+	switch f.Status {
+	case "", StatusTodo, StatusInProgress, StatusDone, StatusError, StatusPaused:
+		return nil
+	}
+	return fmt.Errorf("unknown status %q", f.Status)
+}
+
+// sortBy returns the sort to use after applying the defaults, and if it
+// is descending.
+func (f *Filter) sortBy() (string, bool) {
+	sort := f.Sort
+	if sort == "" {
+		sort = SortAirDate
+		if matchQuery(f.Query) != "" {
+			sort = SortRelevance
+		}
+	}
+
+	switch {
+	case sort == SortRelevance:
+		return sort, false
+	case f.Order != "":
+		return sort, f.Order == OrderDesc
+	default:
+		return sort, sort != SortTitle
+	}
+}
+
+// Result is a tape found by [Search].
+type Result struct {
+	*Tape
+	// Snippet is the part of the text that matched the query, with each
+	// match between [MarkStart] and [MarkEnd].  Empty without a query.
+	Snippet string
+	rank    float64
+}
+
+// sortKey is the value of the result that [Search] orders by.
+func (r *Result) sortKey(sort string) any {
+	switch sort {
+	case SortRelevance:
+		return r.rank
+	case SortCreated:
+		return r.Created
+	case SortTitle:
+		return r.Title
+	case SortDuration:
+		return r.Duration
+	default:
+		return r.AirDate
+	}
+}
+
+// cursor is the position after the last tape of a page, so the next page
+// starts there even when tapes were added or removed in between.
+type cursor struct {
+	Sort  string
+	Value any
+	Id    int64
+}
+
+// encode returns the cursor as an opaque string that is safe in a URL.
+func (c cursor) encode() string {
+	b, _ := json.Marshal([]any{c.Sort, c.Value, c.Id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reads a cursor made by [cursor.encode] for the given sort.
+func decodeCursor(s string, sort string) (cursor, error) {
+	var c cursor
+	invalid := fmt.Errorf("%w %q", ErrCursor, s)
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, invalid
+	}
+
+	var parts []any
+	if json.Unmarshal(b, &parts) != nil || len(parts) != 3 {
+		return c, invalid
+	}
+
+	name, nameOk := parts[0].(string)
+	id, idOk := parts[2].(float64)
+	if !nameOk || !idOk || name != sort {
+		return c, invalid
+	}
+
+	// numbers come back from JSON as float64 which SQLite compares
+	// with integer columns just fine
+	switch parts[1].(type) {
+	case float64:
+		if sort != SortRelevance && sort != SortDuration {
+			return c, invalid
+		}
+	case string:
+		if sort == SortRelevance || sort == SortDuration {
+			return c, invalid
+		}
+	default:
+		return c, invalid
+	}
+
+	return cursor{Sort: name, Value: parts[1], Id: int64(id)}, nil
+}
+
+// matchQuery turns what the user typed into an FTS5 query.  Every word is
+// quoted so FTS5 operators and punctuation are taken literally, and is a
+// prefix so "coltr" finds Coltrane.
+func matchQuery(query string) string {
+	terms := []string{}
+	for _, word := range strings.Fields(query) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search returns up to limit of the user's tapes matching the filter, in
+// the filter's order, starting after the cursor returned with the previous
+// page.  Tapes in the trash are left out.  The returned cursor is empty on
+// the last page.  A negative limit returns every tape.
+func Search(db database.Runner, userId int64, filter Filter, after string, limit int) ([]*Result, string, error) {
+	log.Println("enter SearchTapes", userId, filter, after, limit)
+	defer log.Println("exit SearchTapes")
+
+	err := filter.Validate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort, desc := filter.sortBy()
+	named := map[string]any{":userId": userId, ":limit": limit}
+	if limit >= 0 {
+		// one more tells if there is a next page
+		named[":limit"] = limit + 1
+	}
+
+	var sql strings.Builder
+	match := matchQuery(filter.Query)
+	if match != "" {
+		sql.WriteString("SELECT " + tapeColumns + ", TAPE_SEARCH.rank AS RANK, ")
+		sql.WriteString(fmt.Sprintf("snippet(TAPE_SEARCH, -1, char(2), char(3), '…', %d) AS SNIPPET ", snippetWords))
+		sql.WriteString("FROM TAPE_SEARCH INNER JOIN TAPE T ON T.ID = TAPE_SEARCH.rowid INNER JOIN STATION S ON T.STATION_ID = S.ID ")
+		sql.WriteString("WHERE TAPE_SEARCH MATCH :match AND T.USER_ID=:userId AND T.DELETED_AT IS NULL")
+		named[":match"] = match
+	} else {
+		sql.WriteString(tapeSelectSql + " WHERE T.USER_ID=:userId AND T.DELETED_AT IS NULL")
+	}
+
+	if filter.StationId != 0 {
+		sql.WriteString(" AND T.STATION_ID=:stationId")
+		named[":stationId"] = filter.StationId
+	}
+	if filter.From != "" {
+		sql.WriteString(" AND T.AIR_DATE>=:from")
+		named[":from"] = filter.From
+	}
+	if filter.To != "" {
+		sql.WriteString(" AND T.AIR_DATE<=:to")
+		named[":to"] = filter.To
+	}
+	if filter.Status != "" {
+		sql.WriteString(" AND T.STATUS=:status")
+		named[":status"] = filter.Status
+	}
+
+	column, cmp, dir := sortColumns[sort], ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if after != "" {
+		c, err := decodeCursor(after, sort)
+		if err != nil {
+			return nil, "", err
+		}
+		sql.WriteString(fmt.Sprintf(" AND (%[1]s %[2]s :after OR (%[1]s = :after AND T.ID %[2]s :afterId))", column, cmp))
+		named[":after"] = c.Value
+		named[":afterId"] = c.Id
+	}
+
+	sql.WriteString(fmt.Sprintf(" ORDER BY %s %s, T.ID %s LIMIT :limit;", column, dir, dir))
+
+	results := make([]*Result, 0)
+	err = db.RunQuery(database.Query{
+		Name:           "SearchTapes",
+		Sql:            sql.String(),
+		Named:          named,
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t, err := tapeCreator(stmt)
+			if err != nil {
+				return err
+			}
+
+			r := &Result{Tape: t}
+			if match != "" {
+				r.Snippet = stmt.GetText("SNIPPET")
+				r.rank = stmt.GetFloat("RANK")
+			}
+			results = append(results, r)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+		last := results[limit-1]
+		next = cursor{Sort: sort, Value: last.sortKey(sort), Id: last.Id}.encode()
+	}
+
+	return results, next, nil
+}
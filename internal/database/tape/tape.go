@@ -1,10 +1,14 @@
 package tape
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"path/filepath"
 	"tapedeck/internal/database"
+	"time"
 
+	"github.com/google/uuid"
 	"zombiezen.com/go/sqlite"
 )
 
@@ -17,25 +21,46 @@ const (
 	StatusDone = "done"
 	// Tape processing failed with an error
 	StatusError = "error"
+	// Tape is waiting but its owner is disabled
+	StatusPaused = "paused"
 )
 
 // Tape is the digital equivalent to a physical cassette tape.
 // It holds something recorded off the radio.
 type Tape struct {
-	Id int64
+	// Id is only used inside the server, URLs name tapes by PublicId so
+	// they do not give away how many tapes there are.
+	Id       int64  `json:"-"`
+	PublicId string `json:"id"`
+	// UserId is the owner of the tape.
+	UserId    int64 `json:"-"`
+	StationId int64 `json:"stationId"`
 	// Station call letters.
-	Station string
-	Title   string
-	Desc    string
-	AirDate string
+	Station string `json:"station"`
+	Title   string `json:"title"`
+	Desc    string `json:"desc"`
+	AirDate string `json:"airDate"`
 	// Status of the processing for the tape.
-	Status string
+	Status string `json:"status"`
 	// StatusMsg provides additional details when Status is [StatusError].
-	StatusMsg string
+	StatusMsg string `json:"statusMsg"`
+	// Duration is the length of the recording in seconds, 0 until it is done.
+	Duration int64 `json:"duration"`
+	// Size is the bytes the tape's files take up, counted in its owner's
+	// usage.
+	Size int64 `json:"size"`
+	// Starred tapes are kept forever, whatever the retention rules say.
+	Starred bool `json:"starred"`
 	// timestamp when the show as first downloaded.
-	Created string
+	Created string `json:"created"`
 	// timestamp when the show was updated.
-	Updated string
+	Updated string `json:"updated"`
+	// Deleted is the UTC timestamp when the tape was moved to the trash,
+	// empty while it is not.
+	Deleted string `json:"-"`
+	// FsPath is the directory holding the tape's audio files,
+	// relative to the server's user directory.
+	FsPath string `json:"-"`
 }
 
 const (
@@ -53,54 +78,118 @@ const (
 )
 
 type TapeSource struct {
-	Id     int64
-	TapeId int64
-	Seq    int64
+	Id     int64 `json:"id"`
+	TapeId int64 `json:"-"`
+	Seq    int64 `json:"seq"`
 	// Type defines the overall content type which is either
 	// [TypeFile] or [TypeStream]
-	Type string
-	Url  string
+	Type string `json:"type"`
+	Url  string `json:"url"`
 	// FileExtension is ending '.xxx' for a [TypeFile] source object,
 	// such as an mp3 file.  A [TypeStream] source object which also
 	// contains mp3 data will not have this field populated.
-	FileExtension string
+	FileExtension string `json:"fileExtension"`
 	// ContentType is the value provided in the HTTP response header.
-	ContentType string
+	ContentType string `json:"contentType"`
 	// optional, content downloaded via url.
-	Content     string
-	DateCreated string
-	DateUpdated string
+	Content     string `json:"content"`
+	DateCreated string `json:"created"`
+	DateUpdated string `json:"updated"`
 }
 
 func (t *Tape) String() string {
-	return fmt.Sprintf("tape %q %.20q", t.Id, t.Title)
+	return fmt.Sprintf("tape %d %.20q", t.Id, t.Title)
 }
 
-const tapeSelectSql = "SELECT T.*, S.* FROM TAPE T INNER JOIN STATION S ON T.STATION_ID = S.ID"
+func (s *TapeSource) String() string {
+	return fmt.Sprintf("tape source %d %d %d %q", s.Id, s.TapeId, s.Seq, s.Url)
+}
+
+// New creates a tape owned by userId that is waiting to be processed.
+// Its files will live in a unique directory below userDirName, which is
+// the owner's directory name under the server's user directory.
+func New(userId int64, userDirName string, stationId int64, title string, airDate string) Tape {
+	return Tape{
+		UserId:    userId,
+		StationId: stationId,
+		Title:     title,
+		AirDate:   airDate,
+		PublicId:  newPublicId(),
+		Status:    StatusTodo,
+		Created:   time.Now().Format(time.RFC3339),
+		FsPath:    filepath.Join(userDirName, uuid.New().String()),
+	}
+}
+
+// Validate checks that the required fields are populated.
+func (t *Tape) Validate() error {
+	if t.Title == "" {
+		return fmt.Errorf("title required")
+	}
+	if t.AirDate == "" {
+		return fmt.Errorf("air date required")
+	}
+	if t.StationId == 0 {
+		return fmt.Errorf("station required")
+	}
+	return nil
+}
+
+// Validate checks that the required fields are populated.
+func (s *TapeSource) Validate() error {
+	if s.Type != TypeFile && s.Type != TypeStream {
+		return fmt.Errorf("type must be %q or %q", TypeFile, TypeStream)
+	}
+	if s.Url == "" {
+		return fmt.Errorf("url required")
+	}
+	return nil
+}
+
+// Column names are listed explicitly as SQLite reports the
+// unqualified name for each column of "T.*".
+const tapeColumns = "T.ID, T.PUBLIC_ID, T.USER_ID, T.STATION_ID, T.TITLE, T.DESC, T.AIR_DATE, T.STATUS, T.STATUS_MSG, " +
+	"T.DURATION, T.SIZE, T.STARRED, T.CREATED_AT, T.UPDATED_AT, T.DELETED_AT, T.FS_PATH, S.CALL_LETTERS"
+
+const tapeSelectSql = "SELECT " + tapeColumns + " FROM TAPE T INNER JOIN STATION S ON T.STATION_ID = S.ID"
 
 func tapeCreator(stmt *sqlite.Stmt) (*Tape, error) {
 	return &Tape{
-		Id:        stmt.GetInt64("T.ID"),
-		Title:     stmt.GetText("T.TITLE"),
-		Desc:      stmt.GetText("T.DESC"),
-		AirDate:   stmt.GetText("T.AIR_DATE"),
-		Status:    stmt.GetText("T.STATUS"),
-		StatusMsg: stmt.GetText("T.STATUS_MSG"),
-		Created:   stmt.GetText("T.CREATED_AT"),
-		Updated:   stmt.GetText("T.UPDATED_AT"),
-		Station:   stmt.GetText("S.CALL_LETTERS"),
+		Id:        stmt.GetInt64("ID"),
+		PublicId:  stmt.GetText("PUBLIC_ID"),
+		UserId:    stmt.GetInt64("USER_ID"),
+		StationId: stmt.GetInt64("STATION_ID"),
+		Title:     stmt.GetText("TITLE"),
+		Desc:      stmt.GetText("DESC"),
+		AirDate:   stmt.GetText("AIR_DATE"),
+		Status:    stmt.GetText("STATUS"),
+		StatusMsg: stmt.GetText("STATUS_MSG"),
+		Duration:  stmt.GetInt64("DURATION"),
+		Size:      stmt.GetInt64("SIZE"),
+		Starred:   stmt.GetBool("STARRED"),
+		Created:   stmt.GetText("CREATED_AT"),
+		Updated:   stmt.GetText("UPDATED_AT"),
+		Deleted:   stmt.GetText("DELETED_AT"),
+		FsPath:    stmt.GetText("FS_PATH"),
+		Station:   stmt.GetText("CALL_LETTERS"),
 	}, nil
 }
 
-func GetTapesForUser(userId int64, db *database.Database) ([]*Tape, error) {
-	log.Println("enter GetTapesForUser", userId)
+func GetTapesForUser(userId int64, db database.Runner) ([]*Tape, error) {
+	return GetTapesForUserPage(userId, database.AllRows, db)
+}
+
+// GetTapesForUserPage returns one page of the user's tapes ordered by id,
+// leaving out the ones in the trash.
+func GetTapesForUserPage(userId int64, page database.Page, db database.Runner) ([]*Tape, error) {
+	log.Println("enter GetTapesForUser", userId, page)
 	defer log.Println("exit GetTapesForUser")
 
 	tapes := make([]*Tape, 0)
 	err := db.RunQuery(database.Query{
 		Name:           "GetTapesForUser",
-		Sql:            tapeSelectSql + " WHERE T.USER_ID=:id;",
-		Named:          map[string]any{":id": userId},
+		Sql:            tapeSelectSql + " WHERE T.USER_ID=:id AND T.DELETED_AT IS NULL ORDER BY T.ID LIMIT :limit OFFSET :offset;",
+		Named:          map[string]any{":id": userId, ":limit": page.Limit, ":offset": page.Offset},
 		PerformsUpdate: false,
 		ResultFunc: func(stmt *sqlite.Stmt) error {
 			tape, err := tapeCreator(stmt)
@@ -115,7 +204,54 @@ func GetTapesForUser(userId int64, db *database.Database) ([]*Tape, error) {
 	return tapes, err
 }
 
-func GetTape(id int64, db *database.Database) (*Tape, error) {
+// GetAllForUser returns the user's tapes ordered by id, including the
+// ones in the trash.
+func GetAllForUser(db database.Runner, userId int64) ([]*Tape, error) {
+	log.Println("enter GetAllTapesForUser", userId)
+	defer log.Println("exit GetAllTapesForUser")
+
+	tapes := make([]*Tape, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetAllTapesForUser",
+		Sql:            tapeSelectSql + " WHERE T.USER_ID=:id ORDER BY T.ID;",
+		Named:          map[string]any{":id": userId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tape, err := tapeCreator(stmt)
+			if err == nil {
+				tapes = append(tapes, tape)
+			}
+			return err
+		},
+	})
+
+	return tapes, err
+}
+
+// GetAll returns the tapes of every user ordered by id, including the
+// ones in the trash.
+func GetAll(db database.Runner) ([]*Tape, error) {
+	log.Println("enter GetAllTapes")
+	defer log.Println("exit GetAllTapes")
+
+	tapes := make([]*Tape, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetAllTapes",
+		Sql:            tapeSelectSql + " ORDER BY T.ID;",
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tape, err := tapeCreator(stmt)
+			if err == nil {
+				tapes = append(tapes, tape)
+			}
+			return err
+		},
+	})
+
+	return tapes, err
+}
+
+func GetTape(id int64, db database.Runner) (*Tape, error) {
 	log.Println("enter GetTape", id)
 	defer log.Println("exit GetTape", id)
 
@@ -138,6 +274,507 @@ func GetTape(id int64, db *database.Database) (*Tape, error) {
 	return tape, err
 }
 
+// GetByFsPath returns the tape whose files live at fsPath, below the
+// server's user directory, or nil when there is none.
+func GetByFsPath(db database.Runner, fsPath string) (*Tape, error) {
+	log.Println("enter GetTapeByFsPath", fsPath)
+	defer log.Println("exit GetTapeByFsPath")
+
+	var tape *Tape
+	err := db.RunQuery(database.Query{
+		Name:           "GetTapeByFsPath",
+		Sql:            tapeSelectSql + " WHERE T.FS_PATH=:path;",
+		PerformsUpdate: false,
+		Named:          map[string]any{":path": fsPath},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t, err := tapeCreator(stmt)
+			if err == nil {
+				tape = t
+			}
+			return err
+		},
+	})
+
+	return tape, err
+}
+
+// Insert adds the tape and sets its Id.
+func Insert(db database.Runner, t *Tape) error {
+	log.Println("enter InsertTape", t)
+	defer log.Println("exit InsertTape")
+
+	return db.RunQuery(database.Query{
+		Name: "InsertTape",
+		Sql: "INSERT INTO TAPE (PUBLIC_ID, USER_ID, TITLE, DESC, STATION_ID, AIR_DATE, STATUS, STATUS_MSG, CREATED_AT, FS_PATH) " +
+			"VALUES(:publicId, :userId, :title, :desc, :stationId, :airDate, :status, :statusMsg, :created, :fsPath) RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":publicId":  t.PublicId,
+			":userId":    t.UserId,
+			":title":     t.Title,
+			":desc":      t.Desc,
+			":stationId": t.StationId,
+			":airDate":   t.AirDate,
+			":status":    t.Status,
+			":statusMsg": t.StatusMsg,
+			":created":   t.Created,
+			":fsPath":    t.FsPath,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t.Id = stmt.GetInt64("ID")
+			return nil
+		},
+	})
+}
+
+// Create inserts the tape and its sources together, nothing is saved if
+// one of them fails.  The ids of t and the sources are set on success.
+func Create(db database.Runner, t *Tape, sources []TapeSource) error {
+	log.Println("enter CreateTape", t, len(sources))
+	defer log.Println("exit CreateTape")
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		err := Insert(tx, t)
+		if err != nil {
+			return err
+		}
+
+		for i := range sources {
+			sources[i].TapeId = t.Id
+			err = InsertSource(tx, &sources[i])
+			if err != nil {
+				return fmt.Errorf("source %d: %w", i, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Update saves the user editable fields of the tape and sets Updated.
+func Update(db database.Runner, t *Tape) error {
+	log.Println("enter UpdateTape", t)
+	defer log.Println("exit UpdateTape")
+
+	t.Updated = time.Now().Format(time.RFC3339)
+
+	return db.RunQuery(database.Query{
+		Name:           "UpdateTape",
+		Sql:            "UPDATE TAPE SET TITLE=:title, DESC=:desc, STATION_ID=:stationId, AIR_DATE=:airDate, UPDATED_AT=:updated WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":id":        t.Id,
+			":title":     t.Title,
+			":desc":      t.Desc,
+			":stationId": t.StationId,
+			":airDate":   t.AirDate,
+			":updated":   t.Updated,
+		},
+	})
+}
+
+// SetDuration records the length of the finished recording in seconds.
+func SetDuration(db database.Runner, id int64, seconds int64) error {
+	log.Println("enter SetTapeDuration", id, seconds)
+	defer log.Println("exit SetTapeDuration")
+
+	if seconds < 0 {
+		return fmt.Errorf("duration %d is negative", seconds)
+	}
+
+	return db.RunQuery(database.Query{
+		Name:           "SetTapeDuration",
+		Sql:            "UPDATE TAPE SET DURATION=:duration WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":duration": seconds},
+	})
+}
+
+// SetSize records the bytes the tape's files take up, as capture writes
+// them, and adds the difference to its owner's usage.
+func SetSize(db database.Runner, id int64, bytes int64) error {
+	log.Println("enter SetTapeSize", id, bytes)
+	defer log.Println("exit SetTapeSize")
+
+	if bytes < 0 {
+		return fmt.Errorf("size %d is negative", bytes)
+	}
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		return tx.RunQueries(database.Query{
+			Name: "AddTapeSizeToUsage",
+			Sql: "UPDATE USER SET BYTES_USED=MAX(0, BYTES_USED + :size - (SELECT SIZE FROM TAPE WHERE ID=:id)) " +
+				"WHERE ID=(SELECT USER_ID FROM TAPE WHERE ID=:id);",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id, ":size": bytes},
+		}, database.Query{
+			Name:           "SetTapeSize",
+			Sql:            "UPDATE TAPE SET SIZE=:size WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id, ":size": bytes},
+		})
+	})
+}
+
+// SetStarred stars the tape to keep it forever, or takes the star away.
+func SetStarred(db database.Runner, id int64, starred bool) error {
+	log.Println("enter SetTapeStarred", id, starred)
+	defer log.Println("exit SetTapeStarred")
+
+	return db.RunQuery(database.Query{
+		Name:           "SetTapeStarred",
+		Sql:            "UPDATE TAPE SET STARRED=:starred WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":starred": starred},
+	})
+}
+
+// Delete removes the tape with its sources and tracks, takes it out of
+// every collection and its size out of its owner's usage.
+func Delete(db database.Runner, id int64) error {
+	log.Println("enter DeleteTape", id)
+	defer log.Println("exit DeleteTape")
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		return tx.RunQueries(database.Query{
+			Name: "SubtractTapeUsage",
+			Sql: "UPDATE USER SET BYTES_USED=MAX(0, BYTES_USED - (SELECT SIZE FROM TAPE WHERE ID=:id)) " +
+				"WHERE ID=(SELECT USER_ID FROM TAPE WHERE ID=:id);",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteTapeGrants",
+			Sql:            "DELETE FROM SHARE_GRANT WHERE TAPE_ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteTapeMemberships",
+			Sql:            "DELETE FROM COLLECTION_TAPE WHERE TAPE_ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteTapeTracks",
+			Sql:            "DELETE FROM TAPE_TRACK WHERE TAPE_ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteTapeSources",
+			Sql:            "DELETE FROM TAPE_SOURCE WHERE TAPE_ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		}, database.Query{
+			Name:           "DeleteTape",
+			Sql:            "DELETE FROM TAPE WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id},
+		})
+	})
+}
+
+const sourceSelectSql = "SELECT ID, TAPE_ID, SEQ, TYPE, URL, FILE_EXTENSION, CONTENT_TYPE, CONTENT, CREATED_AT, UPDATED_AT FROM TAPE_SOURCE"
+
+func sourceCreator(stmt *sqlite.Stmt) (*TapeSource, error) {
+	return &TapeSource{
+		Id:            stmt.GetInt64("ID"),
+		TapeId:        stmt.GetInt64("TAPE_ID"),
+		Seq:           stmt.GetInt64("SEQ"),
+		Type:          stmt.GetText("TYPE"),
+		Url:           stmt.GetText("URL"),
+		FileExtension: stmt.GetText("FILE_EXTENSION"),
+		ContentType:   stmt.GetText("CONTENT_TYPE"),
+		Content:       stmt.GetText("CONTENT"),
+		DateCreated:   stmt.GetText("CREATED_AT"),
+		DateUpdated:   stmt.GetText("UPDATED_AT"),
+	}, nil
+}
+
+// GetSources returns the sources of the tape ordered by sequence.
+func GetSources(db database.Runner, tapeId int64) ([]*TapeSource, error) {
+	log.Println("enter GetSources", tapeId)
+	defer log.Println("exit GetSources")
+
+	sources := make([]*TapeSource, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetSources",
+		Sql:            sourceSelectSql + " WHERE TAPE_ID=:tapeId ORDER BY SEQ, ID;",
+		Named:          map[string]any{":tapeId": tapeId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s, err := sourceCreator(stmt)
+			if err == nil {
+				sources = append(sources, s)
+			}
+			return err
+		},
+	})
+
+	return sources, err
+}
+
+// GetSource returns the source of the tape with the given id or nil when not found.
+func GetSource(db database.Runner, tapeId int64, id int64) (*TapeSource, error) {
+	log.Println("enter GetSource", tapeId, id)
+	defer log.Println("exit GetSource")
+
+	var source *TapeSource
+	err := db.RunQuery(database.Query{
+		Name:           "GetSource",
+		Sql:            sourceSelectSql + " WHERE TAPE_ID=:tapeId AND ID=:id;",
+		Named:          map[string]any{":tapeId": tapeId, ":id": id},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s, err := sourceCreator(stmt)
+			if err == nil {
+				source = s
+			}
+			return err
+		},
+	})
+
+	return source, err
+}
+
+// InsertSource adds the source and sets its Id and Created timestamp.
+// A zero Seq places the source after the existing sources of the tape.
+func InsertSource(db database.Runner, s *TapeSource) error {
+	log.Println("enter InsertSource", s)
+	defer log.Println("exit InsertSource")
+
+	s.DateCreated = time.Now().Format(time.RFC3339)
+
+	return db.RunQuery(database.Query{
+		Name: "InsertSource",
+		Sql: "INSERT INTO TAPE_SOURCE (TAPE_ID, SEQ, TYPE, URL, FILE_EXTENSION, CONTENT_TYPE, CONTENT, CREATED_AT) " +
+			"VALUES(:tapeId, IIF(:seq > 0, :seq, (SELECT IFNULL(MAX(SEQ), 0) + 1 FROM TAPE_SOURCE WHERE TAPE_ID=:tapeId)), " +
+			":type, :url, :ext, :contentType, :content, :created) RETURNING ID, SEQ;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":tapeId":      s.TapeId,
+			":seq":         s.Seq,
+			":type":        s.Type,
+			":url":         s.Url,
+			":ext":         s.FileExtension,
+			":contentType": s.ContentType,
+			":content":     s.Content,
+			":created":     s.DateCreated,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s.Id = stmt.GetInt64("ID")
+			s.Seq = stmt.GetInt64("SEQ")
+			return nil
+		},
+	})
+}
+
+// UpdateSource saves all fields of the source and sets Updated.
+func UpdateSource(db database.Runner, s *TapeSource) error {
+	log.Println("enter UpdateSource", s)
+	defer log.Println("exit UpdateSource")
+
+	s.DateUpdated = time.Now().Format(time.RFC3339)
+
+	return db.RunQuery(database.Query{
+		Name: "UpdateSource",
+		Sql: "UPDATE TAPE_SOURCE SET SEQ=:seq, TYPE=:type, URL=:url, FILE_EXTENSION=:ext, CONTENT_TYPE=:contentType, " +
+			"CONTENT=:content, UPDATED_AT=:updated WHERE ID=:id AND TAPE_ID=:tapeId;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":id":          s.Id,
+			":tapeId":      s.TapeId,
+			":seq":         s.Seq,
+			":type":        s.Type,
+			":url":         s.Url,
+			":ext":         s.FileExtension,
+			":contentType": s.ContentType,
+			":content":     s.Content,
+			":updated":     s.DateUpdated,
+		},
+	})
+}
+
+// DeleteSource removes the source from the tape.
+func DeleteSource(db database.Runner, tapeId int64, id int64) error {
+	log.Println("enter DeleteSource", tapeId, id)
+	defer log.Println("exit DeleteSource")
+
+	return db.RunQuery(database.Query{
+		Name:           "DeleteSource",
+		Sql:            "DELETE FROM TAPE_SOURCE WHERE ID=:id AND TAPE_ID=:tapeId;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":tapeId": tapeId, ":id": id},
+	})
+}
+
+// ResetInProgress puts every tape being processed back in the queue with
+// msg as the reason.  It returns the number of tapes reset.
+func ResetInProgress(db database.Runner, msg string) (int, error) {
+	log.Println("enter ResetInProgress")
+	defer log.Println("exit ResetInProgress")
+
+	count := 0
+	err := db.RunQuery(database.Query{
+		Name:           "ResetInProgress",
+		Sql:            "UPDATE TAPE SET STATUS=:todo, STATUS_MSG=:msg, UPDATED_AT=:now WHERE STATUS=:inProgress RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":todo":       StatusTodo,
+			":inProgress": StatusInProgress,
+			":msg":        msg,
+			":now":        time.Now().Format(time.RFC3339),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count++
+			return nil
+		},
+	})
+
+	return count, err
+}
+
+// GetQueue returns the tapes of every user that are not done or in the
+// trash, oldest first.
+func GetQueue(db database.Runner, page database.Page) ([]*Tape, error) {
+	log.Println("enter GetQueue", page)
+	defer log.Println("exit GetQueue")
+
+	tapes := make([]*Tape, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetQueue",
+		Sql:            tapeSelectSql + " WHERE T.STATUS<>:done AND T.DELETED_AT IS NULL ORDER BY T.ID LIMIT :limit OFFSET :offset;",
+		Named:          map[string]any{":done": StatusDone, ":limit": page.Limit, ":offset": page.Offset},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tape, err := tapeCreator(stmt)
+			if err == nil {
+				tapes = append(tapes, tape)
+			}
+			return err
+		},
+	})
+
+	return tapes, err
+}
+
+// GetInCollection returns the tapes of the collection in their order,
+// leaving out the ones in the trash and any no longer owned by the
+// collection's owner.
+func GetInCollection(db database.Runner, collectionId int64) ([]*Tape, error) {
+	log.Println("enter GetTapesInCollection", collectionId)
+	defer log.Println("exit GetTapesInCollection")
+
+	tapes := make([]*Tape, 0)
+	err := db.RunQuery(database.Query{
+		Name: "GetTapesInCollection",
+		Sql: tapeSelectSql + " INNER JOIN COLLECTION_TAPE C ON C.TAPE_ID = T.ID" +
+			" WHERE C.COLLECTION_ID=:collectionId AND T.DELETED_AT IS NULL" +
+			" AND T.USER_ID=(SELECT USER_ID FROM COLLECTION WHERE ID=:collectionId) ORDER BY C.SEQ;",
+		Named:          map[string]any{":collectionId": collectionId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tape, err := tapeCreator(stmt)
+			if err == nil {
+				tapes = append(tapes, tape)
+			}
+			return err
+		},
+	})
+
+	return tapes, err
+}
+
+// Retry puts a tape that failed back in the queue.  It returns false
+// when the tape is not in error.
+func Retry(db database.Runner, id int64) (bool, error) {
+	log.Println("enter RetryTape", id)
+	defer log.Println("exit RetryTape")
+
+	retried := false
+	err := db.RunQuery(database.Query{
+		Name:           "RetryTape",
+		Sql:            "UPDATE TAPE SET STATUS=:todo, STATUS_MSG='', UPDATED_AT=:now WHERE ID=:id AND STATUS=:error RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":id":    id,
+			":todo":  StatusTodo,
+			":error": StatusError,
+			":now":   time.Now().Format(time.RFC3339),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			retried = true
+			return nil
+		},
+	})
+
+	return retried, err
+}
+
+// SetStatus moves the tape from one status to another with msg as the
+// reason.  It returns false when the tape is not in the from status.
+func SetStatus(db database.Runner, id int64, from string, to string, msg string) (bool, error) {
+	log.Println("enter SetTapeStatus", id, from, to)
+	defer log.Println("exit SetTapeStatus")
+
+	changed := false
+	err := db.RunQuery(database.Query{
+		Name:           "SetTapeStatus",
+		Sql:            "UPDATE TAPE SET STATUS=:to, STATUS_MSG=:msg, UPDATED_AT=:now WHERE ID=:id AND STATUS=:from RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":id":   id,
+			":from": from,
+			":to":   to,
+			":msg":  msg,
+			":now":  time.Now().Format(time.RFC3339),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			changed = true
+			return nil
+		},
+	})
+
+	return changed, err
+}
+
+// PauseForUser takes the user's waiting tapes out of the queue with msg
+// as the reason.  It returns the number of tapes paused.
+func PauseForUser(db database.Runner, userId int64, msg string) (int, error) {
+	log.Println("enter PauseForUser", userId)
+	defer log.Println("exit PauseForUser")
+
+	return setStatusForUser(db, "PauseForUser", userId, StatusTodo, StatusPaused, msg)
+}
+
+// ResumeForUser puts the user's paused tapes back in the queue.
+// It returns the number of tapes resumed.
+func ResumeForUser(db database.Runner, userId int64) (int, error) {
+	log.Println("enter ResumeForUser", userId)
+	defer log.Println("exit ResumeForUser")
+
+	return setStatusForUser(db, "ResumeForUser", userId, StatusPaused, StatusTodo, "")
+}
+
+func setStatusForUser(db database.Runner, name string, userId int64, from string, to string, msg string) (int, error) {
+	count := 0
+	err := db.RunQuery(database.Query{
+		Name:           name,
+		Sql:            "UPDATE TAPE SET STATUS=:to, STATUS_MSG=:msg, UPDATED_AT=:now WHERE USER_ID=:userId AND STATUS=:from RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":userId": userId,
+			":from":   from,
+			":to":     to,
+			":msg":    msg,
+			":now":    time.Now().Format(time.RFC3339),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count++
+			return nil
+		},
+	})
+
+	return count, err
+}
+
 func RecordTape() {
 	log.Println("enter RecordTape")
 	defer log.Println("exit RecordTape")
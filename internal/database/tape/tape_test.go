@@ -0,0 +1,512 @@
+package tape_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+	"time"
+)
+
+const testEmail = "tapedeck.us@gmail.com"
+
+const dbPath = "./unit-test.db"
+
+func setup(t *testing.T) (*database.Database, *user.User, *station.Station) {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// insert dummy data
+	err = user.Insert(db, user.New(testEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, u, &s
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+func TestTapeCrud(t *testing.T) {
+	db, u, s := setup(t)
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tape.GetTape(tp.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil {
+		t.Fatalf("tape %d not found", tp.Id)
+	}
+
+	if got.Title != tp.Title || got.UserId != u.Id || got.Station != s.CallLetters || got.FsPath != tp.FsPath {
+		t.Fatalf("tape mismatch: expected %+v, actual %+v", tp, got)
+	}
+
+	got.Title = "Breakfast of Champions"
+	err = tape.Update(db, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tapes, err := tape.GetTapesForUser(u.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tapes) != 1 || tapes[0].Title != got.Title || tapes[0].Updated == "" {
+		t.Fatalf("tape not updated: %v", tapes)
+	}
+
+	err = tape.Delete(db, tp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = tape.GetTape(tp.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != nil {
+		t.Fatalf("tape not deleted: %v", got)
+	}
+}
+
+func TestTapeSources(t *testing.T) {
+	db, u, s := setup(t)
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		src := tape.TapeSource{TapeId: tp.Id, Type: tape.TypeFile, Url: "https://wmbr.org/a.mp3"}
+		err = tape.InsertSource(db, &src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if src.Seq != int64(i+1) {
+			t.Fatalf("expected seq %d, actual %d", i+1, src.Seq)
+		}
+	}
+
+	sources, err := tape.GetSources(db, tp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sources) != 2 {
+		t.Fatalf("expected 2 sources, actual %d", len(sources))
+	}
+
+	err = tape.DeleteSource(db, tp.Id, sources[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := tape.GetSource(db, tp.Id, sources[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if src != nil {
+		t.Fatalf("source not deleted: %v", src)
+	}
+}
+
+func TestTapeCreate(t *testing.T) {
+	db, u, s := setup(t)
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Breakfast of Champions", "2026-10-18")
+	sources := []tape.TapeSource{
+		{Type: tape.TypeFile, Url: "https://wmbr.org/a.mp3"},
+		{Type: tape.TypeFile, Url: "https://wmbr.org/b.mp3"},
+	}
+
+	err := tape.Create(db, &tp, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tp.Id == 0 || sources[1].Id == 0 || sources[1].TapeId != tp.Id {
+		t.Fatalf("ids not set: %v %v", tp, sources)
+	}
+
+	found, err := tape.GetSources(db, tp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("expected 2 sources, actual %d", len(found))
+	}
+
+	// the station does not exist so nothing is saved
+	bad := tape.New(u.Id, u.Uuid, s.Id+100, "Missing Station", "2026-10-18")
+	err = tape.Create(db, &bad, []tape.TapeSource{{Type: tape.TypeFile, Url: "https://wmbr.org/c.mp3"}})
+	if err == nil {
+		t.Fatalf("expected error for missing station")
+	}
+
+	tapes, err := tape.GetTapesForUser(u.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tapes) != 1 {
+		t.Fatalf("expected 1 tape, actual %d", len(tapes))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db, u, s := setup(t)
+
+	whrb := station.Station{CallLetters: "WHRB", Freq: "95.3", HomepageUrl: "https://whrb.org"}
+	err := station.Insert(db, &whrb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jazz := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	jazz.Desc = "Morning jazz"
+	folk := tape.New(u.Id, u.Uuid, whrb.Id, "Hillbilly at Harvard", "2026-10-18")
+	folk.Status = tape.StatusDone
+	for _, tp := range []*tape.Tape{&jazz, &folk} {
+		err = tape.Insert(db, tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tape.SetTracks(db, jazz.Id, []tape.Track{
+		{Artist: "Sonny Rollins", Title: "St. Thomas"},
+		{Artist: "John Coltrane", Title: "Naima"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(filter tape.Filter) []*tape.Result {
+		t.Helper()
+		results, _, err := tape.Search(db, u.Id, filter, "", -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	// a prefix of a track artist
+	results := search(tape.Filter{Query: "coltr"})
+	if len(results) != 1 || results[0].Id != jazz.Id {
+		t.Fatalf("expected the jazz tape, actual %v", results)
+	}
+	if !strings.Contains(results[0].Snippet, tape.MarkStart+"Coltrane"+tape.MarkEnd) {
+		t.Fatalf("expected marked snippet, actual %q", results[0].Snippet)
+	}
+
+	// FTS5 syntax is taken literally
+	if len(search(tape.Filter{Query: `"naima" ( *`})) != 1 {
+		t.Fatalf("expected FTS5 syntax to be ignored")
+	}
+
+	// filters without a query, newest first
+	if results := search(tape.Filter{}); len(results) != 2 || results[0].Id != folk.Id {
+		t.Fatalf("expected both tapes newest first, actual %v", results)
+	}
+	if results := search(tape.Filter{StationId: whrb.Id}); len(results) != 1 || results[0].Id != folk.Id {
+		t.Fatalf("expected the WHRB tape, actual %v", results)
+	}
+	if results := search(tape.Filter{From: "2026-10-18", To: "2026-10-31"}); len(results) != 1 || results[0].Id != folk.Id {
+		t.Fatalf("expected the tape aired on the 18th, actual %v", results)
+	}
+	if results := search(tape.Filter{Query: "jazz", Status: tape.StatusDone}); len(results) != 0 {
+		t.Fatalf("expected no done jazz tapes, actual %v", results)
+	}
+
+	for _, filter := range []tape.Filter{{From: "last week"}, {Sort: "size"}, {Sort: tape.SortRelevance}, {Order: "up"}} {
+		_, _, err = tape.Search(db, u.Id, filter, "", -1)
+		if err == nil {
+			t.Fatalf("expected %+v to be rejected", filter)
+		}
+	}
+
+	// the index follows renamed stations and removed tracks
+	whrb.CallLetters = "WHRB-FM"
+	err = station.Update(db, &whrb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := search(tape.Filter{Query: "whrb-fm"}); len(results) != 1 {
+		t.Fatalf("expected renamed station to be found, actual %v", results)
+	}
+
+	err = tape.SetTracks(db, jazz.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := search(tape.Filter{Query: "coltrane"}); len(results) != 0 {
+		t.Fatalf("expected removed track not to be found, actual %v", results)
+	}
+
+	err = tape.Delete(db, folk.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := search(tape.Filter{Query: "hillbilly"}); len(results) != 0 {
+		t.Fatalf("expected deleted tape not to be found, actual %v", results)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	db, u, s := setup(t)
+
+	// two tapes share each air date and duration so the pages have to
+	// fall back on the id
+	titles := []string{"Jazz A", "jazz b", "Jazz C", "Jazz D", "Jazz E"}
+	for i, title := range titles {
+		tp := tape.New(u.Id, u.Uuid, s.Id, title, fmt.Sprintf("2026-10-%02d", 10+i/2))
+		err := tape.Insert(db, &tp)
+		if err == nil {
+			err = tape.SetDuration(db, tp.Id, int64(60*(i/2)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk := func(filter tape.Filter) []string {
+		t.Helper()
+		actual := []string{}
+		after := ""
+		for range titles {
+			results, next, err := tape.Search(db, u.Id, filter, after, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range results {
+				actual = append(actual, r.Title)
+			}
+			if next == "" {
+				return actual
+			}
+			after = next
+		}
+		t.Fatalf("%+v: pages did not end", filter)
+		return nil
+	}
+
+	tests := []struct {
+		filter   tape.Filter
+		expected string
+	}{
+		{tape.Filter{}, "Jazz E,Jazz D,Jazz C,jazz b,Jazz A"},
+		{tape.Filter{Sort: tape.SortAirDate, Order: tape.OrderAsc}, "Jazz A,jazz b,Jazz C,Jazz D,Jazz E"},
+		{tape.Filter{Sort: tape.SortTitle}, "Jazz A,jazz b,Jazz C,Jazz D,Jazz E"},
+		{tape.Filter{Sort: tape.SortDuration}, "Jazz E,Jazz D,Jazz C,jazz b,Jazz A"},
+		{tape.Filter{Query: "jazz"}, "Jazz A,jazz b,Jazz C,Jazz D,Jazz E"},
+		{tape.Filter{Query: "jazz", Sort: tape.SortCreated, Order: tape.OrderDesc}, "Jazz E,Jazz D,Jazz C,jazz b,Jazz A"},
+	}
+
+	for _, test := range tests {
+		actual := strings.Join(walk(test.filter), ",")
+		if actual != test.expected {
+			t.Errorf("%+v: expected %s, actual %s", test.filter, test.expected, actual)
+		}
+	}
+
+	// a cursor only works with the sort it was made for
+	_, next, err := tape.Search(db, u.Id, tape.Filter{}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, after := range []string{"not a cursor", next} {
+		_, _, err = tape.Search(db, u.Id, tape.Filter{Sort: tape.SortTitle}, after, 2)
+		if err == nil {
+			t.Errorf("expected cursor %q to be rejected", after)
+		}
+	}
+}
+
+func TestTrash(t *testing.T) {
+	db, u, s := setup(t)
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trashed, err := tape.Trash(db, tp.Id)
+	if err != nil || !trashed {
+		t.Fatalf("expected tape to be trashed, actual %v %v", trashed, err)
+	}
+	trashed, err = tape.Trash(db, tp.Id)
+	if err != nil || trashed {
+		t.Fatalf("expected second trash to do nothing, actual %v %v", trashed, err)
+	}
+
+	// out of the list, search and queue but still readable by id
+	tapes, err := tape.GetTapesForUser(u.Id, db)
+	if err != nil || len(tapes) != 0 {
+		t.Fatalf("expected no tapes, actual %v %v", tapes, err)
+	}
+	results, _, err := tape.Search(db, u.Id, tape.Filter{Query: "risers"}, "", -1)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("expected no results, actual %v %v", results, err)
+	}
+	queue, err := tape.GetQueue(db, database.AllRows)
+	if err != nil || len(queue) != 0 {
+		t.Fatalf("expected empty queue, actual %v %v", queue, err)
+	}
+
+	read, err := tape.GetTape(tp.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Deleted == "" || time.Until(read.Expires()) < tape.TrashTtl-time.Minute {
+		t.Fatalf("expected deleted time, actual %q", read.Deleted)
+	}
+
+	trash, err := tape.GetTrash(db, u.Id)
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("expected one tape in the trash, actual %v %v", trash, err)
+	}
+
+	expired, err := tape.GetExpired(db, time.Now())
+	if err != nil || len(expired) != 0 {
+		t.Fatalf("expected nothing expired yet, actual %v %v", expired, err)
+	}
+	expired, err = tape.GetExpired(db, time.Now().Add(tape.TrashTtl+time.Minute))
+	if err != nil || len(expired) != 1 {
+		t.Fatalf("expected the tape to expire, actual %v %v", expired, err)
+	}
+
+	restored, err := tape.Restore(db, tp.Id)
+	if err != nil || !restored {
+		t.Fatalf("expected tape to be restored, actual %v %v", restored, err)
+	}
+	tapes, err = tape.GetTapesForUser(u.Id, db)
+	if err != nil || len(tapes) != 1 {
+		t.Fatalf("expected restored tape in the list, actual %v %v", tapes, err)
+	}
+}
+
+func TestGetForUser(t *testing.T) {
+	db, u, s := setup(t)
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err := tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tp.PublicId) != 24 || tp.PublicId == fmt.Sprint(tp.Id) {
+		t.Fatalf("unexpected public id %q", tp.PublicId)
+	}
+
+	read, err := tape.GetForUser(db, tp.PublicId, u.Id, tape.AccessOwner)
+	if err != nil || read.Id != tp.Id {
+		t.Fatalf("expected owner to get the tape, actual %v %v", read, err)
+	}
+
+	tests := []struct {
+		publicId string
+		userId   int64
+	}{
+		{fmt.Sprint(tp.Id), u.Id},
+		{"", u.Id},
+		{tp.PublicId, u.Id + 1},
+	}
+	for _, test := range tests {
+		_, err = tape.GetForUser(db, test.publicId, test.userId, tape.AccessRead)
+		if !errors.Is(err, tape.ErrNotFound) {
+			t.Errorf("%+v: expected %v, actual %v", test, tape.ErrNotFound, err)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tapes := []*tape.Tape{}
+	add := func(title string, airDate string, status string) *tape.Tape {
+		tp := tape.New(1, "uuid", 1, title, airDate)
+		tp.Id = int64(len(tapes) + 1)
+		tp.Status = status
+		tapes = append(tapes, &tp)
+		return &tp
+	}
+	oldest := add("Late Risers Club", "2026-10-03", tape.StatusDone)
+	older := add("Late Risers Club", "2026-10-10", tape.StatusDone)
+	add("Late Risers Club", "2026-10-17", tape.StatusDone)
+	recording := add("Hillbilly at Harvard", "2026-01-01", tape.StatusInProgress)
+	starred := add("Hillbilly at Harvard", "2026-01-02", tape.StatusDone)
+	starred.Starred = true
+
+	ids := func(expired []tape.Expired) string {
+		s := []string{}
+		for _, e := range expired {
+			s = append(s, fmt.Sprint(e.Id))
+		}
+		return strings.Join(s, ",")
+	}
+
+	if expired := (tape.Retention{}).Expired(tapes, now, tape.Show); len(expired) != 0 {
+		t.Fatalf("expected the zero rule to keep everything, actual %s", ids(expired))
+	}
+
+	// the last per show
+	expired := tape.Retention{KeepLast: 1}.Expired(tapes, now, tape.Show)
+	if ids(expired) != fmt.Sprintf("%d,%d", oldest.Id, older.Id) {
+		t.Fatalf("keep last: unexpected %s", ids(expired))
+	}
+
+	// days by air date, never a starred tape or one being recorded
+	expired = tape.Retention{KeepDays: 14}.Expired(tapes, now, tape.Show)
+	if ids(expired) != fmt.Sprint(oldest.Id) || !strings.Contains(expired[0].Reason, "14 days") {
+		t.Fatalf("keep days: unexpected %s", ids(expired))
+	}
+	if recording.Prunable() || starred.Prunable() {
+		t.Fatal("expected recording and starred tapes to be kept")
+	}
+
+	if err := (tape.Retention{KeepLast: -1}).Validate(); err == nil {
+		t.Fatal("expected a negative rule to be invalid")
+	}
+}
@@ -0,0 +1,116 @@
+package tape
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"tapedeck/internal/database"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// Track is one entry of a tape's playlist.
+type Track struct {
+	Id     int64  `json:"id"`
+	TapeId int64  `json:"-"`
+	Seq    int64  `json:"seq"`
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+}
+
+func (t *Track) String() string {
+	return fmt.Sprintf("track %d %d %q - %q", t.TapeId, t.Seq, t.Artist, t.Title)
+}
+
+// Validate checks that the required fields are populated.
+func (t *Track) Validate() error {
+	if t.Title == "" {
+		return fmt.Errorf("track title required")
+	}
+	return nil
+}
+
+func trackCreator(stmt *sqlite.Stmt) (*Track, error) {
+	return &Track{
+		Id:     stmt.GetInt64("ID"),
+		TapeId: stmt.GetInt64("TAPE_ID"),
+		Seq:    stmt.GetInt64("SEQ"),
+		Artist: stmt.GetText("ARTIST"),
+		Title:  stmt.GetText("TITLE"),
+	}, nil
+}
+
+// GetTracks returns the tape's playlist in order.
+func GetTracks(db database.Runner, tapeId int64) ([]*Track, error) {
+	log.Println("enter GetTracks", tapeId)
+	defer log.Println("exit GetTracks")
+
+	tracks := make([]*Track, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetTracks",
+		Sql:            "SELECT * FROM TAPE_TRACK WHERE TAPE_ID=:tapeId ORDER BY SEQ;",
+		Named:          map[string]any{":tapeId": tapeId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t, err := trackCreator(stmt)
+			if err == nil {
+				tracks = append(tracks, t)
+			}
+			return err
+		},
+	})
+
+	return tracks, err
+}
+
+// SetTracks replaces the tape's playlist with tracks, numbered in the
+// order given.  Nothing is saved when one track is invalid.
+func SetTracks(db database.Runner, tapeId int64, tracks []Track) error {
+	log.Println("enter SetTracks", tapeId, len(tracks))
+	defer log.Println("exit SetTracks")
+
+	for i := range tracks {
+		if err := tracks[i].Validate(); err != nil {
+			return fmt.Errorf("track %d: %w", i+1, err)
+		}
+	}
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		err := tx.RunQuery(database.Query{
+			Name:           "DeleteTracks",
+			Sql:            "DELETE FROM TAPE_TRACK WHERE TAPE_ID=:tapeId;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":tapeId": tapeId},
+		})
+		if err != nil {
+			return err
+		}
+
+		for i := range tracks {
+			t := &tracks[i]
+			t.TapeId = tapeId
+			t.Seq = int64(i + 1)
+
+			err = tx.RunQuery(database.Query{
+				Name:           "InsertTrack",
+				Sql:            "INSERT INTO TAPE_TRACK (TAPE_ID, SEQ, ARTIST, TITLE) VALUES(:tapeId, :seq, :artist, :title) RETURNING ID;",
+				PerformsUpdate: true,
+				Named: map[string]any{
+					":tapeId": t.TapeId,
+					":seq":    t.Seq,
+					":artist": t.Artist,
+					":title":  t.Title,
+				},
+				ResultFunc: func(stmt *sqlite.Stmt) error {
+					t.Id = stmt.GetInt64("ID")
+					return nil
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
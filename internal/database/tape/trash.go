@@ -0,0 +1,97 @@
+package tape
+
+import (
+	"log"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// TrashTtl is how long a tape stays in the trash, where it can be
+// restored, before it is deleted for good.
+const TrashTtl = 30 * 24 * time.Hour
+
+// Trash moves the tape to the trash.  It returns false when the tape was
+// already there.
+func Trash(db database.Runner, id int64) (bool, error) {
+	log.Println("enter TrashTape", id)
+	defer log.Println("exit TrashTape")
+
+	return setDeleted(db, "TrashTape", "UPDATE TAPE SET DELETED_AT=:now WHERE ID=:id AND DELETED_AT IS NULL RETURNING ID;",
+		map[string]any{":id": id, ":now": time.Now().UTC().Format(time.RFC3339)})
+}
+
+// Restore takes the tape out of the trash.  It returns false when the
+// tape was not in the trash.
+func Restore(db database.Runner, id int64) (bool, error) {
+	log.Println("enter RestoreTape", id)
+	defer log.Println("exit RestoreTape")
+
+	return setDeleted(db, "RestoreTape", "UPDATE TAPE SET DELETED_AT=NULL WHERE ID=:id AND DELETED_AT IS NOT NULL RETURNING ID;",
+		map[string]any{":id": id})
+}
+
+func setDeleted(db database.Runner, name string, sql string, named map[string]any) (bool, error) {
+	changed := false
+	err := db.RunQuery(database.Query{
+		Name:           name,
+		Sql:            sql,
+		PerformsUpdate: true,
+		Named:          named,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			changed = true
+			return nil
+		},
+	})
+
+	return changed, err
+}
+
+// GetTrash returns the user's tapes in the trash, the most recently
+// deleted first.
+func GetTrash(db database.Runner, userId int64) ([]*Tape, error) {
+	log.Println("enter GetTrash", userId)
+	defer log.Println("exit GetTrash")
+
+	return getTrashed(db, "GetTrash", " WHERE T.USER_ID=:userId AND T.DELETED_AT IS NOT NULL ORDER BY T.DELETED_AT DESC, T.ID DESC;",
+		map[string]any{":userId": userId})
+}
+
+// GetExpired returns the tapes of every user that have been in the trash
+// for longer than [TrashTtl] at now.
+func GetExpired(db database.Runner, now time.Time) ([]*Tape, error) {
+	log.Println("enter GetExpiredTrash", now)
+	defer log.Println("exit GetExpiredTrash")
+
+	return getTrashed(db, "GetExpiredTrash", " WHERE T.DELETED_AT<=:before ORDER BY T.DELETED_AT, T.ID;",
+		map[string]any{":before": now.Add(-TrashTtl).UTC().Format(time.RFC3339)})
+}
+
+func getTrashed(db database.Runner, name string, where string, named map[string]any) ([]*Tape, error) {
+	tapes := make([]*Tape, 0)
+	err := db.RunQuery(database.Query{
+		Name:           name,
+		Sql:            tapeSelectSql + where,
+		Named:          named,
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tape, err := tapeCreator(stmt)
+			if err == nil {
+				tapes = append(tapes, tape)
+			}
+			return err
+		},
+	})
+
+	return tapes, err
+}
+
+// Expires returns when the tape in the trash will be deleted for good.
+func (t *Tape) Expires() time.Time {
+	deleted, err := time.Parse(time.RFC3339, t.Deleted)
+	if err != nil {
+		return time.Time{}
+	}
+	return deleted.Add(TrashTtl)
+}
@@ -0,0 +1,219 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"tapedeck/internal/database"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+const (
+	// ScopeRead allows read only requests.
+	ScopeRead = "read"
+	// ScopeRecord allows reading, recording and editing tapes.
+	ScopeRecord = "record"
+)
+
+// Prefix starts every token secret so they are easy to recognize
+// in scripts and secret scanners.
+const Prefix = "tdk_"
+
+// Token is a personal API token used by non-browser clients.
+// Only a hash of the secret is stored; the secret itself is
+// shown to the user once when the token is created.
+type Token struct {
+	Id     int64  `json:"id"`
+	UserId int64  `json:"-"`
+	Name   string `json:"name"`
+	Hash   string `json:"-"`
+	Scope  string `json:"scope"`
+	// timestamp when the token was created.
+	Created string `json:"created"`
+	// timestamp of the last request made with the token.
+	LastUsed string `json:"lastUsed"`
+	// timestamp when the token was revoked.
+	Revoked string `json:"revoked"`
+}
+
+func (t *Token) String() string {
+	return fmt.Sprintf("token %d %q %s", t.Id, t.Name, t.Scope)
+}
+
+// New creates a token for the user and returns it with its secret.
+func New(userId int64, name string, scope string) (Token, string, error) {
+	if name == "" {
+		return Token{}, "", fmt.Errorf("token name required")
+	}
+
+	if scope == "" {
+		scope = ScopeRecord
+	}
+
+	if scope != ScopeRead && scope != ScopeRecord {
+		return Token{}, "", fmt.Errorf("scope must be %q or %q", ScopeRead, ScopeRecord)
+	}
+
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	secret := Prefix + base64.RawURLEncoding.EncodeToString(random)
+
+	token := Token{
+		UserId:  userId,
+		Name:    name,
+		Hash:    Hash(secret),
+		Scope:   scope,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return token, secret, nil
+}
+
+// Hash returns the value stored in the database for a token secret.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows reports whether the token's scope permits the request method.
+func (t *Token) Allows(method string) bool {
+	if t.Scope == ScopeRecord {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// FromHeader returns the secret from an "Authorization: Bearer" header value.
+func FromHeader(authorization string) (string, bool) {
+	scheme, secret, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(secret), true
+}
+
+const tokenSelectSql = "SELECT ID, USER_ID, NAME, HASH, SCOPE, CREATED_AT, LAST_USED_AT, REVOKED_AT FROM API_TOKEN"
+
+func tokenCreator(stmt *sqlite.Stmt) (*Token, error) {
+	return &Token{
+		Id:       stmt.GetInt64("ID"),
+		UserId:   stmt.GetInt64("USER_ID"),
+		Name:     stmt.GetText("NAME"),
+		Hash:     stmt.GetText("HASH"),
+		Scope:    stmt.GetText("SCOPE"),
+		Created:  stmt.GetText("CREATED_AT"),
+		LastUsed: stmt.GetText("LAST_USED_AT"),
+		Revoked:  stmt.GetText("REVOKED_AT"),
+	}, nil
+}
+
+// Insert adds the token and sets its Id.
+func Insert(db database.Runner, t *Token) error {
+	log.Println("enter InsertToken", t)
+	defer log.Println("exit InsertToken")
+
+	return db.RunQuery(database.Query{
+		Name:           "InsertToken",
+		Sql:            "INSERT INTO API_TOKEN (USER_ID, NAME, HASH, SCOPE, CREATED_AT) VALUES(:userId, :name, :hash, :scope, :created) RETURNING ID;",
+		PerformsUpdate: true,
+		Named: map[string]any{
+			":userId":  t.UserId,
+			":name":    t.Name,
+			":hash":    t.Hash,
+			":scope":   t.Scope,
+			":created": t.Created,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t.Id = stmt.GetInt64("ID")
+			return nil
+		},
+	})
+}
+
+// Lookup returns the unrevoked token matching the secret or nil when not found.
+func Lookup(db database.Runner, secret string) (*Token, error) {
+	log.Println("enter LookupToken")
+	defer log.Println("exit LookupToken")
+
+	var token *Token
+	err := db.RunQuery(database.Query{
+		Name:           "LookupToken",
+		Sql:            tokenSelectSql + " WHERE HASH=:hash AND REVOKED_AT IS NULL;",
+		Named:          map[string]any{":hash": Hash(secret)},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t, err := tokenCreator(stmt)
+			if err == nil {
+				token = t
+			}
+			return err
+		},
+	})
+
+	return token, err
+}
+
+// GetForUser returns all tokens of the user, newest first.
+func GetForUser(db database.Runner, userId int64) ([]*Token, error) {
+	log.Println("enter GetTokensForUser", userId)
+	defer log.Println("exit GetTokensForUser")
+
+	tokens := make([]*Token, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetTokensForUser",
+		Sql:            tokenSelectSql + " WHERE USER_ID=:userId ORDER BY ID DESC;",
+		Named:          map[string]any{":userId": userId},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			t, err := tokenCreator(stmt)
+			if err == nil {
+				tokens = append(tokens, t)
+			}
+			return err
+		},
+	})
+
+	return tokens, err
+}
+
+// Touch records that the token was just used.
+func Touch(db database.Runner, id int64) error {
+	return db.RunQuery(database.Query{
+		Name:           "TouchToken",
+		Sql:            "UPDATE API_TOKEN SET LAST_USED_AT=:now WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":now": time.Now().UTC().Format(time.RFC3339)},
+	})
+}
+
+// Revoke disables the user's token.  It reports false when
+// the user has no active token with that id.
+func Revoke(db database.Runner, userId int64, id int64) (bool, error) {
+	log.Println("enter RevokeToken", userId, id)
+	defer log.Println("exit RevokeToken")
+
+	revoked := false
+	err := db.RunQuery(database.Query{
+		Name:           "RevokeToken",
+		Sql:            "UPDATE API_TOKEN SET REVOKED_AT=:now WHERE ID=:id AND USER_ID=:userId AND REVOKED_AT IS NULL RETURNING ID;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":userId": userId, ":now": time.Now().UTC().Format(time.RFC3339)},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			revoked = true
+			return nil
+		},
+	})
+
+	return revoked, err
+}
@@ -0,0 +1,116 @@
+package token_test
+
+import (
+	"net/http"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/token"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+const dbPath = "./unit-test.db"
+
+func setup(t *testing.T) *database.Database {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); teardown() })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// owner of the rows under test, ID 1
+	err = user.Insert(db, user.New("tapedeck.us@gmail.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func teardown() {
+	file.Delete(dbPath)
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	db := setup(t)
+
+	tk, secret, err := token.New(1, "laptop", token.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(secret, token.Prefix) || tk.Hash == secret {
+		t.Fatalf("unexpected secret %q hash %q", secret, tk.Hash)
+	}
+
+	err = token.Insert(db, &tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := token.Lookup(db, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found == nil || found.Id != tk.Id {
+		t.Fatalf("token not found by secret: %v", found)
+	}
+
+	if found.Allows(http.MethodPost) || !found.Allows(http.MethodGet) {
+		t.Fatalf("read scope should only allow safe methods")
+	}
+
+	err = token.Touch(db, tk.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another user cannot revoke it
+	revoked, err := token.Revoke(db, 2, tk.Id)
+	if err != nil || revoked {
+		t.Fatalf("token revoked by wrong user: %v %v", revoked, err)
+	}
+
+	revoked, err = token.Revoke(db, 1, tk.Id)
+	if err != nil || !revoked {
+		t.Fatalf("token not revoked: %v %v", revoked, err)
+	}
+
+	found, err = token.Lookup(db, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found != nil {
+		t.Fatalf("revoked token still found: %v", found)
+	}
+
+	tokens, err := token.GetForUser(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 || tokens[0].Revoked == "" || tokens[0].LastUsed == "" {
+		t.Fatalf("unexpected tokens %v", tokens)
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	secret, ok := token.FromHeader("Bearer tdk_abc")
+	if !ok || secret != "tdk_abc" {
+		t.Fatalf("bearer header not parsed: %q %v", secret, ok)
+	}
+
+	_, ok = token.FromHeader("Basic dXNlcjpwYXNz")
+	if ok {
+		t.Fatalf("basic header should not parse")
+	}
+}
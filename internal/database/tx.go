@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// Runner runs queries and units of work.  Both [*Database] and [*Tx] are
+// runners so the functions of the table packages work inside or outside
+// of a transaction.
+type Runner interface {
+	RunQuery(query Query) error
+	WithTx(ctx context.Context, fn func(tx *Tx) error) error
+}
+
+// Tx is a unit of work on a single connection.  Every query run on it is
+// committed together or not at all.  See [Database.WithTx].
+type Tx struct {
+	conn  *sqlite.Conn
+	depth int
+}
+
+// WithTx runs fn in an immediate transaction on a pooled connection.
+// The transaction commits when fn returns nil and rolls back when fn
+// returns an error or panics, in which case the panic continues.
+//
+// Do not use the Database from inside fn, use tx instead.  A second
+// connection would wait on the write lock held by this one.
+func (db *Database) WithTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	log.Println("enter WithTx")
+	defer log.Println("exit WithTx", err)
+
+	conn, put, err := db.take(ctx)
+	if err != nil {
+		return
+	}
+	defer put()
+
+	// immediate so a read followed by a write cannot fail with SQLITE_BUSY
+	// when another connection wrote in between
+	end, err := sqlitex.ImmediateTransaction(conn)
+	if err != nil {
+		return
+	}
+	defer end(&err)
+
+	return fn(&Tx{conn: conn})
+}
+
+// RunQueries runs every query in one transaction.
+func (db *Database) RunQueries(ctx context.Context, queries ...Query) error {
+	return db.WithTx(ctx, func(tx *Tx) error {
+		return tx.RunQueries(queries...)
+	})
+}
+
+// WithTx runs fn in a savepoint nested inside tx.  Only the work of fn is
+// rolled back when it fails, the caller decides what happens to the rest.
+// ctx is checked before starting, the context given to [Database.WithTx]
+// still interrupts the queries.
+func (tx *Tx) WithTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	log.Println("enter nested WithTx", tx.depth+1)
+	defer log.Println("exit nested WithTx", tx.depth+1, err)
+
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	defer sqlitex.Save(tx.conn)(&err)
+
+	return fn(&Tx{conn: tx.conn, depth: tx.depth + 1})
+}
+
+// RunQuery runs the query as part of the transaction.
+func (tx *Tx) RunQuery(query Query) error {
+	log.Println("enter RunQuery in tx", query.Name)
+	defer log.Println("exit RunQuery in tx", query.Name)
+
+	return runQuery(tx.conn, query)
+}
+
+// RunQueries runs the queries in order and stops at the first error.
+func (tx *Tx) RunQueries(queries ...Query) error {
+	for _, query := range queries {
+		err := tx.RunQuery(query)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errTest = errors.New("test error")
+
+func TestWithTxCommit(t *testing.T) {
+	db := setupDb(t)
+
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		err := tx.RunQuery(stationQuery("WMBR"))
+		if err != nil {
+			return err
+		}
+		return tx.RunQuery(stationQuery("WHRB"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStations(t, db, 2)
+}
+
+func TestWithTxRollbackOnError(t *testing.T) {
+	db := setupDb(t)
+
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		err := tx.RunQuery(stationQuery("WMBR"))
+		if err != nil {
+			return err
+		}
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Fatalf("expected errTest, actual %v", err)
+	}
+
+	expectStations(t, db, 0)
+}
+
+func TestWithTxRollbackOnPanic(t *testing.T) {
+	db := setupDb(t)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic to continue")
+			}
+		}()
+
+		db.WithTx(context.Background(), func(tx *Tx) error {
+			err := tx.RunQuery(stationQuery("WMBR"))
+			if err != nil {
+				return err
+			}
+			panic("test panic")
+		})
+	}()
+
+	expectStations(t, db, 0)
+
+	// the connection went back to the pool in a usable state
+	for i := 0; i < poolSize; i++ {
+		err := db.RunQuery(stationQuery("WUMB"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectStations(t, db, poolSize)
+}
+
+func TestWithTxNested(t *testing.T) {
+	db := setupDb(t)
+
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		err := tx.RunQuery(stationQuery("WMBR"))
+		if err != nil {
+			return err
+		}
+
+		// only the inner savepoint is rolled back
+		err = tx.WithTx(context.Background(), func(inner *Tx) error {
+			err := inner.RunQuery(stationQuery("WHRB"))
+			if err != nil {
+				return err
+			}
+			return errTest
+		})
+		if !errors.Is(err, errTest) {
+			t.Fatalf("expected errTest, actual %v", err)
+		}
+
+		return tx.WithTx(context.Background(), func(inner *Tx) error {
+			return inner.RunQuery(stationQuery("WUMB"))
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStations(t, db, 2)
+}
+
+func TestRunQueries(t *testing.T) {
+	db := setupDb(t)
+
+	bad := Query{
+		Name:           "InsertBadStation",
+		Sql:            "INSERT INTO STATION (CALL_LETTERS) VALUES('WOMR');",
+		PerformsUpdate: true,
+	}
+
+	err := db.RunQueries(context.Background(), stationQuery("WMBR"), bad)
+	if err == nil {
+		t.Fatalf("expected error for missing columns")
+	}
+	expectStations(t, db, 0)
+
+	err = db.RunQueries(context.Background(), stationQuery("WMBR"), stationQuery("WCUW"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStations(t, db, 2)
+}
+
+func stationQuery(callLetters string) Query {
+	return Query{
+		Name:           "InsertTestStation",
+		Sql:            "INSERT INTO STATION (CALL_LETTERS, FREQ, HOMEPAGE_URL) VALUES(:call, '88.1', 'https://example.com');",
+		PerformsUpdate: true,
+		Named:          map[string]any{":call": callLetters},
+	}
+}
+
+func expectStations(t *testing.T, db *Database, expected int) {
+	t.Helper()
+
+	count, err := countStations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != expected {
+		t.Fatalf("expected %d stations, actual %d", expected, count)
+	}
+}
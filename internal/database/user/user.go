@@ -1,13 +1,18 @@
 package user
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/database/tape"
 
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 	"zombiezen.com/go/sqlite"
 )
 
@@ -16,6 +21,19 @@ const (
 	StatusDisabled = "disabled"
 )
 
+const (
+	// RoleUser manages their own tapes
+	RoleUser = "user"
+	// RoleAdmin also manages users, stations and the job queue
+	RoleAdmin = "admin"
+)
+
+// QuotaDefault is the QuotaBytes of users who get the server's quota.
+const QuotaDefault = -1
+
+// MinPasswordLength is the shortest password accepted by [SetPassword].
+const MinPasswordLength = 10
+
 type User struct {
 	Id         int64
 	Uuid       string
@@ -23,7 +41,21 @@ type User struct {
 	ExternalId string
 	Provider   string
 	Status     string
+	Role       string
 	Created    string
+	// PasswordHash is the bcrypt hash used by the built-in
+	// authentication mode.  Empty when no password is set.
+	PasswordHash string
+	// Retention is the rule for all of the user's tapes, where KeepLast
+	// counts the episodes of each show.
+	Retention tape.Retention
+	// BytesUsed is the size of the user's tapes, including the ones in
+	// the trash.
+	BytesUsed int64
+	// QuotaBytes is the most BytesUsed may reach before no more tapes can
+	// be captured.  0 is unlimited and [QuotaDefault] is the server's
+	// quota.
+	QuotaBytes int64
 }
 
 func (u *User) String() string {
@@ -36,6 +68,7 @@ func New(email string) User {
 		Email:    email,
 		Provider: "google",
 		Status:   StatusEnabled,
+		Role:     RoleUser,
 		Created:  time.Now().Format(time.RFC3339),
 	}
 
@@ -44,29 +77,38 @@ func New(email string) User {
 
 func userCreator(stmt *sqlite.Stmt) (*User, error) {
 	return &User{
-		Id:         stmt.GetInt64("ID"),
-		Uuid:       stmt.GetText("UUID"),
-		Email:      stmt.GetText("EMAIL"),
-		Provider:   stmt.GetText("PROVIDER"),
-		ExternalId: stmt.GetText("EXTERNAL_ID"),
-		Status:     stmt.GetText("STATUS"),
-		Created:    stmt.GetText("CREATED_AT"),
+		Id:           stmt.GetInt64("ID"),
+		Uuid:         stmt.GetText("UUID"),
+		Email:        stmt.GetText("EMAIL"),
+		Provider:     stmt.GetText("PROVIDER"),
+		ExternalId:   stmt.GetText("EXTERNAL_ID"),
+		Status:       stmt.GetText("STATUS"),
+		Role:         stmt.GetText("ROLE"),
+		Created:      stmt.GetText("CREATED_AT"),
+		PasswordHash: stmt.GetText("PASSWORD_HASH"),
+		Retention: tape.Retention{
+			KeepLast: int(stmt.GetInt64("KEEP_LAST")),
+			KeepDays: int(stmt.GetInt64("KEEP_DAYS")),
+		},
+		BytesUsed:  stmt.GetInt64("BYTES_USED"),
+		QuotaBytes: stmt.GetInt64("QUOTA_BYTES"),
 	}, nil
 }
 
-func Insert(db *database.Database, user User) error {
+func Insert(db database.Runner, user User) error {
 	log.Println("enter InsertUser")
 	defer log.Println("exit InsertUser")
 
 	err := db.RunQuery(database.Query{
 		Name:           "InsertUser",
-		Sql:            "INSERT INTO USER (UUID, EMAIL, PROVIDER, STATUS, CREATED_AT) VALUES(:uuid, :email, :provider, :status, :created);",
+		Sql:            "INSERT INTO USER (UUID, EMAIL, PROVIDER, STATUS, ROLE, CREATED_AT) VALUES(:uuid, :email, :provider, :status, :role, :created);",
 		PerformsUpdate: true,
 		Named: map[string]any{
 			":uuid":     user.Uuid,
 			":email":    user.Email,
 			":provider": user.Provider,
 			":status":   user.Status,
+			":role":     user.Role,
 			":created":  user.Created,
 		},
 	})
@@ -74,7 +116,7 @@ func Insert(db *database.Database, user User) error {
 	return err
 }
 
-func GetByEmail(db *database.Database, email string) (*User, error) {
+func GetByEmail(db database.Runner, email string) (*User, error) {
 	log.Println("enter GetUserByEmail")
 	defer log.Println("exit GetUserByEmail")
 
@@ -96,3 +138,394 @@ func GetByEmail(db *database.Database, email string) (*User, error) {
 
 	return user, err
 }
+
+func GetById(db database.Runner, id int64) (*User, error) {
+	log.Println("enter GetUserById")
+	defer log.Println("exit GetUserById")
+
+	var user *User
+	err := db.RunQuery(database.Query{
+		Name:           "GetUserById",
+		Sql:            "SELECT * FROM USER WHERE ID=:id;",
+		Named:          map[string]any{":id": id},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			u, err := userCreator(stmt)
+			if err == nil {
+				log.Println("user returned", u)
+				user = u
+			}
+			return err
+		},
+	})
+
+	return user, err
+}
+
+// GetByUuid returns the user whose directory is uuid, or nil when there
+// is none.
+func GetByUuid(db database.Runner, uuid string) (*User, error) {
+	log.Println("enter GetUserByUuid")
+	defer log.Println("exit GetUserByUuid")
+
+	var user *User
+	err := db.RunQuery(database.Query{
+		Name:           "GetUserByUuid",
+		Sql:            "SELECT * FROM USER WHERE UUID=:uuid;",
+		Named:          map[string]any{":uuid": uuid},
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			u, err := userCreator(stmt)
+			if err == nil {
+				user = u
+			}
+			return err
+		},
+	})
+
+	return user, err
+}
+
+// SetPassword stores a bcrypt hash of the password for the user.
+func SetPassword(db database.Runner, id int64, password string) error {
+	log.Println("enter SetPassword", id)
+	defer log.Println("exit SetPassword")
+
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return db.RunQuery(database.Query{
+		Name:           "SetPassword",
+		Sql:            "UPDATE USER SET PASSWORD_HASH=:hash WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":hash": string(hash)},
+	})
+}
+
+// CheckPassword reports whether the password matches the user's stored hash.
+// A nil user or one without a password is compared against a dummy hash,
+// so a failed sign in takes as long whether or not the email has an
+// account.
+func (u *User) CheckPassword(password string) bool {
+	known := u != nil && u.PasswordHash != ""
+	hash := dummyHash()
+	if known {
+		hash = []byte(u.PasswordHash)
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil && known
+}
+
+// dummyHash is what [User.CheckPassword] compares against when there is no
+// hash to check.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte(uuid.New().String()), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// Delete removes the user with their tapes, sources, tracks, collections,
+// grants, tokens and sessions in one transaction.  Grants the user was given
+// go too.  Files in the user's directory are left alone.
+func Delete(db database.Runner, id int64) error {
+	log.Println("enter DeleteUser", id)
+	defer log.Println("exit DeleteUser")
+
+	named := map[string]any{":id": id}
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		return tx.RunQueries(database.Query{
+			Name: "DeleteUserGrants",
+			Sql: "DELETE FROM SHARE_GRANT WHERE USER_ID=:id OR TAPE_ID IN (SELECT ID FROM TAPE WHERE USER_ID=:id) " +
+				"OR COLLECTION_ID IN (SELECT ID FROM COLLECTION WHERE USER_ID=:id);",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name: "DeleteUserMemberships",
+			Sql: "DELETE FROM COLLECTION_TAPE WHERE COLLECTION_ID IN (SELECT ID FROM COLLECTION WHERE USER_ID=:id) " +
+				"OR TAPE_ID IN (SELECT ID FROM TAPE WHERE USER_ID=:id);",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUserCollections",
+			Sql:            "DELETE FROM COLLECTION WHERE USER_ID=:id;",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUserTracks",
+			Sql:            "DELETE FROM TAPE_TRACK WHERE TAPE_ID IN (SELECT ID FROM TAPE WHERE USER_ID=:id);",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUserSources",
+			Sql:            "DELETE FROM TAPE_SOURCE WHERE TAPE_ID IN (SELECT ID FROM TAPE WHERE USER_ID=:id);",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUserTapes",
+			Sql:            "DELETE FROM TAPE WHERE USER_ID=:id;",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUserTokens",
+			Sql:            "DELETE FROM API_TOKEN WHERE USER_ID=:id;",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUserSessions",
+			Sql:            "DELETE FROM SESSION WHERE USER_ID=:id;",
+			PerformsUpdate: true,
+			Named:          named,
+		}, database.Query{
+			Name:           "DeleteUser",
+			Sql:            "DELETE FROM USER WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          named,
+		})
+	})
+}
+
+// GetAll returns every user ordered by email.
+func GetAll(db database.Runner) ([]*User, error) {
+	log.Println("enter GetAllUsers")
+	defer log.Println("exit GetAllUsers")
+
+	users := make([]*User, 0)
+	err := db.RunQuery(database.Query{
+		Name:           "GetAllUsers",
+		Sql:            "SELECT * FROM USER ORDER BY EMAIL;",
+		PerformsUpdate: false,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			u, err := userCreator(stmt)
+			if err == nil {
+				users = append(users, u)
+			}
+			return err
+		},
+	})
+
+	return users, err
+}
+
+// Enabled reports whether the user may sign in and use their tapes.
+func (u *User) Enabled() bool {
+	return u.Status == StatusEnabled
+}
+
+// SetStatus enables or disables the user on behalf of actor.  Disabling
+// pauses the user's waiting tapes and enabling puts them back in the
+// queue.  Each change is written to the audit table in the same
+// transaction.
+func SetStatus(db database.Runner, id int64, status string, actor string) error {
+	log.Println("enter SetStatus", id, status, actor)
+	defer log.Println("exit SetStatus")
+
+	if status != StatusEnabled && status != StatusDisabled {
+		return fmt.Errorf("unknown user status %q", status)
+	}
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		u, err := GetById(tx, id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		if u.Status == status {
+			return nil
+		}
+
+		err = tx.RunQuery(database.Query{
+			Name:           "SetStatus",
+			Sql:            "UPDATE USER SET STATUS=:status WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id, ":status": status},
+		})
+		if err != nil {
+			return err
+		}
+
+		var count int
+		if status == StatusDisabled {
+			count, err = tape.PauseForUser(tx, id, "owner disabled")
+		} else {
+			count, err = tape.ResumeForUser(tx, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		r := audit.New(id, actor, audit.ActionStatus, fmt.Sprintf("%s to %s, %d tapes", u.Status, status, count))
+		return audit.Insert(tx, &r)
+	})
+}
+
+// SetRetention changes the rule for all of the user's tapes.
+func SetRetention(db database.Runner, id int64, r tape.Retention) error {
+	log.Println("enter SetUserRetention", id, r)
+	defer log.Println("exit SetUserRetention")
+
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	return db.RunQuery(database.Query{
+		Name:           "SetUserRetention",
+		Sql:            "UPDATE USER SET KEEP_LAST=:keepLast, KEEP_DAYS=:keepDays WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":keepLast": r.KeepLast, ":keepDays": r.KeepDays},
+	})
+}
+
+// Quota returns the user's quota in bytes, their own or else
+// defaultQuota.  0 is unlimited.
+func (u *User) Quota(defaultQuota int64) int64 {
+	if u.QuotaBytes == QuotaDefault {
+		return defaultQuota
+	}
+	return u.QuotaBytes
+}
+
+// SetQuota gives the user a quota of their own, or [QuotaDefault].
+func SetQuota(db database.Runner, id int64, bytes int64) error {
+	log.Println("enter SetUserQuota", id, bytes)
+	defer log.Println("exit SetUserQuota")
+
+	if bytes < QuotaDefault {
+		return fmt.Errorf("quota %d is negative", bytes)
+	}
+
+	return db.RunQuery(database.Query{
+		Name:           "SetUserQuota",
+		Sql:            "UPDATE USER SET QUOTA_BYTES=:quota WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": id, ":quota": bytes},
+	})
+}
+
+// RecomputeUsage sets the usage of every user to the total size of their
+// tapes, fixing any drift.  It returns the number of users changed.
+func RecomputeUsage(db database.Runner) (int, error) {
+	log.Println("enter RecomputeUsage")
+	defer log.Println("exit RecomputeUsage")
+
+	count := 0
+	err := db.RunQuery(database.Query{
+		Name: "RecomputeUsage",
+		Sql: "UPDATE USER SET BYTES_USED=U.TOTAL FROM (SELECT USER.ID, IFNULL(SUM(T.SIZE), 0) AS TOTAL " +
+			"FROM USER LEFT JOIN TAPE T ON T.USER_ID = USER.ID GROUP BY USER.ID) AS U " +
+			"WHERE USER.ID = U.ID AND USER.BYTES_USED <> U.TOTAL RETURNING USER.ID;",
+		PerformsUpdate: true,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count++
+			return nil
+		},
+	})
+
+	return count, err
+}
+
+// IsAdmin reports whether the user may use the admin pages.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// SetRole changes the user's role on behalf of actor and writes the
+// change to the audit table in the same transaction.
+func SetRole(db database.Runner, id int64, role string, actor string) error {
+	log.Println("enter SetRole", id, role, actor)
+	defer log.Println("exit SetRole")
+
+	if role != RoleUser && role != RoleAdmin {
+		return fmt.Errorf("unknown user role %q", role)
+	}
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		u, err := GetById(tx, id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		if u.Role == role {
+			return nil
+		}
+
+		err = tx.RunQuery(database.Query{
+			Name:           "SetRole",
+			Sql:            "UPDATE USER SET ROLE=:role WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id, ":role": role},
+		})
+		if err != nil {
+			return err
+		}
+
+		r := audit.New(id, actor, audit.ActionRole, fmt.Sprintf("%s to %s", u.Role, role))
+		return audit.Insert(tx, &r)
+	})
+}
+
+// SetEmail changes the user's email address, which must not belong to
+// another user.
+func SetEmail(db database.Runner, id int64, email string) error {
+	log.Println("enter SetEmail", id, email)
+	defer log.Println("exit SetEmail")
+
+	if email == "" {
+		return fmt.Errorf("email required")
+	}
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		other, err := GetByEmail(tx, email)
+		if err != nil {
+			return err
+		}
+		if other != nil && other.Id != id {
+			return fmt.Errorf("email %s already belongs to %v", email, other)
+		}
+
+		return tx.RunQuery(database.Query{
+			Name:           "SetEmail",
+			Sql:            "UPDATE USER SET EMAIL=:email WHERE ID=:id;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":id": id, ":email": email},
+		})
+	})
+}
+
+// ReassignTapes gives all tapes of from to the user to, with their usage.  The tapes'
+// directories move from from's directory to to's, the caller moves the
+// files.
+func ReassignTapes(db database.Runner, from *User, to *User) error {
+	log.Println("enter ReassignTapes", from, to)
+	defer log.Println("exit ReassignTapes")
+
+	return db.WithTx(context.TODO(), func(tx *database.Tx) error {
+		return tx.RunQueries(database.Query{
+			Name:           "ReassignUsage",
+			Sql:            "UPDATE USER SET BYTES_USED=BYTES_USED + (SELECT IFNULL(SUM(SIZE), 0) FROM TAPE WHERE USER_ID=:fromId) WHERE ID=:toId;",
+			PerformsUpdate: true,
+			Named:          map[string]any{":fromId": from.Id, ":toId": to.Id},
+		}, database.Query{
+			Name:           "ReassignTapes",
+			Sql:            "UPDATE TAPE SET USER_ID=:toId, FS_PATH=:toDir || SUBSTR(FS_PATH, LENGTH(:fromDir) + 1) WHERE USER_ID=:fromId;",
+			PerformsUpdate: true,
+			Named: map[string]any{
+				":fromId":  from.Id,
+				":fromDir": from.Uuid,
+				":toId":    to.Id,
+				":toDir":   to.Uuid,
+			},
+		})
+	})
+}
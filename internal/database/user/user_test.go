@@ -2,10 +2,17 @@ package user_test
 
 import (
 	"log"
+	"path/filepath"
 	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/database/session"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/token"
 	"tapedeck/internal/database/user"
 	"tapedeck/internal/file"
 	"testing"
+	"time"
 )
 
 const testEmail = "tapedeck.us@gmail.com"
@@ -15,15 +22,20 @@ const dbPath = "./unit-test.db"
 func setup(t *testing.T) *database.Database {
 	file.Touch(dbPath)
 	db := database.New(dbPath)
-	t.Cleanup(func() { teardown() })
+	t.Cleanup(func() { db.Close(); teardown() })
 
-	db.Open()
-	db.Upgrade()
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// insert dummy data
 	u := user.New(testEmail)
 
-	err := user.Insert(db, u)
+	err = user.Insert(db, u)
 
 	if err != nil {
 		t.Fatal(err)
@@ -55,3 +67,347 @@ func TestGetUserByEmail(t *testing.T) {
 		t.Fatalf("user record has mismatched email: expected '%s', actual '%s'", testEmail, u.Email)
 	}
 }
+
+func TestDeleteUser(t *testing.T) {
+	db := setup(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err = tape.Create(db, &tp, []tape.TapeSource{{Type: tape.TypeFile, Url: "https://wmbr.org/a.mp3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk, _, err := token.New(u.Id, "laptop", token.ScopeRead)
+	if err == nil {
+		err = token.Insert(db, &tk)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss, _, err := session.New(u.Id, time.Hour)
+	if err == nil {
+		err = session.Insert(db, &ss)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Delete(db, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := user.GetById(db, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != nil {
+		t.Fatalf("user was not deleted: %v", deleted)
+	}
+
+	tapes, err := tape.GetTapesForUser(u.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tapes) != 0 {
+		t.Fatalf("expected tapes to be deleted, actual %d", len(tapes))
+	}
+}
+
+func TestSetStatusAndEmail(t *testing.T) {
+	db := setup(t)
+
+	other := user.New("other@example.com")
+	err := user.Insert(db, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.SetStatus(db, u.Id, user.StatusDisabled, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.SetStatus(db, u.Id, "gone", "unit test") == nil {
+		t.Fatalf("expected unknown status to be rejected")
+	}
+
+	if user.SetEmail(db, u.Id, other.Email) == nil {
+		t.Fatalf("expected email of another user to be rejected")
+	}
+
+	err = user.SetEmail(db, u.Id, "renamed@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := user.GetAll(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 2 || users[1].Email != "renamed@example.com" || users[1].Status != user.StatusDisabled {
+		t.Fatalf("unexpected users %v", users)
+	}
+}
+
+func TestReassignTapes(t *testing.T) {
+	db := setup(t)
+
+	err := user.Insert(db, user.New("heir@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to, err := user.GetByEmail(db, "heir@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := tape.New(from.Id, from.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err = tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tape.SetSize(db, tp.Id, 100)
+	if err == nil {
+		err = user.ReassignTapes(db, from, to)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// usage moves with the tapes
+	to, err = user.GetById(db, to.Id)
+	if err != nil || to.BytesUsed != 100 {
+		t.Fatalf("expected 100 bytes used, actual %v %v", to, err)
+	}
+
+	moved, err := tape.GetTape(tp.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join(to.Uuid, filepath.Base(tp.FsPath))
+	if moved.UserId != to.Id || moved.FsPath != expected {
+		t.Fatalf("expected tape of user %d in %s, actual %v in %s", to.Id, expected, moved.UserId, moved.FsPath)
+	}
+}
+
+func TestSetStatusPausesTapes(t *testing.T) {
+	db := setup(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err = tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectTape := func(status string, records int) {
+		t.Helper()
+
+		actual, err := tape.GetTape(tp.Id, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.Status != status {
+			t.Fatalf("expected tape %s, actual %s", status, actual.Status)
+		}
+
+		audits, err := audit.GetForUser(db, u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(audits) != records {
+			t.Fatalf("expected %d audit records, actual %v", records, audits)
+		}
+	}
+
+	err = user.SetStatus(db, u.Id, user.StatusDisabled, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTape(tape.StatusPaused, 1)
+
+	// no change, nothing recorded
+	err = user.SetStatus(db, u.Id, user.StatusDisabled, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTape(tape.StatusPaused, 1)
+
+	err = user.SetStatus(db, u.Id, user.StatusEnabled, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTape(tape.StatusTodo, 2)
+}
+
+func TestSetRole(t *testing.T) {
+	db := setup(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Role != user.RoleUser || u.IsAdmin() {
+		t.Fatalf("expected new users to have role %s, actual %s", user.RoleUser, u.Role)
+	}
+
+	if user.SetRole(db, u.Id, "owner", "unit test") == nil {
+		t.Fatalf("expected unknown role to be rejected")
+	}
+
+	err = user.SetRole(db, u.Id, user.RoleAdmin, "unit test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = user.GetById(db, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.IsAdmin() {
+		t.Fatalf("expected admin, actual %s", u.Role)
+	}
+
+	records, err := audit.GetForUser(db, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Action != audit.ActionRole {
+		t.Fatalf("unexpected audit records %v", records)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	db := setup(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := func() int64 {
+		t.Helper()
+		read, err := user.GetById(db, u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return read.BytesUsed
+	}
+
+	tapes := []tape.Tape{}
+	for _, title := range []string{"Late Risers Club", "Hillbilly at Harvard"} {
+		tp := tape.New(u.Id, u.Uuid, s.Id, title, "2026-10-17")
+		err = tape.Insert(db, &tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tapes = append(tapes, tp)
+	}
+
+	// a new size replaces the old one
+	for _, size := range []int64{100, 60} {
+		err = tape.SetSize(db, tapes[0].Id, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tape.SetSize(db, tapes[1].Id, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := usage(); used != 100 {
+		t.Fatalf("expected 100 bytes used, actual %d", used)
+	}
+
+	err = tape.Delete(db, tapes[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := usage(); used != 40 {
+		t.Fatalf("expected 40 bytes used after the delete, actual %d", used)
+	}
+
+	err = db.RunQuery(database.Query{
+		Name:           "DriftUsage",
+		Sql:            "UPDATE USER SET BYTES_USED=7 WHERE ID=:id;",
+		PerformsUpdate: true,
+		Named:          map[string]any{":id": u.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := user.RecomputeUsage(db)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one user recomputed, actual %d %v", count, err)
+	}
+	if used := usage(); used != 40 {
+		t.Fatalf("expected 40 bytes used after recomputing, actual %d", used)
+	}
+
+	if quota := u.Quota(1 << 30); quota != 1<<30 {
+		t.Fatalf("expected the default quota, actual %d", quota)
+	}
+	err = user.SetQuota(db, u.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = user.GetById(db, u.Id)
+	if err != nil || u.Quota(1<<30) != 0 {
+		t.Fatalf("expected an unlimited quota, actual %v %v", u, err)
+	}
+	if err := user.SetQuota(db, u.Id, -2); err == nil {
+		t.Fatal("expected a negative quota to be invalid")
+	}
+}
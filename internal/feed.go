@@ -0,0 +1,107 @@
+package app
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"tapedeck/internal/database/tape"
+	"time"
+)
+
+// rssFeed is an RSS 2.0 document that podcast apps can subscribe to,
+// with each audio file of a tape as an episode.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	Guid        rssGuid      `xml:"guid"`
+	PubDate     string       `xml:"pubDate,omitempty"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+}
+
+type rssGuid struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// newFeed starts a feed with no episodes.
+func newFeed(title string, link string, description string) rssFeed {
+	return rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{Title: title, Link: link, Description: description},
+	}
+}
+
+// addTape adds an episode for each of the tape's audio files, found below
+// the absolute URL prefix.  Recordings in several files are numbered.
+func (f *rssFeed) addTape(t *tape.Tape, prefix string, files []audioFile) {
+	pubDate := ""
+	if aired, err := time.Parse(time.DateOnly, t.AirDate); err == nil {
+		pubDate = aired.Format(time.RFC1123Z)
+	}
+
+	urls := audioUrls(prefix, files)
+	for i, file := range files {
+		title := partTitle(t, i, len(files))
+
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       title,
+			Description: t.Desc,
+			// stable while the file is, the URL may change with a new share link
+			Guid:      rssGuid{Value: t.PublicId + "/" + file.Name},
+			PubDate:   pubDate,
+			Enclosure: rssEnclosure{Url: urls[i], Length: file.Size, Type: file.Type},
+		})
+	}
+}
+
+// writeFeed writes the feed as XML.
+func writeFeed(w http.ResponseWriter, f rssFeed) {
+	bytes, err := xml.MarshalIndent(f, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Println("write feed to response", len(f.Channel.Items))
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(bytes)
+}
+
+// baseUrl returns the scheme and host the client used to reach the server,
+// for the absolute links a feed needs.  The scheme set by a trusted proxy
+// wins over the one of the connection to it.
+func baseUrl(r *http.Request, trust *proxyTrust) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if trust != nil && trust.trusted(r) {
+		switch proto := r.Header.Get("X-Forwarded-Proto"); proto {
+		case "http", "https":
+			scheme = proto
+		}
+	}
+
+	return scheme + "://" + r.Host
+}
@@ -2,7 +2,9 @@ package file
 
 import (
 	"bufio"
+	"io/fs"
 	"os"
+	"path/filepath"
 )
 
 func Touch(path string) (*os.File, error) {
@@ -37,3 +39,21 @@ func ReadLines(path string, handleLine func(string) error) error {
 
 	return nil
 }
+
+// DirSize adds up the size of the files below dir.  Files that cannot be
+// read are skipped, a missing dir is empty.
+func DirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/fsck"
+	"time"
+)
+
+// fsckPage is the data for admin-fsck.html.
+type fsckPage struct {
+	Csrf    string
+	Report  *fsck.Report
+	Message string
+	Error   string
+}
+
+// makeFsckAdminHandler checks the tapes against the user directory and
+// shows what does not match.  Posting the repair action fixes what can be
+// fixed and shows what is left.
+func makeFsckAdminHandler(db *database.Database, userDir string, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeFsckAdminHandler", r.URL.String())
+			defer log.Println("exit MakeFsckAdminHandler")
+
+			admin := getUserFromRequest(w, r)
+			if admin == nil {
+				return
+			}
+
+			page := fsckPage{Csrf: csrfToken(r)}
+
+			if r.Method == http.MethodPost {
+				if r.PostFormValue("action") != "repair" {
+					http.Error(w, "unknown action", http.StatusBadRequest)
+					return
+				}
+
+				report, err := fsck.Check(db, fsck.Options{UserDir: userDir, Repair: true, Now: time.Now()})
+				if report != nil && report.Repaired() > 0 {
+					auditErr := recordAdmin(db, admin, admin.Id, audit.ActionFsck, fmt.Sprintf("repaired %d of %d problems", report.Repaired(), len(report.Problems)))
+					if err == nil {
+						err = auditErr
+					}
+					page.Message = fmt.Sprintf("Repaired %d problems", report.Repaired())
+				}
+				if err != nil {
+					page.Error = err.Error()
+				}
+			}
+
+			report, err := fsck.Check(db, fsck.Options{UserDir: userDir, Now: time.Now()})
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Report = report
+
+			bytes, evalErr := tmplEngine.eval("admin-fsck.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package fsck
+
+// SetSnapshotTaken replaces the hook run between reading the database and
+// walking the user directory until the test ends.
+func SetSnapshotTaken(cleanup func(func()), f func()) {
+	snapshotTaken = f
+	cleanup(func() { snapshotTaken = func() {} })
+}
@@ -0,0 +1,336 @@
+// Package fsck cross-checks the tapes in the database against the files
+// in the server's user directory, and optionally repairs what drifted.
+package fsck
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"time"
+)
+
+const (
+	// KindOrphan is a file or directory no tape owns.  Repair deletes it.
+	KindOrphan = "orphan"
+	// KindMissing is a finished tape without the files of its sources.
+	// Repair fails the tape so an admin can retry it.
+	KindMissing = "missing"
+	// KindSize is a tape whose size is not the size of its files.  Repair
+	// saves the size on disk, which fixes the owner's usage too.
+	KindSize = "size"
+	// KindDuration is a finished tape with audio but no duration.  The
+	// files are not decoded so it is only reported.
+	KindDuration = "duration"
+	// KindStuck is a tape in progress for longer than a capture takes,
+	// left behind by a crash.  Repair puts it back in the queue.
+	KindStuck = "stuck"
+)
+
+// DefaultStuckAfter is how long a tape may be in progress before it is
+// reported as stuck.
+const DefaultStuckAfter = 12 * time.Hour
+
+// stationDir holds the station logos, stationLogoDir in the app package.
+const stationDir = "station"
+
+// deletedPrefix names the directories of deletes in progress, which are
+// removed once their transaction commits.
+const deletedPrefix = ".deleted-"
+
+// snapshotTaken runs once the tapes and users are read, before the user
+// directory is walked.  Tests use it to change the database in between.
+var snapshotTaken = func() {}
+
+// Options control a check.
+type Options struct {
+	// UserDir is the server's user directory.
+	UserDir string
+	// Repair fixes the problems that can be fixed instead of only
+	// reporting them.
+	Repair bool
+	// StuckAfter is [DefaultStuckAfter] when 0.
+	StuckAfter time.Duration
+	// Now is the time a stuck tape is measured from.
+	Now time.Time
+}
+
+// Problem is one difference between the database and the disk.
+type Problem struct {
+	Kind string
+	// Path is relative to the user directory.
+	Path string
+	// Tape is nil for orphans.
+	Tape   *tape.Tape
+	Detail string
+	// Repaired is set once the problem is fixed.
+	Repaired bool
+}
+
+func (p *Problem) String() string {
+	if p.Tape != nil {
+		return fmt.Sprintf("%s %v %s: %s", p.Kind, p.Tape, p.Path, p.Detail)
+	}
+	return fmt.Sprintf("%s %s: %s", p.Kind, p.Path, p.Detail)
+}
+
+// Report is the result of a check.
+type Report struct {
+	Tapes    int
+	Problems []Problem
+}
+
+// Repaired counts the problems fixed.
+func (r *Report) Repaired() int {
+	count := 0
+	for _, p := range r.Problems {
+		if p.Repaired {
+			count++
+		}
+	}
+	return count
+}
+
+// Check compares every tape and its sources with the user directory.  A
+// repair that fails stops the check with the report so far.
+func Check(db *database.Database, opts Options) (*Report, error) {
+	log.Println("enter Fsck", opts.UserDir, opts.Repair)
+	defer log.Println("exit Fsck")
+
+	if opts.StuckAfter == 0 {
+		opts.StuckAfter = DefaultStuckAfter
+	}
+
+	tapes, err := tape.GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	users, err := user.GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotTaken()
+
+	report := &Report{Tapes: len(tapes)}
+	add := func(p Problem, repair func() error) error {
+		if opts.Repair && repair != nil {
+			if err := repair(); err != nil {
+				return fmt.Errorf("repair %v: %w", &p, err)
+			}
+			p.Repaired = true
+		}
+		log.Println("fsck found", &p, "repaired", p.Repaired)
+		report.Problems = append(report.Problems, p)
+		return nil
+	}
+
+	owned := map[string]bool{}
+	for _, t := range tapes {
+		// never look outside the user directory
+		if t.FsPath == "" || !filepath.IsLocal(t.FsPath) {
+			continue
+		}
+		owned[filepath.Clean(t.FsPath)] = true
+
+		err = checkTape(db, opts, t, add)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	userDirs := map[string]bool{}
+	for _, u := range users {
+		userDirs[u.Uuid] = true
+	}
+	err = checkOrphans(db, opts.UserDir, userDirs, owned, add)
+	return report, err
+}
+
+// checkTape compares one tape with its directory.
+func checkTape(db *database.Database, opts Options, t *tape.Tape, add func(Problem, func() error) error) error {
+	dir := filepath.Join(opts.UserDir, t.FsPath)
+	names, err := fileNames(dir)
+	if err != nil {
+		return err
+	}
+
+	if t.Status == tape.StatusInProgress {
+		since := t.Updated
+		if since == "" {
+			since = t.Created
+		}
+		started, err := time.Parse(time.RFC3339, since)
+		if err == nil && opts.Now.Sub(started) > opts.StuckAfter {
+			err = add(Problem{Kind: KindStuck, Path: t.FsPath, Tape: t, Detail: "in progress since " + since}, func() error {
+				_, err := tape.SetStatus(db, t.Id, tape.StatusInProgress, tape.StatusTodo, "put back in the queue by fsck")
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.Status == tape.StatusDone {
+		missing, err := missingFiles(db, t, names)
+		if err != nil {
+			return err
+		}
+		if missing != "" {
+			err = add(Problem{Kind: KindMissing, Path: t.FsPath, Tape: t, Detail: missing}, func() error {
+				_, err := tape.SetStatus(db, t.Id, tape.StatusDone, tape.StatusError, "fsck: "+missing)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		} else if t.Duration == 0 {
+			err = add(Problem{Kind: KindDuration, Path: t.FsPath, Tape: t, Detail: fmt.Sprintf("%d files but no duration", len(names))}, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// a capture sets the size as it goes
+	if t.Status != tape.StatusInProgress {
+		size := file.DirSize(dir)
+		if size != t.Size {
+			err = add(Problem{Kind: KindSize, Path: t.FsPath, Tape: t, Detail: fmt.Sprintf("%d bytes saved, %d on disk", t.Size, size)}, func() error {
+				return tape.SetSize(db, t.Id, size)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fileNames returns the names of the regular files in dir, none when dir
+// does not exist.
+func fileNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// missingFiles describes what a finished tape lacks, empty when it has a
+// file and one for each file source's extension.
+func missingFiles(db *database.Database, t *tape.Tape, names []string) (string, error) {
+	if len(names) == 0 {
+		return "no files", nil
+	}
+
+	sources, err := tape.GetSources(db, t.Id)
+	if err != nil {
+		return "", err
+	}
+
+	for _, s := range sources {
+		if s.Type != tape.TypeFile || s.FileExtension == "" {
+			continue
+		}
+
+		ext := strings.ToLower(s.FileExtension)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		found := false
+		for _, name := range names {
+			if strings.ToLower(filepath.Ext(name)) == ext {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("no %s file for source %d", ext, s.Seq), nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkOrphans reports the entries of the user directory that no user or
+// tape owns.  Station logos and deletes in progress are left alone.
+//
+// userDirs and owned are read before the walk, so on a running server a
+// user or tape added since then is looked up again before its directory
+// counts as an orphan.
+func checkOrphans(db *database.Database, userDir string, userDirs map[string]bool, owned map[string]bool, add func(Problem, func() error) error) error {
+	orphan := func(path string, detail string) error {
+		return add(Problem{Kind: KindOrphan, Path: path, Detail: detail}, func() error {
+			return os.RemoveAll(filepath.Join(userDir, path))
+		})
+	}
+
+	entries, err := os.ReadDir(userDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == stationDir || strings.HasPrefix(name, deletedPrefix) {
+			continue
+		}
+		if entry.IsDir() && !userDirs[name] {
+			u, err := user.GetByUuid(db, name)
+			if err != nil {
+				return err
+			}
+			userDirs[name] = u != nil
+		}
+		if !entry.IsDir() || !userDirs[name] {
+			err = orphan(name, "no user")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		tapeEntries, err := os.ReadDir(filepath.Join(userDir, name))
+		if err != nil {
+			return err
+		}
+		for _, tapeEntry := range tapeEntries {
+			path := filepath.Join(name, tapeEntry.Name())
+			if strings.HasPrefix(tapeEntry.Name(), deletedPrefix) || owned[path] {
+				continue
+			}
+			t, err := tape.GetByFsPath(db, path)
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				continue
+			}
+			err = orphan(path, "no tape")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
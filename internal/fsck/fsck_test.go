@@ -0,0 +1,208 @@
+package fsck_test
+
+import (
+	"os"
+	"path/filepath"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"tapedeck/internal/fsck"
+	"testing"
+	"time"
+)
+
+const testEmail = "tapedeck.us@gmail.com"
+
+const dbPath = "./unit-test.db"
+
+// setup returns a user with a station to record their tapes from.
+func setup(t *testing.T) (*database.Database, *user.User, *station.Station) {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); file.Delete(dbPath) })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Insert(db, user.New(testEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, u, &s
+}
+
+// write creates the file below userDir with size bytes.
+func write(t *testing.T, userDir string, path string, size int) {
+	t.Helper()
+	full := filepath.Join(userDir, path)
+	err := os.MkdirAll(filepath.Dir(full), 0o750)
+	if err == nil {
+		err = os.WriteFile(full, make([]byte, size), 0o640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db, u, s := setup(t)
+	userDir := t.TempDir()
+	now := time.Now()
+
+	insert := func(title string, status string) *tape.Tape {
+		t.Helper()
+		tp := tape.New(u.Id, u.Uuid, s.Id, title, "2026-10-17")
+		tp.Status = status
+		err := tape.Insert(db, &tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tp
+	}
+
+	// in step with the disk
+	good := insert("Late Risers Club", tape.StatusDone)
+	write(t, userDir, filepath.Join(good.FsPath, "a.mp3"), 10)
+	err := tape.SetSize(db, good.Id, 10)
+	if err == nil {
+		err = tape.SetDuration(db, good.Id, 3600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted by hand, with a source promising an mp3
+	missing := insert("Hillbilly at Harvard", tape.StatusDone)
+	err = tape.InsertSource(db, &tape.TapeSource{TapeId: missing.Id, Type: tape.TypeFile, Url: "https://wmbr.org/a.mp3", FileExtension: ".mp3"})
+	if err == nil {
+		err = tape.SetSize(db, missing.Id, 20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// crashed long ago
+	stuck := insert("Breakfast of Champions", tape.StatusInProgress)
+
+	write(t, userDir, filepath.Join(u.Uuid, "lost", "b.mp3"), 5)
+	write(t, userDir, filepath.Join("nobody", "c.mp3"), 5)
+	write(t, userDir, filepath.Join("station", "1.png"), 5)
+	write(t, userDir, filepath.Join(u.Uuid, ".deleted-gone", "d.mp3"), 5)
+
+	later := now.Add(fsck.DefaultStuckAfter + time.Hour)
+	check := func(repair bool) map[string]int {
+		t.Helper()
+		report, err := fsck.Check(db, fsck.Options{UserDir: userDir, Repair: repair, Now: later})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds := map[string]int{}
+		for _, p := range report.Problems {
+			kinds[p.Kind]++
+			if p.Repaired != repair && p.Kind != fsck.KindDuration {
+				t.Fatalf("expected repaired %v, actual %v", repair, &p)
+			}
+		}
+		return kinds
+	}
+
+	expected := map[string]int{fsck.KindOrphan: 2, fsck.KindMissing: 1, fsck.KindSize: 1, fsck.KindStuck: 1}
+	kinds := check(false)
+	if len(kinds) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, kinds)
+	}
+	for kind, count := range expected {
+		if kinds[kind] != count {
+			t.Fatalf("expected %v, actual %v", expected, kinds)
+		}
+	}
+
+	// a dry run changes nothing
+	if _, err := os.Stat(filepath.Join(userDir, "nobody")); err != nil {
+		t.Fatalf("expected the orphan to be kept, actual %v", err)
+	}
+	if kinds = check(true); len(kinds) != len(expected) {
+		t.Fatalf("expected %v repaired, actual %v", expected, kinds)
+	}
+	if kinds = check(false); len(kinds) != 0 {
+		t.Fatalf("expected nothing left to repair, actual %v", kinds)
+	}
+
+	for _, path := range []string{"nobody", filepath.Join(u.Uuid, "lost")} {
+		if _, err := os.Stat(filepath.Join(userDir, path)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted, actual %v", path, err)
+		}
+	}
+	for _, path := range []string{"station", filepath.Join(u.Uuid, ".deleted-gone"), good.FsPath} {
+		if _, err := os.Stat(filepath.Join(userDir, path)); err != nil {
+			t.Fatalf("expected %s to be kept, actual %v", path, err)
+		}
+	}
+
+	for tp, status := range map[*tape.Tape]string{missing: tape.StatusError, stuck: tape.StatusTodo, good: tape.StatusDone} {
+		read, err := tape.GetTape(tp.Id, db)
+		if err != nil || read.Status != status {
+			t.Fatalf("expected %v to be %s, actual %v %v", tp, status, read, err)
+		}
+	}
+
+	u, err = user.GetById(db, u.Id)
+	if err != nil || u.BytesUsed != 10 {
+		t.Fatalf("expected the usage fixed, actual %v %v", u, err)
+	}
+}
+
+func TestCheckKeepsNewTapes(t *testing.T) {
+	db, u, s := setup(t)
+	userDir := t.TempDir()
+
+	// a tape and a user added while the check runs, their files already
+	// on disk when the user directory is walked
+	created := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	newcomer := user.New("listener@example.com")
+	fsck.SetSnapshotTaken(t.Cleanup, func() {
+		err := tape.Insert(db, &created)
+		if err == nil {
+			err = user.Insert(db, newcomer)
+		}
+		if err == nil {
+			err = os.MkdirAll(filepath.Join(userDir, newcomer.Uuid), 0o750)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(t, userDir, filepath.Join(created.FsPath, "a.mp3"), 5)
+	})
+
+	report, err := fsck.Check(db, fsck.Options{UserDir: userDir, Repair: true, Now: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, actual %v", report.Problems)
+	}
+
+	for _, path := range []string{created.FsPath, newcomer.Uuid} {
+		if _, err := os.Stat(filepath.Join(userDir, path)); err != nil {
+			t.Fatalf("expected %s to be kept, actual %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFsckAdmin(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+	makeAdmin(t, db, testEmail)
+
+	orphan := filepath.Join(userDir, "nobody", "a.mp3")
+	err := os.MkdirAll(filepath.Dir(orphan), 0o750)
+	if err == nil {
+		err = os.WriteFile(orphan, []byte("mp3"), 0o640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := chain(makeUserLookup(db, &authSettings{trust: trust}, tmplEngine), makeAdminOnly(tmplEngine), makeFsckAdminHandler(db, userDir, tmplEngine))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest("127.0.0.1:5000", testEmail))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "orphan") {
+		t.Fatalf("expected the orphan reported, actual %d %q", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("expected the check to change nothing, actual %v", err)
+	}
+
+	w = postForm(handler, "/s/admin/fsck", url.Values{"action": {"repair"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Repaired 1 problems") || !strings.Contains(w.Body.String(), "All 0 tapes match") {
+		t.Fatalf("expected the orphan repaired, actual %d %q", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("expected the orphan deleted, actual %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/grant"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+)
+
+// grantTarget is the tape or collection whose grants the grants page
+// manages.
+type grantTarget struct {
+	// Kind is the name of the query parameter, "tape" or "collection".
+	Kind     string
+	PublicId string
+	Name     string
+	// Back is the page of the tape or collection.
+	Back string
+
+	tapeId       int64
+	collectionId int64
+}
+
+func (g *grantTarget) list(db *database.Database) ([]*grant.Grant, error) {
+	if g.tapeId != 0 {
+		return grant.GetForTape(db, g.tapeId)
+	}
+	return grant.GetForCollection(db, g.collectionId)
+}
+
+func (g *grantTarget) grant(userId int64, access string, grantedBy string) grant.Grant {
+	if g.tapeId != 0 {
+		return grant.ForTape(g.tapeId, userId, access, grantedBy)
+	}
+	return grant.ForCollection(g.collectionId, userId, access, grantedBy)
+}
+
+func (g *grantTarget) revoke(db *database.Database, id int64) error {
+	if g.tapeId != 0 {
+		return grant.DeleteForTape(db, g.tapeId, id)
+	}
+	return grant.DeleteForCollection(db, g.collectionId, id)
+}
+
+// getGrantTarget returns the user's own tape or collection named by the
+// tape or collection parameter.  Anything else is reported as not found.
+func getGrantTarget(w http.ResponseWriter, r *http.Request, db *database.Database, u *user.User) *grantTarget {
+	if publicId := r.FormValue("tape"); publicId != "" {
+		t := getTapeForUser(w, r, db, u, publicId, tape.AccessOwner, false)
+		if t == nil {
+			return nil
+		}
+		return &grantTarget{Kind: "tape", PublicId: t.PublicId, Name: t.Title, Back: "/s/playback?id=" + url.QueryEscape(t.PublicId), tapeId: t.Id}
+	}
+
+	c, err := collection.Get(db, r.FormValue("collection"), u.Id, tape.AccessOwner)
+	if errors.Is(err, collection.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return nil
+	}
+	return &grantTarget{Kind: "collection", PublicId: c.PublicId, Name: c.Name, Back: "/s/collection?id=" + url.QueryEscape(c.PublicId), collectionId: c.Id}
+}
+
+// grantsPage is the data for grants.html.
+type grantsPage struct {
+	Csrf   string
+	Target *grantTarget
+	Grants []*grant.Grant
+	Error  string
+}
+
+// makeGrantsHandler shows who the user shared a tape or collection with,
+// shares it with another user by email and takes access back.
+func makeGrantsHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeGrantsHandler", r.URL.String())
+			defer log.Println("exit MakeGrantsHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			target := getGrantTarget(w, r, db, u)
+			if target == nil {
+				return
+			}
+
+			page := grantsPage{Csrf: csrfToken(r), Target: target}
+
+			if r.Method == http.MethodPost {
+				err := changeGrants(r, db, u, target)
+				if err == nil {
+					http.Redirect(w, r, "/s/grants?"+target.Kind+"="+url.QueryEscape(target.PublicId), http.StatusSeeOther)
+					return
+				}
+
+				page.Error = err.Error()
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			grants, err := target.list(db)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Grants = grants
+
+			bytes, evalErr := tmplEngine.eval("grants.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// changeGrants shares the target with the user of the email, or takes
+// back the grant with the id.
+func changeGrants(r *http.Request, db *database.Database, u *user.User, target *grantTarget) error {
+	switch action := r.PostFormValue("action"); action {
+	case "add":
+		email := strings.TrimSpace(r.PostFormValue("email"))
+		grantee, err := user.GetByEmail(db, email)
+		if err != nil {
+			return err
+		}
+		if grantee == nil {
+			return fmt.Errorf("no tapedeck user has the email %q", email)
+		}
+		if grantee.Id == u.Id {
+			return fmt.Errorf("you own this %s", target.Kind)
+		}
+
+		g := target.grant(grantee.Id, r.PostFormValue("access"), u.Email)
+		if err := g.Validate(); err != nil {
+			return err
+		}
+		return grant.Put(db, &g)
+	case "remove":
+		id, err := strconv.ParseInt(r.PostFormValue("grant"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid grant %q", r.PostFormValue("grant"))
+		}
+		return target.revoke(db, id)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
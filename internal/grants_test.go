@@ -0,0 +1,172 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/grant"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+)
+
+func TestGrants(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const listenerEmail = "listener@example.com"
+	err = user.Insert(db, user.New(listenerEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err = tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := collection.New(u.Id, "Saturday jazz 2026", "")
+	err = collection.Insert(db, &c)
+	if err == nil {
+		err = collection.AddTape(db, c.Id, tp.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := makeUserLookup(db, &authSettings{trust: trust}, tmplEngine)
+	grants := chain(lookup, makeGrantsHandler(db, tmplEngine))
+	playback := chain(lookup, makePlaybackHandler(db, userDir, tmplEngine))
+	edit := chain(lookup, makeEditTapeHandler(db, tmplEngine))
+	del := chain(lookup, makeDeleteTapeHandler(db, tmplEngine))
+	list := chain(lookup, makeListHandler(db, tmplEngine))
+	page := chain(lookup, makeCollectionHandler(db, userDir, trust, tmplEngine))
+
+	// as sends the request as the listener
+	as := func(handler http.HandlerFunc, method string, query url.Values, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/s/?"+query.Encode(), strings.NewReader(form.Encode()))
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-EMAIL", listenerEmail)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	byId := url.Values{"id": {tp.PublicId}}
+
+	if w := as(playback, http.MethodGet, byId, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected %d before sharing, actual %d", http.StatusNotFound, w.Code)
+	}
+
+	w := postForm(grants, "/s/grants", url.Values{"tape": {tp.PublicId}, "action": {"add"}, "email": {"nobody@example.com"}, "access": {"view"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no tapedeck user") {
+		t.Fatalf("expected an unknown email, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = postForm(grants, "/s/grants", url.Values{"tape": {tp.PublicId}, "action": {"add"}, "email": {listenerEmail}, "access": {"view"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("share: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+
+	// viewers can play but not change the tape
+	w = as(playback, http.MethodGet, byId, nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "/s/edit") {
+		t.Fatalf("expected playback without edit, actual %d %q", w.Code, w.Body.String())
+	}
+	if w = as(edit, http.MethodGet, byId, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("edit: expected %d for a viewer, actual %d", http.StatusNotFound, w.Code)
+	}
+	w = as(list, http.MethodGet, nil, nil)
+	if !strings.Contains(w.Body.String(), "Shared with Me") || !strings.Contains(w.Body.String(), "Late Risers Club") {
+		t.Fatalf("expected the tape to be shared on the list, actual %q", w.Body.String())
+	}
+
+	// editors can change it but not move it to the trash or share it
+	w = postForm(grants, "/s/grants", url.Values{"tape": {tp.PublicId}, "action": {"add"}, "email": {listenerEmail}, "access": {"edit"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("share: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	form := url.Values{"id": {tp.PublicId}, "title": {"Late Risers"}, "airDate": {"2026-10-17"}, "station": {fmt.Sprint(s.Id)}}
+	if w = as(edit, http.MethodPost, nil, form); w.Code != http.StatusSeeOther {
+		t.Fatalf("edit: expected %d for an editor, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	if w = as(del, http.MethodPost, nil, byId); w.Code != http.StatusNotFound {
+		t.Fatalf("delete: expected %d for an editor, actual %d", http.StatusNotFound, w.Code)
+	}
+	if w = as(grants, http.MethodGet, url.Values{"tape": {tp.PublicId}}, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("grants: expected %d for an editor, actual %d", http.StatusNotFound, w.Code)
+	}
+
+	given, err := grant.GetForTape(db, tp.Id)
+	if err != nil || len(given) != 1 {
+		t.Fatalf("expected one grant, actual %v %v", given, err)
+	}
+	w = postForm(grants, "/s/grants", url.Values{"tape": {tp.PublicId}, "action": {"remove"}, "grant": {fmt.Sprint(given[0].Id)}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("remove: expected %d, actual %d", http.StatusSeeOther, w.Code)
+	}
+	if w = as(playback, http.MethodGet, byId, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected %d after removing the grant, actual %d", http.StatusNotFound, w.Code)
+	}
+
+	// sharing a collection shares its tapes for listening
+	w = postForm(grants, "/s/grants", url.Values{"collection": {c.PublicId}, "action": {"add"}, "email": {listenerEmail}, "access": {"view"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("share: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	w = as(page, http.MethodGet, url.Values{"id": {c.PublicId}}, nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `value="save"`) {
+		t.Fatalf("expected the collection without edit, actual %d %q", w.Code, w.Body.String())
+	}
+	if w = as(page, http.MethodPost, nil, url.Values{"id": {c.PublicId}, "action": {"save"}, "name": {"Mine"}}); w.Code != http.StatusNotFound {
+		t.Fatalf("save: expected %d for a viewer, actual %d", http.StatusNotFound, w.Code)
+	}
+	if w = as(playback, http.MethodGet, byId, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected the collection's tape to play, actual %d", w.Code)
+	}
+
+	// editors reorder the collection but never take the owner's tapes out
+	w = postForm(grants, "/s/grants", url.Values{"collection": {c.PublicId}, "action": {"add"}, "email": {listenerEmail}, "access": {"edit"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("share: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	w = as(page, http.MethodGet, url.Values{"id": {c.PublicId}}, nil)
+	if !strings.Contains(w.Body.String(), `value="up"`) || strings.Contains(w.Body.String(), `value="remove"`) {
+		t.Fatalf("expected reordering without remove, actual %q", w.Body.String())
+	}
+	form = url.Values{"id": {c.PublicId}, "action": {"remove"}, "tape": {tp.PublicId}}
+	if w = as(page, http.MethodPost, nil, form); w.Code != http.StatusNotFound {
+		t.Fatalf("remove: expected %d for an editor, actual %d", http.StatusNotFound, w.Code)
+	}
+	tapes, err := tape.GetInCollection(db, c.Id)
+	if err != nil || len(tapes) != 1 {
+		t.Fatalf("expected the tape to stay in the collection, actual %v %v", tapes, err)
+	}
+}
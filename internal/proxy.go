@@ -0,0 +1,87 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// proxySecretHeader carries the shared secret that proves a request came
+// through the reverse proxy.  See [ServerConfig.ProxySecret].
+const proxySecretHeader = "X-Proxy-Secret"
+
+// defaultTrustedProxies is used when the config does not list any.
+// nginx and oauth2-proxy run on the same box as the server.
+var defaultTrustedProxies = []string{"127.0.0.1/32", "::1/128"}
+
+// proxyTrust decides whether the identity headers set by oauth2-proxy
+// can be believed for a request.
+type proxyTrust struct {
+	prefixes []netip.Prefix
+	secret   string
+}
+
+func (p *proxyTrust) String() string {
+	return fmt.Sprintf("proxy trust %v secret %v", p.prefixes, p.secret != "")
+}
+
+// newProxyTrust parses the CIDRs of the trusted proxies.  An empty list
+// means [defaultTrustedProxies].  When secret is not empty the request
+// must also carry it in the [proxySecretHeader] header.
+func newProxyTrust(cidrs []string, secret string) (*proxyTrust, error) {
+	if len(cidrs) == 0 {
+		cidrs = defaultTrustedProxies
+	}
+
+	p := &proxyTrust{secret: secret}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+
+	return p, nil
+}
+
+// trusted reports whether the request came from a trusted proxy.
+func (p *proxyTrust) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		log.Printf("cannot parse remote address %q: %v\n", r.RemoteAddr, err)
+		return false
+	}
+	addr = addr.Unmap()
+
+	found := false
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		log.Printf("remote address %v is not a trusted proxy\n", addr)
+		return false
+	}
+
+	if p.secret != "" {
+		given := r.Header.Get(proxySecretHeader)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(p.secret)) != 1 {
+			log.Printf("%s header missing or wrong from %v\n", proxySecretHeader, addr)
+			return false
+		}
+	}
+
+	return true
+}
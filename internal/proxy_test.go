@@ -0,0 +1,190 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/token"
+	"tapedeck/internal/database/user"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+const testEmail = "tapedeck.us@gmail.com"
+
+const dbPath = "./unit-test.db"
+
+func setupDb(t *testing.T) *database.Database {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); file.Delete(dbPath) })
+
+	err := db.Open()
+	if err == nil {
+		err = db.Upgrade()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Insert(db, user.New(testEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// okHandler responds 200 when a user was added to the request context.
+func okHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if getUserFromRequest(w, r) != nil {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+// lookupStatus runs r through makeUserLookup and returns the response status.
+func lookupStatus(t *testing.T, db *database.Database, trust *proxyTrust, r *http.Request) int {
+	handler := chain(makeUserLookup(db, &authSettings{trust: trust}, nil), okHandler)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func newRequest(remoteAddr string, email string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/s/list", nil)
+	r.RemoteAddr = remoteAddr
+	if email != "" {
+		r.Header.Set("X-EMAIL", email)
+	}
+	return r
+}
+
+func TestNewProxyTrust(t *testing.T) {
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trust.prefixes) != len(defaultTrustedProxies) {
+		t.Fatalf("expected default proxies, actual %v", trust)
+	}
+
+	_, err = newProxyTrust([]string{"10.0.0.0/8", "not-a-cidr"}, "")
+	if err == nil {
+		t.Fatalf("expected error for invalid cidr")
+	}
+}
+
+func TestProxyTrusted(t *testing.T) {
+	trust, err := newProxyTrust([]string{"10.1.0.0/16", "::1/128"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		trusted    bool
+	}{
+		{"10.1.2.3:5000", true},
+		{"[::1]:5000", true},
+		{"[::ffff:10.1.2.3]:5000", true},
+		{"10.2.0.1:5000", false},
+		{"127.0.0.1:5000", false},
+		{"203.0.113.9:5000", false},
+		{"garbage", false},
+	}
+
+	for _, test := range tests {
+		actual := trust.trusted(newRequest(test.remoteAddr, testEmail))
+		if actual != test.trusted {
+			t.Errorf("%s: expected trusted %v, actual %v", test.remoteAddr, test.trusted, actual)
+		}
+	}
+}
+
+func TestProxySecret(t *testing.T) {
+	trust, err := newProxyTrust(nil, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRequest("127.0.0.1:5000", testEmail)
+	if trust.trusted(r) {
+		t.Fatalf("request without secret should not be trusted")
+	}
+
+	r.Header.Set(proxySecretHeader, "wrong")
+	if trust.trusted(r) {
+		t.Fatalf("request with wrong secret should not be trusted")
+	}
+
+	r.Header.Set(proxySecretHeader, "s3cret")
+	if !trust.trusted(r) {
+		t.Fatalf("request with secret should be trusted")
+	}
+
+	r = newRequest("203.0.113.9:5000", testEmail)
+	r.Header.Set(proxySecretHeader, "s3cret")
+	if trust.trusted(r) {
+		t.Fatalf("request with secret from untrusted address should not be trusted")
+	}
+}
+
+func TestUserLookupRejectsUntrusted(t *testing.T) {
+	db := setupDb(t)
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := lookupStatus(t, db, trust, newRequest("127.0.0.1:5000", testEmail))
+	if status != http.StatusOK {
+		t.Fatalf("trusted request: expected %d, actual %d", http.StatusOK, status)
+	}
+
+	status = lookupStatus(t, db, trust, newRequest("203.0.113.9:5000", testEmail))
+	if status != http.StatusForbidden {
+		t.Fatalf("untrusted request: expected %d, actual %d", http.StatusForbidden, status)
+	}
+
+	status = lookupStatus(t, db, trust, newRequest("127.0.0.1:5000", "nobody@example.com"))
+	if status != http.StatusNotFound {
+		t.Fatalf("unknown user: expected %d, actual %d", http.StatusNotFound, status)
+	}
+}
+
+func TestUserLookupTokenFromUntrusted(t *testing.T) {
+	db := setupDb(t)
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk, secret, err := token.New(u.Id, "test", token.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = token.Insert(db, &tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// API tokens authenticate themselves so the proxy is not involved.
+	r := newRequest("203.0.113.9:5000", "")
+	r.Header.Set("Authorization", "Bearer "+secret)
+	status := lookupStatus(t, db, trust, r)
+	if status != http.StatusOK {
+		t.Fatalf("token request: expected %d, actual %d", http.StatusOK, status)
+	}
+}
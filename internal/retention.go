@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"time"
+)
+
+// pruneInterval is how often the tapes the retention rules no longer keep
+// are deleted with their files.
+const pruneInterval = time.Hour
+
+// prunedTape is a tape the retention rules delete, for the dry run
+// reports.
+type prunedTape struct {
+	tape.Expired
+	Owner string
+	// Rule names the rule that prunes the tape.
+	Rule string
+}
+
+// planPrune returns the tapes the rules of the users and of their
+// collections prune at now, each tape once.  Nothing is deleted.
+func planPrune(db *database.Database, users []*user.User, now time.Time) ([]prunedTape, error) {
+	owners := make(map[int64]*user.User, len(users))
+	for _, u := range users {
+		owners[u.Id] = u
+	}
+
+	planned := []prunedTape{}
+	seen := map[int64]bool{}
+	add := func(expired []tape.Expired, owner *user.User, rule string) {
+		for _, e := range expired {
+			if !seen[e.Id] {
+				seen[e.Id] = true
+				planned = append(planned, prunedTape{Expired: e, Owner: owner.Email, Rule: rule})
+			}
+		}
+	}
+
+	for _, u := range users {
+		if !u.Retention.Active() {
+			continue
+		}
+		tapes, err := tape.GetTapesForUser(u.Id, db)
+		if err != nil {
+			return nil, err
+		}
+		add(u.Retention.Expired(tapes, now, tape.Show), u, "account")
+	}
+
+	collections, err := collection.GetWithRetention(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range collections {
+		owner, ok := owners[c.UserId]
+		if !ok {
+			continue
+		}
+		tapes, err := tape.GetInCollection(db, c.Id)
+		if err != nil {
+			return nil, err
+		}
+		// the collection is one group
+		add(c.Retention.Expired(tapes, now, func(*tape.Tape) string { return "" }), owner, "collection "+c.Name)
+	}
+
+	return planned, nil
+}
+
+// prune deletes the tapes the retention rules no longer keep at now, with
+// their files.  Each tape is read again right before it is deleted, so a
+// star given in the meantime still saves it.  It returns the number of
+// tapes deleted.
+func prune(ctx context.Context, db *database.Database, userDir string, now time.Time) (int, error) {
+	users, err := user.GetAll(db)
+	if err != nil {
+		return 0, err
+	}
+
+	planned, err := planPrune(db, users, now)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, p := range planned {
+		t, err := tape.GetTape(p.Id, db)
+		if err != nil {
+			return count, err
+		}
+		if t == nil || !t.Prunable() {
+			continue
+		}
+
+		log.Println("pruning", t, p.Reason, "by the", p.Rule, "rule")
+		err = removeTape(ctx, db, userDir, t)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// startPruner runs [prune] every interval until ctx is done.  The
+// returned channel is closed once the last run has finished.
+func startPruner(ctx context.Context, db *database.Database, userDir string, interval time.Duration) <-chan struct{} {
+	log.Println("pruning by the retention rules every", interval)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				count, err := prune(ctx, db, userDir, now)
+				if err != nil {
+					log.Println("prune failed:", err)
+				} else if count > 0 {
+					log.Println("prune deleted", count, "tapes")
+				}
+			}
+		}
+	}()
+
+	return done
+}
+
+// parseRetention reads the keepLast and keepDays fields of a form.
+func parseRetention(r *http.Request) (tape.Retention, error) {
+	keepLast, err := formInt(r, "keepLast")
+	if err != nil {
+		return tape.Retention{}, err
+	}
+	keepDays, err := formInt(r, "keepDays")
+	if err != nil {
+		return tape.Retention{}, err
+	}
+
+	rule := tape.Retention{KeepLast: keepLast, KeepDays: keepDays}
+	return rule, rule.Validate()
+}
+
+// formInt reads a number field of a form, where empty means 0.
+func formInt(r *http.Request, name string) (int, error) {
+	value := strings.TrimSpace(r.PostFormValue(name))
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a number", name, value)
+	}
+	return n, nil
+}
+
+// retentionPage is the data for retention.html.
+type retentionPage struct {
+	Csrf      string
+	Retention tape.Retention
+	// Collections are the user's collections with a rule of their own.
+	Collections []*collection.Collection
+	// Planned is the dry run, what the next prune deletes.
+	Planned []prunedTape
+	Message string
+	Error   string
+}
+
+// makeRetentionHandler shows and saves the user's retention rule, with the
+// tapes the next prune would delete by it and by the user's collections'
+// rules.
+func makeRetentionHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeRetentionHandler", r.URL.String())
+			defer log.Println("exit MakeRetentionHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			page := retentionPage{Csrf: csrfToken(r), Retention: u.Retention}
+
+			if r.Method == http.MethodPost {
+				rule, err := parseRetention(r)
+				if err == nil {
+					err = user.SetRetention(db, u.Id, rule)
+				}
+				if err != nil {
+					page.Error = err.Error()
+					w.WriteHeader(http.StatusBadRequest)
+				} else {
+					page.Message = "Saved"
+					page.Retention = rule
+				}
+			}
+
+			// plan with the rule just saved
+			planFor := *u
+			planFor.Retention = page.Retention
+
+			planned, err := planPrune(db, []*user.User{&planFor}, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Planned = planned
+
+			collections, err := collection.GetForUser(db, u.Id)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			for _, c := range collections {
+				if c.Retention.Active() {
+					page.Collections = append(page.Collections, c)
+				}
+			}
+
+			bytes, evalErr := tmplEngine.eval("retention.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// makeStarHandler stars a tape so the retention rules keep it forever, or
+// takes the star away.  Only the owner can.
+func makeStarHandler(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeStarHandler", r.URL.String())
+			defer log.Println("exit MakeStarHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			t := getTapeForUser(w, r, db, u, r.FormValue("id"), tape.AccessOwner, false)
+			if t == nil {
+				return
+			}
+
+			err := tape.SetStarred(db, t.Id, r.PostFormValue("starred") == "true")
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			http.Redirect(w, r, "/s/playback?id="+url.QueryEscape(t.PublicId), http.StatusSeeOther)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"tapedeck/internal/database/collection"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tapes := []*tape.Tape{}
+	for _, airDate := range []string{"2026-10-03", "2026-10-10", "2026-10-17"} {
+		tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", airDate)
+		tp.Status = tape.StatusDone
+		err = tape.Insert(db, &tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		audio := filepath.Join(userDir, tp.FsPath, "a.mp3")
+		err = os.MkdirAll(filepath.Dir(audio), 0o750)
+		if err == nil {
+			err = os.WriteFile(audio, []byte("mp3"), 0o640)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tapes = append(tapes, &tp)
+	}
+	oldest, older, newest := tapes[0], tapes[1], tapes[2]
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := makeUserLookup(db, &authSettings{trust: trust}, tmplEngine)
+	retention := chain(lookup, makeRetentionHandler(db, tmplEngine))
+	star := chain(lookup, makeStarHandler(db))
+	page := chain(lookup, makeCollectionHandler(db, userDir, trust, tmplEngine))
+
+	w := postForm(retention, "/s/retention", url.Values{"keepLast": {"many"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "is not a number") {
+		t.Fatalf("expected a bad rule, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = postForm(star, "/s/star", url.Values{"id": {older.PublicId}, "starred": {"true"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("star: expected %d, actual %d", http.StatusSeeOther, w.Code)
+	}
+
+	// the dry run lists what the rule deletes, never the starred tape
+	w = postForm(retention, "/s/retention", url.Values{"keepLast": {"1"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), oldest.AirDate) || strings.Contains(w.Body.String(), older.AirDate) {
+		t.Fatalf("expected the oldest tape in the dry run, actual %d %q", w.Code, w.Body.String())
+	}
+
+	count, err := prune(context.Background(), db, userDir, time.Now())
+	if err != nil || count != 1 {
+		t.Fatalf("expected one tape pruned, actual %d %v", count, err)
+	}
+	for _, tp := range tapes {
+		read, err := tape.GetTape(tp.Id, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (read == nil) != (tp == oldest) {
+			t.Fatalf("unexpected tape %d after the prune %v", tp.Id, read)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(userDir, oldest.FsPath)); !os.IsNotExist(err) {
+		t.Fatalf("expected audio to be deleted, actual %v", err)
+	}
+
+	// a collection's rule counts its tapes only
+	err = user.SetRetention(db, u.Id, tape.Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collection.New(u.Id, "Saturday jazz 2026", "")
+	err = collection.Insert(db, &c)
+	if err == nil {
+		err = collection.AddTape(db, c.Id, older.Id)
+	}
+	if err == nil {
+		err = collection.AddTape(db, c.Id, newest.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = postForm(page, "/s/collection", url.Values{"id": {c.PublicId}, "action": {"retention"}, "keepDays": {"1"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("collection rule: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+
+	w = postForm(star, "/s/star", url.Values{"id": {older.PublicId}, "starred": {"false"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unstar: expected %d, actual %d", http.StatusSeeOther, w.Code)
+	}
+
+	count, err = prune(context.Background(), db, userDir, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC))
+	if err != nil || count != 2 {
+		t.Fatalf("expected the collection's tapes pruned, actual %d %v", count, err)
+	}
+}
@@ -3,19 +3,26 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"syscall"
 	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
 	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/token"
 	"tapedeck/internal/database/user"
 	"tapedeck/internal/lazy"
+	"time"
 )
 
 const logHeaders = false
@@ -46,18 +53,68 @@ const (
 	RcTemplateEngine
 	RcStaticDir
 	RcListenAndServe
+	RcTrustedProxies
+	RcAuthMode
+	RcTlsCert
+	RcShutdown
+	RcDbNotFound
+	RcDbState
+	RcDbMigration
+	RcDbOpen
+	RcDbSchema
 )
 
 type contextKey string
 
 const userKey contextKey = "user"
 
+// tokenKey holds the [token.Token] of requests authenticated by an API token.
+const tokenKey contextKey = "token"
+
 type ServerConfig struct {
 	WebDir           string `json:"webDir"`
 	UserDir          string `json:"userDir"`
 	ServerListenAddr string `json:"serverListenAddr"`
 	DbFile           string `json:"dbFile"`
 	ProductionMode   bool   `jons:"productionMode"`
+	// TrustedProxies are the CIDRs of the reverse proxies allowed to set
+	// the X-EMAIL header.  Defaults to the loopback addresses.
+	TrustedProxies []string `json:"trustedProxies"`
+	// ProxySecret, when set, must also be sent by the reverse proxy in
+	// the X-Proxy-Secret header before X-EMAIL is trusted.
+	ProxySecret string `json:"proxySecret"`
+	// AuthMode is "proxy" (the default) to rely on nginx and oauth2-proxy
+	// or "builtin" to sign in with passwords stored in the database.
+	AuthMode string `json:"authMode"`
+	// SessionHours is how long a builtin sign in lasts, two weeks by default.
+	SessionHours int `json:"sessionHours"`
+	// TlsCertFile and TlsKeyFile enable HTTPS on ServerListenAddr.  The files
+	// are reloaded when they change.
+	TlsCertFile string `json:"tlsCertFile"`
+	TlsKeyFile  string `json:"tlsKeyFile"`
+	// HttpRedirectAddr, when set with TLS, listens for plain HTTP and
+	// redirects every request to HTTPS.
+	HttpRedirectAddr string `json:"httpRedirectAddr"`
+	// AcmeWebroot is the certbot --webroot directory served by the redirect listener.
+	AcmeWebroot string `json:"acmeWebroot"`
+	// HstsSeconds is the max-age of the Strict-Transport-Security header sent
+	// with TLS.  Defaults to one year, a negative value disables the header.
+	HstsSeconds int `json:"hstsSeconds"`
+	// ShutdownSeconds is how long in-flight requests get to finish after
+	// SIGTERM or SIGINT, 30 seconds by default.
+	ShutdownSeconds int `json:"shutdownSeconds"`
+	// AutoMigrate applies pending schema migrations on startup.  Without it
+	// the server refuses to start until "cmd/db -action upgrade" is run.
+	AutoMigrate bool `json:"autoMigrate"`
+	// BackupDir and BackupHours turn on scheduled backups of the database.
+	BackupDir   string `json:"backupDir"`
+	BackupHours int    `json:"backupHours"`
+	// BackupKeep is how many scheduled backups are kept, 14 by default.
+	BackupKeep int `json:"backupKeep"`
+	// QuotaMb is how many MiB each user's tapes may take up before no more
+	// are captured, 0 is unlimited.  "cmd/db -action user-quota" gives a
+	// user a quota of their own.
+	QuotaMb int64 `json:"quotaMb"`
 }
 
 // checkDir will join the parentDir to dirName and check that the new dir exists.
@@ -144,28 +201,183 @@ func RunServer(jsonConfigPath string) (rc ReturnCode, err error) {
 	}
 	log.Println("using static directory", staticDir)
 
+	log.Println("validate trusted proxies")
+	trust, trustErr := newProxyTrust(config.TrustedProxies, config.ProxySecret)
+	if trustErr != nil {
+		return RcTrustedProxies, trustErr
+	}
+	log.Println("using", trust)
+
+	log.Println("validate auth mode")
+	auth, authErr := newAuthSettings(config, trust)
+	if authErr != nil {
+		return RcAuthMode, authErr
+	}
+	log.Println("using", auth)
+
+	var reloader *certReloader
+	if config.TlsCertFile != "" || config.TlsKeyFile != "" {
+		log.Println("validate tls certificate")
+		var certErr error
+		reloader, certErr = newCertReloader(config.TlsCertFile, config.TlsKeyFile)
+		if certErr != nil {
+			return RcTlsCert, certErr
+		}
+	}
+
 	log.Println("using db file", config.DbFile)
 	db := database.New(config.DbFile)
 
 	log.Println("open database")
-	db.Open()
-	defer db.Close()
+	err = db.Open()
+	if err != nil {
+		return dbReturnCode(err), err
+	}
+	defer func() {
+		closeErr := db.Close()
+		if closeErr != nil && err == nil {
+			rc, err = dbReturnCode(closeErr), closeErr
+		}
+	}()
+
+	log.Println("check database schema")
+	_, err = checkSchema(db, config.AutoMigrate)
+	if err != nil {
+		return dbReturnCode(err), err
+	}
+
+	quota := config.QuotaMb << 20
+	log.Println("using quota", formatBytes(quota))
 
 	log.Println("server verification complete")
 
+	mux := http.NewServeMux()
+
 	// Open routes
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir))))
-	http.HandleFunc("/", chain(makeLogger, makeRootHandler(tmplEngine)))
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir))))
+	mux.HandleFunc("/", chain(makeLogger, makeRecoverer, makeRootHandler(tmplEngine)))
+	mux.HandleFunc("/login", chain(makeLogger, makeRecoverer, makeLoginHandler(db, auth, tmplEngine)))
+	mux.HandleFunc("/status", chain(makeLogger, makeRecoverer, makeStatusHandler(db)))
+
+	// Shared collections, open to anyone with the link
+	mux.HandleFunc("GET /share/{token}", chain(makeLogger, makeRecoverer, makeShareHandler(db, config.UserDir, trust, tmplEngine)))
+	mux.HandleFunc("GET /share/{token}/feed.xml", chain(makeLogger, makeRecoverer, makeShareFeedHandler(db, config.UserDir, trust)))
+	mux.HandleFunc("GET /share/{token}/audio/{id}/{file}", chain(makeLogger, makeRecoverer, makeShareAudioHandler(db, config.UserDir)))
 
 	// Secure routes
-	http.HandleFunc("/s/list", chain(makeLogger, makeUserLookup(db), makeListHandler(db, tmplEngine)))
-	http.HandleFunc("/s/playback", chain(makeLogger, makeUserLookup(db), makePlaybackHandler(db, tmplEngine)))
-	http.HandleFunc("/s/record", chain(makeLogger, makeUserLookup(db), makeRecordHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/list", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeListHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/playback", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makePlaybackHandler(db, config.UserDir, tmplEngine)))
+	mux.HandleFunc("GET /s/audio/{id}/{file}", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeAudioHandler(db, config.UserDir)))
+	mux.HandleFunc("/s/edit", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeEditTapeHandler(db, tmplEngine)))
+	mux.HandleFunc("POST /s/delete", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeDeleteTapeHandler(db, tmplEngine)))
+	mux.HandleFunc("POST /s/restore", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeRestoreTapeHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/trash", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeTrashHandler(db, config.UserDir, tmplEngine)))
+	mux.HandleFunc("/s/record", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeRecordHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/logout", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeLogoutHandler(db, auth, tmplEngine)))
+	mux.HandleFunc("/s/settings", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeSettingsHandler(db, quota, tmplEngine)))
+	mux.HandleFunc("/s/tokens", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeTokensHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/collections", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeCollectionsHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/retention", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeRetentionHandler(db, tmplEngine)))
+	mux.HandleFunc("POST /s/star", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeStarHandler(db)))
+	mux.HandleFunc("/s/grants", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeGrantsHandler(db, tmplEngine)))
+	mux.HandleFunc("/s/collection", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeCollectionHandler(db, config.UserDir, trust, tmplEngine)))
+	mux.HandleFunc("GET /s/stations/{id}/logo", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeStationLogoHandler(db, config.UserDir)))
+	registerApiRoutes(mux, db, auth, config.UserDir, quota)
+
+	// Admin routes
+	mux.HandleFunc("/s/admin", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeAdminOnly(tmplEngine), makeAdminHandler(db, quota, tmplEngine)))
+	mux.HandleFunc("/s/admin/fsck", chain(makeLogger, makeRecoverer, makeUserLookup(db, auth, tmplEngine), makeAdminOnly(tmplEngine), makeFsckAdminHandler(db, config.UserDir, tmplEngine)))
+	// the body limit comes first as the CSRF check reads the logo upload
+	mux.HandleFunc("/s/admin/stations", chain(makeLogger, makeRecoverer, makeBodyLimit(maxLogoBytes+64<<10), makeUserLookup(db, auth, tmplEngine),
+		makeAdminOnly(tmplEngine), makeStationsAdminHandler(db, config.UserDir, tmplEngine)))
+
+	var handler http.Handler = mux
+	server := &http.Server{Addr: config.ServerListenAddr}
+	servers := []*http.Server{server}
+
+	if reloader != nil {
+		hstsSeconds := config.HstsSeconds
+		if hstsSeconds == 0 {
+			hstsSeconds = defaultHstsSeconds
+		}
+		if hstsSeconds > 0 {
+			handler = withHsts(handler, hstsSeconds)
+		}
 
-	log.Println("server starting on", config.ServerListenAddr)
-	err = http.ListenAndServe(config.ServerListenAddr, nil)
-	if err != nil {
+		server.TLSConfig = newTlsConfig(reloader)
+
+		if config.HttpRedirectAddr != "" {
+			redirect := &http.Server{
+				Addr:    config.HttpRedirectAddr,
+				Handler: newRedirectHandler(config.ServerListenAddr, config.AcmeWebroot),
+			}
+			servers = append(servers, redirect)
+
+			go func() {
+				log.Println("http redirect starting on", redirect.Addr)
+				redirectErr := redirect.ListenAndServe()
+				log.Println("http redirect stopped:", redirectErr)
+			}()
+		}
+	}
+	server.Handler = handler
+
+	// systemd sends SIGTERM on stop and restart, ctrl-c sends SIGINT.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if config.BackupDir != "" && config.BackupHours > 0 {
+		keep := config.BackupKeep
+		if keep <= 0 {
+			keep = defaultBackupKeep
+		}
+
+		backupsDone := startBackups(ctx, db, config.BackupDir, time.Duration(config.BackupHours)*time.Hour, keep)
+		// runs before the database is closed, stop ends the backups when
+		// the server fails rather than receiving a signal
+		defer func() {
+			stop()
+			<-backupsDone
+		}()
+	}
+
+	purgeDone := startTrashPurge(ctx, db, config.UserDir, trashPurgeInterval)
+	pruneDone := startPruner(ctx, db, config.UserDir, pruneInterval)
+	defer func() {
+		stop()
+		<-purgeDone
+		<-pruneDone
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if reloader != nil {
+			log.Println("tls server starting on", server.Addr)
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			log.Println("server starting on", server.Addr)
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err = <-serveErr:
 		return RcListenAndServe, err
+	case <-ctx.Done():
+		log.Println("shutdown signal received")
+	}
+
+	// a second signal stops the process right away
+	stop()
+
+	shutdownSeconds := config.ShutdownSeconds
+	if shutdownSeconds <= 0 {
+		shutdownSeconds = defaultShutdownSeconds
+	}
+
+	err = shutdown(servers, db, time.Duration(shutdownSeconds)*time.Second)
+	if err != nil {
+		return RcShutdown, err
 	} else {
 		return RcOkay, nil
 	}
@@ -204,39 +416,58 @@ func getUserFromRequest(w http.ResponseWriter, r *http.Request) *user.User {
 }
 
 // makeUserLookup creates a [middleware] function that will retrieve the authenticated user
-// and make it available in the request context.
-func makeUserLookup(db *database.Database) middleware {
+// and make it available in the request context.  Requests carrying an API token in an
+// "Authorization: Bearer" header are authenticated by the token.  All others use the
+// session cookie in builtin auth mode or the X-EMAIL header set by oauth2-proxy, which
+// is only believed when the proxy is trusted.  Disabled users are turned away
+// with forbidden.html, or a JSON error under the API prefix where tmplEngine
+// may be nil.
+func makeUserLookup(db *database.Database, auth *authSettings, tmplEngine *templateEngine) middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			u := r.Context().Value(userKey)
 
 			if u == nil {
-				userEmail := r.Header.Get("X-EMAIL")
-				userId := r.Header.Get("X-USER")
-				log.Printf("X-EMAIL is %s\n", userEmail)
-				log.Printf("X-USER is %s\n", userId)
-
-				if userEmail == "" {
-					log.Printf("X-EMAIL header not set\n")
-					w.WriteHeader(http.StatusNotFound)
-					return
-				}
-
-				u, err := user.GetByEmail(db, userEmail)
-
-				if err != nil {
-					log.Printf("error getting user object: %v\n", err)
-					w.WriteHeader(http.StatusNotFound)
-					return
+				ctx := r.Context()
+
+				var found *user.User
+				if secret, ok := token.FromHeader(r.Header.Get("Authorization")); ok {
+					t, u, status := lookupTokenUser(db, r, secret)
+					if u == nil {
+						w.Header().Set("WWW-Authenticate", `Bearer realm="tapedeck"`)
+						w.WriteHeader(status)
+						return
+					}
+					ctx = context.WithValue(ctx, tokenKey, t)
+					found = u
+				} else if auth.builtin {
+					s, u, status := lookupSessionUser(db, r)
+					if u == nil {
+						if status == http.StatusUnauthorized && !strings.HasPrefix(r.URL.Path, apiPrefix) {
+							http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+							return
+						}
+						w.WriteHeader(status)
+						return
+					}
+					ctx = context.WithValue(ctx, sessionKey, s)
+					found = u
+				} else {
+					u, status := lookupHeaderUser(db, auth.trust, r)
+					if u == nil {
+						w.WriteHeader(status)
+						return
+					}
+					found = u
 				}
 
-				if u == nil {
-					log.Printf("user object not found for email %q\n", userEmail)
-					w.WriteHeader(http.StatusNotFound)
+				if !found.Enabled() {
+					log.Printf("%v is %s\n", found, found.Status)
+					writeForbidden(w, r, tmplEngine, "Your account is disabled.")
 					return
 				}
 
-				ctx := context.WithValue(r.Context(), userKey, u)
+				ctx = context.WithValue(ctx, userKey, found)
 				newRequest := r.WithContext(ctx)
 
 				log.Printf("user added to request context %v\n", ctx)
@@ -249,6 +480,141 @@ func makeUserLookup(db *database.Database) middleware {
 	}
 }
 
+// forbiddenPage is the data for forbidden.html.
+type forbiddenPage struct {
+	Message string
+}
+
+// writeForbidden responds 403 with msg.
+func writeForbidden(w http.ResponseWriter, r *http.Request, tmplEngine *templateEngine, msg string) {
+	if strings.HasPrefix(r.URL.Path, apiPrefix) || tmplEngine == nil {
+		writeApiError(w, http.StatusForbidden, "%s", msg)
+		return
+	}
+
+	bytes, evalErr := tmplEngine.eval("forbidden.html", forbiddenPage{Message: msg})
+	if evalErr != nil {
+		http.Error(w, evalErr.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(bytes)
+}
+
+// lookupHeaderUser finds the user named by the X-EMAIL header.
+// It returns the status to respond with when no user is found.
+func lookupHeaderUser(db *database.Database, trust *proxyTrust, r *http.Request) (*user.User, int) {
+	if !trust.trusted(r) {
+		return nil, http.StatusForbidden
+	}
+
+	userEmail := r.Header.Get("X-EMAIL")
+	userId := r.Header.Get("X-USER")
+	log.Printf("X-EMAIL is %s\n", userEmail)
+	log.Printf("X-USER is %s\n", userId)
+
+	if userEmail == "" {
+		log.Printf("X-EMAIL header not set\n")
+		return nil, http.StatusNotFound
+	}
+
+	u, err := user.GetByEmail(db, userEmail)
+
+	if err != nil {
+		log.Printf("error getting user object: %v\n", err)
+		return nil, http.StatusNotFound
+	}
+
+	if u == nil {
+		log.Printf("user object not found for email %q\n", userEmail)
+		return nil, http.StatusNotFound
+	}
+
+	return u, http.StatusOK
+}
+
+// lookupTokenUser finds the owner of the API token secret and checks the
+// token's scope permits the request.  It returns the status to respond
+// with when the request is not allowed.
+func lookupTokenUser(db *database.Database, r *http.Request, secret string) (*token.Token, *user.User, int) {
+	t, err := token.Lookup(db, secret)
+	if err != nil {
+		log.Printf("error getting token: %v\n", err)
+		return nil, nil, http.StatusInternalServerError
+	}
+
+	if t == nil {
+		log.Printf("token not found or revoked\n")
+		return nil, nil, http.StatusUnauthorized
+	}
+
+	if !t.Allows(r.Method) {
+		log.Printf("%v does not allow %s\n", t, r.Method)
+		return nil, nil, http.StatusForbidden
+	}
+
+	u, err := user.GetById(db, t.UserId)
+	if err != nil {
+		log.Printf("error getting user object: %v\n", err)
+		return nil, nil, http.StatusInternalServerError
+	}
+
+	if u == nil {
+		log.Printf("user object not found for %v\n", t)
+		return nil, nil, http.StatusUnauthorized
+	}
+
+	err = token.Touch(db, t.Id)
+	if err != nil {
+		log.Printf("failed to update token last used: %v\n", err)
+	}
+
+	log.Printf("authenticated %v with %v\n", u, t)
+	return t, u, http.StatusOK
+}
+
+// dbReturnCode maps an error from the database lifecycle onto a [ReturnCode].
+func dbReturnCode(err error) ReturnCode {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return RcDbNotFound
+	case errors.Is(err, database.ErrWrongState):
+		return RcDbState
+	case errors.Is(err, database.ErrMigration):
+		return RcDbMigration
+	case errors.Is(err, database.ErrSchemaVersion):
+		return RcDbSchema
+	default:
+		return RcDbOpen
+	}
+}
+
+// makeRecoverer is a [middleware] function that turns a panic in the
+// handlers after it into a 500 response, so one bad request is logged
+// with its stack instead of taking down the connection.
+func makeRecoverer(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			log.Printf("panic in %s %s: %v\n%v", r.Method, r.URL.Path, p, string(debug.Stack()))
+			if strings.HasPrefix(r.URL.Path, apiPrefix) {
+				writeApiError(w, http.StatusInternalServerError, "internal error")
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // makeLogger is a [middleware] function that logs all header values.
 func makeLogger(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -274,14 +640,6 @@ func makeRootHandler(tmplEngine *templateEngine) middleware {
 			log.Println("enter rootHandler", r.URL.String())
 			defer log.Println("exit rootHandler")
 
-			defer func() {
-				if r := recover(); r != nil {
-					msg := fmt.Sprintf("panic in handleRoot: %v\n%v", r, string(debug.Stack()))
-					log.Println(msg)
-					http.Error(w, msg, 500)
-				}
-			}()
-
 			bytes, evalErr := tmplEngine.eval("index.html", "")
 			if evalErr != nil {
 				http.Error(w, evalErr.Error(), 500)
@@ -296,36 +654,177 @@ func makeRootHandler(tmplEngine *templateEngine) middleware {
 	}
 }
 
+// listPageSize is the number of recordings on each page of the list.
+const listPageSize = 50
+
+// listSort is an option of the sort menu on the list page.
+type listSort struct {
+	Value string
+	Label string
+}
+
+var listSorts = []listSort{
+	{"", "Best match or newest"},
+	{tape.SortAirDate, "Air date"},
+	{tape.SortCreated, "Recorded"},
+	{tape.SortTitle, "Title"},
+	{tape.SortDuration, "Length"},
+}
+
+// listPage is the data for list.html and list-results.html.
+type listPage struct {
+	Filter   tape.Filter
+	Stations []*station.Station
+	Statuses []string
+	Sorts    []listSort
+	Results  []listResult
+	// Filtered is set when any filter is in use, so an empty result is
+	// told apart from having no recordings.
+	Filtered bool
+	// After is the cursor the page started at.  First links to the
+	// start of the list and Next to the following page when there is one.
+	After string
+	First string
+	Next  string
+	// Shared are the tapes other users shared with the user, only shown
+	// with the whole page.
+	Shared []*tape.Tape
+	Error  string
+}
+
+// listResult is the data for list-row.html, a row of the recordings table.
+type listResult struct {
+	*tape.Result
+	Highlight template.HTML
+	Length    string
+	Csrf      string
+}
+
+// highlight escapes a search snippet and marks the words that matched.
+func highlight(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, tape.MarkStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, tape.MarkEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// formatLength shows a duration in seconds as h:mm:ss, or m:ss under an
+// hour.  Zero is shown as nothing since the length is not known yet.
+func formatLength(seconds int64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// parseFilter reads the search form from the query string.
+func parseFilter(r *http.Request) (tape.Filter, error) {
+	q := r.URL.Query()
+	filter := tape.Filter{
+		Query:  strings.TrimSpace(q.Get("q")),
+		From:   q.Get("from"),
+		To:     q.Get("to"),
+		Status: q.Get("status"),
+		Sort:   q.Get("sort"),
+		Order:  q.Get("order"),
+	}
+
+	if s := q.Get("station"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid station %q", s)
+		}
+		filter.StationId = id
+	}
+
+	return filter, filter.Validate()
+}
+
+// isHtmxRequest reports if htmx sent the request to swap in part of the
+// page.  History restores need the whole page.
+func isHtmxRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-History-Restore-Request") != "true"
+}
+
 func makeListHandler(db *database.Database, tmplEngine *templateEngine) middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			log.Println("enter MakeListHandler", r.URL.String())
 			defer log.Println("exit MakeListHandler")
-			defer func() {
-				if r := recover(); r != nil {
-					msg := fmt.Sprintf("panic in MakeListHandler: %v\n%v", r, string(debug.Stack()))
-					log.Println(msg)
-					http.Error(w, msg, 500)
-				}
-			}()
 
 			u := getUserFromRequest(w, r)
 			if u == nil {
 				return
 			}
 
-			tapes, getErr := tape.GetTapesForUser(u.Id, db)
-			log.Println("GetAllTapes returned items: ", len(tapes))
-			for i, v := range tapes {
-				log.Println(i, v)
+			page := listPage{
+				Statuses: []string{tape.StatusTodo, tape.StatusInProgress, tape.StatusDone, tape.StatusError, tape.StatusPaused},
+				Sorts:    listSorts,
+				After:    r.URL.Query().Get("after"),
 			}
 
-			if getErr != nil {
-				http.Error(w, getErr.Error(), 500)
+			stations, err := station.GetAll(db, database.AllRows)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
 				return
 			}
+			page.Stations = stations
+
+			filter, err := parseFilter(r)
+			page.Filter = filter
+			page.Filtered = filter != tape.Filter{Sort: filter.Sort, Order: filter.Order}
+
+			var results []*tape.Result
+			var nextCursor string
+			if err == nil {
+				results, nextCursor, err = tape.Search(db, u.Id, filter, page.After, listPageSize)
+				if err != nil && !errors.Is(err, tape.ErrCursor) {
+					http.Error(w, err.Error(), 500)
+					return
+				}
+			}
 
-			bytes, evalErr := tmplEngine.eval("list.html", tapes)
+			if err != nil {
+				page.Error = err.Error()
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			log.Println("SearchTapes returned items: ", len(results))
+
+			for _, result := range results {
+				page.Results = append(page.Results, listResult{
+					Result:    result,
+					Highlight: highlight(result.Snippet),
+					Length:    formatLength(result.Duration),
+					Csrf:      csrfToken(r),
+				})
+			}
+
+			q := r.URL.Query()
+			q.Del("after")
+			page.First = "/s/list?" + q.Encode()
+			if nextCursor != "" {
+				q.Set("after", nextCursor)
+				page.Next = "/s/list?" + q.Encode()
+			}
+
+			// the same URL returns the whole page or only the results
+			w.Header().Add("Vary", "HX-Request")
+			name := "list.html"
+			if isHtmxRequest(r) {
+				name = "list-results.html"
+			} else {
+				page.Shared, err = tape.GetSharedWith(db, u.Id)
+				if err != nil {
+					http.Error(w, err.Error(), 500)
+					return
+				}
+			}
+
+			bytes, evalErr := tmplEngine.eval(name, page)
 			if evalErr != nil {
 				http.Error(w, evalErr.Error(), 500)
 				return
@@ -337,34 +836,42 @@ func makeListHandler(db *database.Database, tmplEngine *templateEngine) middlewa
 	}
 }
 
-func makePlaybackHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+// playbackPage is the data for playback.html.
+type playbackPage struct {
+	*tape.Tape
+	Csrf     string
+	Playlist []playerTrack
+	// Access is what the user may do, the page only offers that.
+	Access tape.Access
+}
+
+func makePlaybackHandler(db *database.Database, userDir string, tmplEngine *templateEngine) middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			log.Println("enter MakePlaybackHandler", r.URL.String())
 			defer log.Println("exit MakePlaybackHandler")
 
-			defer func() {
-				if r := recover(); r != nil {
-					msg := fmt.Sprintf("panic in MakePlaybackHandler: %v\n%v", r, string(debug.Stack()))
-					log.Println(msg)
-					http.Error(w, msg, 500)
-				}
-			}()
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
 
-			params := r.URL.Query()
-			id, parseErr := strconv.ParseInt(params.Get("id"), 10, 64)
-			if parseErr != nil {
-				http.Error(w, fmt.Errorf("tape id failed to parse as number: %w", parseErr).Error(), 500)
+			t := getTapeForUser(w, r, db, u, r.FormValue("id"), tape.AccessRead, false)
+			if t == nil {
 				return
 			}
 
-			t, getErr := tape.GetTape(id, db)
-			if getErr != nil {
-				http.Error(w, getErr.Error(), 500)
+			access, err := tape.AccessFor(db, t, u.Id)
+			var playlist []playerTrack
+			if err == nil {
+				playlist, err = buildPlaylist(userDir, []*tape.Tape{t}, ownAudioPrefix)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
 				return
 			}
 
-			bytes, evalErr := tmplEngine.eval("playback.html", t)
+			bytes, evalErr := tmplEngine.eval("playback.html", playbackPage{Tape: t, Csrf: csrfToken(r), Playlist: playlist, Access: access})
 			if evalErr != nil {
 				http.Error(w, evalErr.Error(), 500)
 				return
@@ -382,14 +889,6 @@ func makeRecordHandler(_ *database.Database, tmplEngine *templateEngine) middlew
 			log.Println("enter MakeRecordHandler", r.URL.String())
 			defer log.Println("exit MakeRecordHandler")
 
-			defer func() {
-				if r := recover(); r != nil {
-					msg := fmt.Sprintf("panic in MakeRecordHandler: %v\n%v", r, string(debug.Stack()))
-					log.Println(msg)
-					http.Error(w, msg, 500)
-				}
-			}()
-
 			u := getUserFromRequest(w, r)
 			if u == nil {
 				return
@@ -407,6 +906,81 @@ func makeRecordHandler(_ *database.Database, tmplEngine *templateEngine) middlew
 	}
 }
 
+// tokensPage is the data for tokens.html.
+type tokensPage struct {
+	Csrf   string
+	Tokens []*token.Token
+	// NewToken and NewSecret are set right after a token is created.
+	NewToken  *token.Token
+	NewSecret string
+	Error     string
+}
+
+// makeTokensHandler lists the user's API tokens and handles the forms to create and revoke them.
+func makeTokensHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeTokensHandler", r.URL.String())
+			defer log.Println("exit MakeTokensHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			if r.Context().Value(tokenKey) != nil {
+				http.Error(w, "API tokens cannot manage API tokens", http.StatusForbidden)
+				return
+			}
+
+			page := tokensPage{Csrf: csrfToken(r)}
+
+			if r.Method == http.MethodPost {
+				switch r.PostFormValue("action") {
+				case "create":
+					t, secret, err := token.New(u.Id, r.PostFormValue("name"), r.PostFormValue("scope"))
+					if err == nil {
+						err = token.Insert(db, &t)
+					}
+					if err != nil {
+						page.Error = err.Error()
+					} else {
+						page.NewToken = &t
+						page.NewSecret = secret
+					}
+				case "revoke":
+					id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+					if err == nil {
+						_, err = token.Revoke(db, u.Id, id)
+					}
+					if err != nil {
+						page.Error = err.Error()
+					}
+				default:
+					http.Error(w, "unknown action", http.StatusBadRequest)
+					return
+				}
+			}
+
+			tokens, getErr := token.GetForUser(db, u.Id)
+			if getErr != nil {
+				http.Error(w, getErr.Error(), 500)
+				return
+			}
+			page.Tokens = tokens
+
+			bytes, evalErr := tmplEngine.eval("tokens.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
 // templateEngine is built upon [template.Template]. It is used to cache and evaluate templates.
 // Call [newTemplateEngine] to create a new instance and call [templateEngine.Init] before first use.
 // Failure call [templateEngine.Init] before first use will result in a panic.
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+)
+
+func TestDbReturnCode(t *testing.T) {
+	tests := []struct {
+		err error
+		rc  ReturnCode
+	}{
+		{fmt.Errorf("%w: open", database.ErrNotFound), RcDbNotFound},
+		{fmt.Errorf("%w: close", database.ErrWrongState), RcDbState},
+		{fmt.Errorf("%w: bad line", database.ErrMigration), RcDbMigration},
+		{fmt.Errorf("disk full"), RcDbOpen},
+	}
+
+	for _, test := range tests {
+		actual := dbReturnCode(test.err)
+		if actual != test.rc {
+			t.Errorf("%v: expected %d, actual %d", test.err, test.rc, actual)
+		}
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	panics := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			panic("test panic")
+		}
+	}
+	handler := chain(makeRecoverer, panics)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/s/list", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, actual %d", http.StatusInternalServerError, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "test panic") {
+		t.Fatalf("panic value leaked into response: %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix+"/tapes", nil))
+	if w.Code != http.StatusInternalServerError || !strings.HasPrefix(w.Body.String(), `{"error"`) {
+		t.Fatalf("expected JSON 500, actual %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	snippet := "<b>" + tape.MarkStart + "Naima" + tape.MarkEnd + "</b> & more"
+	expected := "&lt;b&gt;<mark>Naima</mark>&lt;/b&gt; &amp; more"
+
+	actual := string(highlight(snippet))
+	if actual != expected {
+		t.Fatalf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestListSearch(t *testing.T) {
+	db := setupDb(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, title := range []string{"Jazz Train", "Late Risers Club"} {
+		tp := tape.New(u.Id, u.Uuid, s.Id, title, "2026-10-17")
+		err = tape.Insert(db, &tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := chain(makeUserLookup(db, &authSettings{trust: trust}, tmplEngine), makeListHandler(db, tmplEngine))
+
+	tests := []struct {
+		query    string
+		htmx     bool
+		code     int
+		contains string
+		missing  string
+	}{
+		{"", false, http.StatusOK, "Late Risers Club", ""},
+		{"?q=jazz", false, http.StatusOK, "<mark>Jazz</mark>", "Late Risers Club"},
+		{"?q=bebop", false, http.StatusOK, "No recordings match", "Jazz Train"},
+		{"?from=2026-13-01", false, http.StatusBadRequest, "not YYYY-MM-DD", "Jazz Train"},
+		{"?after=bogus", false, http.StatusBadRequest, "invalid page cursor", "Jazz Train"},
+		// htmx only gets the table
+		{"?sort=title", true, http.StatusOK, "Jazz Train", "<html"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/s/list"+test.query, nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-EMAIL", testEmail)
+		if test.htmx {
+			r.Header.Set("HX-Request", "true")
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		body := w.Body.String()
+		if w.Code != test.code || !strings.Contains(body, test.contains) || (test.missing != "" && strings.Contains(body, test.missing)) {
+			t.Errorf("%q: expected %d with %q, actual %d %q", test.query, test.code, test.contains, w.Code, body)
+		}
+	}
+}
+
+func TestFormatLength(t *testing.T) {
+	tests := map[int64]string{0: "", 59: "0:59", 3599: "59:59", 3600: "1:00:00", 7384: "2:03:04"}
+
+	for seconds, expected := range tests {
+		actual := formatLength(seconds)
+		if actual != expected {
+			t.Errorf("%d: expected %q, actual %q", seconds, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+	"time"
+)
+
+// defaultShutdownSeconds is how long in-flight requests get to finish.
+// systemd waits 90 seconds before it kills the process.
+const defaultShutdownSeconds = 30
+
+// shutdown stops the servers accepting requests and waits up to timeout for
+// in-flight requests to finish.  Tapes that were being captured are then
+// put back in the queue so they are picked up again after the restart.
+func shutdown(servers []*http.Server, db *database.Database, timeout time.Duration) error {
+	log.Println("enter shutdown", timeout)
+	defer log.Println("exit shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for _, server := range servers {
+		log.Println("shutdown server", server.Addr)
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Println("server shutdown failed", server.Addr, err)
+			errs = append(errs, err)
+		}
+	}
+
+	count, err := tape.ResetInProgress(db, "interrupted by server shutdown")
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		log.Println("tapes returned to queue:", count)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	db := setupDb(t)
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capturing := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	capturing.Status = tape.StatusInProgress
+	err = tape.Insert(db, &capturing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	err = shutdown([]*http.Server{server}, db, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := <-body; b != "done" {
+		t.Fatalf("in-flight request not drained: %q", b)
+	}
+
+	_, err = http.Get("http://" + ln.Addr().String())
+	if err == nil {
+		t.Fatalf("server still accepting requests after shutdown")
+	}
+
+	got, err := tape.GetTape(capturing.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != tape.StatusTodo || got.StatusMsg == "" {
+		t.Fatalf("in progress tape not returned to queue: %+v", got)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	db := setupDb(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	defer close(release)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	err = shutdown([]*http.Server{server}, db, 50*time.Millisecond)
+	if err == nil {
+		t.Fatalf("expected deadline error for stuck request")
+	}
+}
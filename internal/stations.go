@@ -0,0 +1,332 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/audit"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/user"
+
+	"github.com/google/uuid"
+)
+
+// stationLogoDir is the directory under the user directory holding station logos.
+const stationLogoDir = "station"
+
+// maxLogoBytes is the largest station logo accepted.
+const maxLogoBytes = 1 << 20
+
+// logoExtensions are the accepted logo content types.  SVG is left out
+// because it can carry script.
+var logoExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// stationsPage is the data for admin-stations.html.
+type stationsPage struct {
+	Csrf     string
+	Stations []*station.Station
+	// Edit is the station shown in the form, empty to add one.
+	Edit    station.Station
+	Message string
+	Error   string
+}
+
+// makeStationsAdminHandler lists the stations and handles the forms to
+// add, edit, seed and delete them.
+func makeStationsAdminHandler(db *database.Database, userDir string, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeStationsAdminHandler", r.URL.String())
+			defer log.Println("exit MakeStationsAdminHandler")
+
+			admin := getUserFromRequest(w, r)
+			if admin == nil {
+				return
+			}
+
+			page := stationsPage{Csrf: csrfToken(r)}
+
+			if r.Method == http.MethodPost {
+				var err error
+				switch r.PostFormValue("action") {
+				case "save":
+					page.Edit, err = saveStation(db, userDir, admin, r)
+					if err == nil {
+						page.Message = "Saved " + page.Edit.CallLetters
+						page.Edit = station.Station{}
+					}
+				case "seed":
+					var count int
+					err = db.WithTx(r.Context(), func(tx *database.Tx) error {
+						var seedErr error
+						count, seedErr = station.Seed(tx)
+						if seedErr != nil {
+							return seedErr
+						}
+						return recordAdmin(tx, admin, admin.Id, audit.ActionStation, fmt.Sprintf("seeded %d stations", count))
+					})
+					page.Message = fmt.Sprintf("Added %d of %d built-in stations", count, len(station.Seeds))
+				case "delete":
+					var id int64
+					id, err = strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+					if err == nil {
+						err = deleteStation(r.Context(), db, userDir, admin, id)
+					}
+					if err == nil {
+						page.Message = "Deleted"
+					}
+				default:
+					http.Error(w, "unknown action", http.StatusBadRequest)
+					return
+				}
+
+				if err != nil {
+					page.Message = ""
+					page.Error = err.Error()
+				}
+			} else if idParam := r.URL.Query().Get("id"); idParam != "" {
+				id, err := strconv.ParseInt(idParam, 10, 64)
+				if err != nil {
+					http.Error(w, "invalid id", http.StatusBadRequest)
+					return
+				}
+
+				s, err := station.Get(db, id)
+				if err != nil {
+					http.Error(w, err.Error(), 500)
+					return
+				}
+				if s == nil {
+					http.NotFound(w, r)
+					return
+				}
+				page.Edit = *s
+			}
+
+			stations, getErr := station.GetAll(db, database.AllRows)
+			if getErr != nil {
+				http.Error(w, getErr.Error(), 500)
+				return
+			}
+			page.Stations = stations
+
+			bytes, evalErr := tmplEngine.eval("admin-stations.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// saveStation inserts or updates the station posted in r and stores its
+// logo when one was uploaded.  It returns the posted station so the form
+// can be shown again on error.
+func saveStation(db *database.Database, userDir string, admin *user.User, r *http.Request) (station.Station, error) {
+	s := station.Station{
+		CallLetters:   r.PostFormValue("callLetters"),
+		Freq:          r.PostFormValue("freq"),
+		Desc:          r.PostFormValue("desc"),
+		HomepageUrl:   r.PostFormValue("homepageUrl"),
+		ArchivesUrl:   r.PostFormValue("archivesUrl"),
+		LiveStreamUrl: r.PostFormValue("liveStreamUrl"),
+		TimeZone:      r.PostFormValue("timeZone"),
+	}
+
+	if idParam := r.PostFormValue("id"); idParam != "" {
+		id, err := strconv.ParseInt(idParam, 10, 64)
+		if err != nil {
+			return s, err
+		}
+		s.Id = id
+	}
+
+	err := s.Validate()
+	if err != nil {
+		return s, err
+	}
+
+	logo, ext, err := readLogo(r)
+	if err != nil {
+		return s, err
+	}
+
+	// written first under a new name, removed again if the save fails
+	newLogo := ""
+	if logo != nil {
+		newLogo, err = writeLogo(userDir, logo, ext)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	oldLogo := ""
+	err = db.WithTx(r.Context(), func(tx *database.Tx) error {
+		var err error
+		if s.Id == 0 {
+			err = station.Insert(tx, &s)
+		} else {
+			existing, getErr := station.Get(tx, s.Id)
+			if getErr != nil {
+				return getErr
+			}
+			if existing == nil {
+				return fmt.Errorf("station %d not found", s.Id)
+			}
+			oldLogo = existing.LogoPath
+			s.LogoPath = existing.LogoPath
+			err = station.Update(tx, &s)
+		}
+		if err != nil {
+			return err
+		}
+
+		detail := fmt.Sprintf("saved %v", &s)
+		if newLogo != "" {
+			err = station.SetLogo(tx, s.Id, newLogo)
+			if err != nil {
+				return err
+			}
+			s.LogoPath = newLogo
+			detail += " with a new logo"
+		}
+
+		return recordAdmin(tx, admin, admin.Id, audit.ActionStation, detail)
+	})
+
+	if err != nil {
+		if newLogo != "" {
+			os.Remove(filepath.Join(userDir, newLogo))
+		}
+		return s, err
+	}
+
+	if newLogo != "" && oldLogo != "" {
+		removeLogo(userDir, oldLogo)
+	}
+
+	return s, nil
+}
+
+// deleteStation deletes the station and its logo on behalf of admin.
+func deleteStation(ctx context.Context, db *database.Database, userDir string, admin *user.User, id int64) error {
+	logoPath := ""
+	err := db.WithTx(ctx, func(tx *database.Tx) error {
+		s, err := station.Get(tx, id)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return fmt.Errorf("station %d not found", id)
+		}
+		logoPath = s.LogoPath
+
+		err = station.Delete(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAdmin(tx, admin, admin.Id, audit.ActionStation, fmt.Sprintf("deleted %v", s))
+	})
+
+	if err == nil && logoPath != "" {
+		removeLogo(userDir, logoPath)
+	}
+
+	return err
+}
+
+// readLogo returns the uploaded logo and its file extension, or nil when
+// no file was chosen.
+func readLogo(r *http.Request) ([]byte, string, error) {
+	file, _, err := r.FormFile("logo")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	logo, err := io.ReadAll(io.LimitReader(file, maxLogoBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(logo) > maxLogoBytes {
+		return nil, "", fmt.Errorf("logo is larger than %d KB", maxLogoBytes/1024)
+	}
+
+	contentType := http.DetectContentType(logo)
+	ext, ok := logoExtensions[contentType]
+	if !ok {
+		return nil, "", fmt.Errorf("logo must be a PNG, JPEG, GIF or WebP image, not %s", contentType)
+	}
+
+	return logo, ext, nil
+}
+
+// writeLogo saves the logo under a new name and returns its path relative
+// to the user directory.
+func writeLogo(userDir string, logo []byte, ext string) (string, error) {
+	err := os.MkdirAll(filepath.Join(userDir, stationLogoDir), 0o750)
+	if err != nil {
+		return "", err
+	}
+
+	logoPath := filepath.Join(stationLogoDir, uuid.New().String()+ext)
+	return logoPath, os.WriteFile(filepath.Join(userDir, logoPath), logo, 0o640)
+}
+
+// removeLogo deletes a logo that is no longer used.  A failure only
+// leaves a stray file behind so it is logged.
+func removeLogo(userDir string, logoPath string) {
+	err := os.Remove(filepath.Join(userDir, logoPath))
+	if err != nil {
+		log.Println("failed to remove old logo", err)
+	}
+}
+
+// makeStationLogoHandler serves the logo of the station in the path.
+func makeStationLogoHandler(db *database.Database, userDir string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeStationLogoHandler", r.URL.String())
+			defer log.Println("exit MakeStationLogoHandler")
+
+			id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+
+			s, err := station.Get(db, id)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			if s == nil || s.LogoPath == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			// revalidated so a new upload shows at once
+			w.Header().Set("Cache-Control", "private, no-cache")
+			http.ServeFile(w, r, filepath.Join(userDir, s.LogoPath))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"tapedeck/internal/database"
+)
+
+// statusPage is the body of the /status response.
+type statusPage struct {
+	Status string                 `json:"status"`
+	Schema database.SchemaVersion `json:"schema"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// checkSchema compares the schema of the database file with this build before
+// the server starts.  Pending migrations are applied when autoMigrate is set,
+// otherwise the server refuses to start as would a file from a newer build.
+func checkSchema(db *database.Database, autoMigrate bool) (database.SchemaVersion, error) {
+	log.Println("enter checkSchema", autoMigrate)
+	defer log.Println("exit checkSchema")
+
+	v, err := db.SchemaVersion(context.TODO())
+	if err != nil {
+		return v, err
+	}
+	log.Println("database", v)
+
+	if v.Newer() {
+		return v, fmt.Errorf("%w: %v, the file was upgraded by a newer tapedeck", database.ErrSchemaVersion, v)
+	}
+
+	if !v.Pending() {
+		return v, nil
+	}
+
+	if !autoMigrate {
+		return v, fmt.Errorf("%w: %v, run cmd/db -action upgrade or set autoMigrate", database.ErrSchemaVersion, v)
+	}
+
+	log.Println("applying pending migrations")
+	err = db.Upgrade()
+	if err != nil {
+		return v, err
+	}
+
+	return db.SchemaVersion(context.TODO())
+}
+
+// makeStatusHandler reports whether the server can reach its database
+// along with the schema version, for monitoring and deploy scripts.
+func makeStatusHandler(db *database.Database) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter StatusHandler")
+			defer log.Println("exit StatusHandler")
+
+			page := statusPage{Status: "ok"}
+			status := http.StatusOK
+
+			v, err := db.SchemaVersion(r.Context())
+			page.Schema = v
+			if err != nil {
+				log.Println("status check failed", err)
+				page.Status = "error"
+				page.Error = "database unavailable"
+				status = http.StatusServiceUnavailable
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(page)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"tapedeck/internal/database"
+	"tapedeck/internal/file"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	file.Touch(dbPath)
+	db := database.New(dbPath)
+	t.Cleanup(func() { db.Close(); file.Delete(dbPath) })
+
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = checkSchema(db, false)
+	if !errors.Is(err, database.ErrSchemaVersion) {
+		t.Fatalf("pending without autoMigrate: expected ErrSchemaVersion, actual %v", err)
+	}
+
+	v, err := checkSchema(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Pending() || v.Current == 0 {
+		t.Fatalf("expected migrated schema, actual %v", v)
+	}
+
+	err = db.RunQuery(database.Query{
+		Name:           "SetFutureVersion",
+		Sql:            "PRAGMA user_version = 9999;",
+		PerformsUpdate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = checkSchema(db, true)
+	if !errors.Is(err, database.ErrSchemaVersion) {
+		t.Fatalf("newer schema: expected ErrSchemaVersion, actual %v", err)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	db := setupDb(t)
+
+	w := httptest.NewRecorder()
+	chain(makeStatusHandler(db)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, actual %d", http.StatusOK, w.Code)
+	}
+
+	var page statusPage
+	err := json.Unmarshal(w.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Status != "ok" || page.Schema.Pending() || page.Schema.Latest == 0 {
+		t.Fatalf("unexpected status %+v", page)
+	}
+}
@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"time"
+)
+
+// trashPurgeInterval is how often tapes that have been in the trash for
+// longer than [tape.TrashTtl] are deleted with their files.
+const trashPurgeInterval = time.Hour
+
+// getTapeForUser returns the tape with the public id when u has at least
+// the access asked for, and it is in the trash or not as asked.  Anything
+// else is reported as not found.
+func getTapeForUser(w http.ResponseWriter, r *http.Request, db *database.Database, u *user.User, publicId string, need tape.Access, trashed bool) *tape.Tape {
+	t, err := tape.GetForUser(db, publicId, u.Id, need)
+	if err == nil && (t.Deleted != "") != trashed {
+		err = tape.ErrNotFound
+	}
+
+	if errors.Is(err, tape.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return nil
+	}
+
+	return t
+}
+
+// editPage is the data for edit.html.
+type editPage struct {
+	Csrf     string
+	Tape     *tape.Tape
+	Stations []*station.Station
+	Error    string
+}
+
+// makeEditTapeHandler shows the form to change a tape's title,
+// description, air date and station, and saves it.
+func makeEditTapeHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeEditTapeHandler", r.URL.String())
+			defer log.Println("exit MakeEditTapeHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			t := getTapeForUser(w, r, db, u, r.FormValue("id"), tape.AccessWrite, false)
+			if t == nil {
+				return
+			}
+
+			page := editPage{Csrf: csrfToken(r), Tape: t}
+
+			if r.Method == http.MethodPost {
+				err := saveTape(db, t, r)
+				if err == nil {
+					http.Redirect(w, r, "/s/playback?id="+url.QueryEscape(t.PublicId), http.StatusSeeOther)
+					return
+				}
+
+				page.Error = err.Error()
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			stations, err := station.GetAll(db, database.AllRows)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Stations = stations
+
+			bytes, evalErr := tmplEngine.eval("edit.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// saveTape copies the edit form into t and updates it.
+func saveTape(db *database.Database, t *tape.Tape, r *http.Request) error {
+	t.Title = strings.TrimSpace(r.PostFormValue("title"))
+	t.Desc = strings.TrimSpace(r.PostFormValue("desc"))
+	t.AirDate = r.PostFormValue("airDate")
+
+	stationId, err := strconv.ParseInt(r.PostFormValue("station"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("station required")
+	}
+	t.StationId = stationId
+
+	err = t.Validate()
+	if err != nil {
+		return err
+	}
+	if _, err := time.Parse(time.DateOnly, t.AirDate); err != nil {
+		return fmt.Errorf("air date %q is not YYYY-MM-DD", t.AirDate)
+	}
+
+	s, err := station.Get(db, stationId)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("station %d not found", stationId)
+	}
+
+	return tape.Update(db, t)
+}
+
+// trashedRow is the data for list-trashed.html, the row left in place of
+// a tape moved to the trash from the list.
+type trashedRow struct {
+	*tape.Tape
+	Csrf string
+}
+
+// makeDeleteTapeHandler moves a tape to the trash.  htmx gets a row with
+// an undo button, browsers without it are sent back to the list.
+func makeDeleteTapeHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeDeleteTapeHandler", r.URL.String())
+			defer log.Println("exit MakeDeleteTapeHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			t := getTapeForUser(w, r, db, u, r.FormValue("id"), tape.AccessOwner, false)
+			if t == nil {
+				return
+			}
+
+			_, err := tape.Trash(db, t.Id)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			if !isHtmxRequest(r) {
+				http.Redirect(w, r, "/s/list", http.StatusSeeOther)
+				return
+			}
+
+			bytes, evalErr := tmplEngine.eval("list-trashed.html", trashedRow{Tape: t, Csrf: csrfToken(r)})
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// makeRestoreTapeHandler takes a tape out of the trash.  htmx gets the
+// tape's row of the list back, browsers without it the trash page.
+func makeRestoreTapeHandler(db *database.Database, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeRestoreTapeHandler", r.URL.String())
+			defer log.Println("exit MakeRestoreTapeHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			t := getTapeForUser(w, r, db, u, r.FormValue("id"), tape.AccessOwner, true)
+			if t == nil {
+				return
+			}
+
+			_, err := tape.Restore(db, t.Id)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			if !isHtmxRequest(r) {
+				http.Redirect(w, r, "/s/trash", http.StatusSeeOther)
+				return
+			}
+
+			t.Deleted = ""
+			row := listResult{
+				Result: &tape.Result{Tape: t},
+				Length: formatLength(t.Duration),
+				Csrf:   csrfToken(r),
+			}
+
+			bytes, evalErr := tmplEngine.eval("list-row.html", row)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// trashPage is the data for trash.html.
+type trashPage struct {
+	Csrf    string
+	Tapes   []*tape.Tape
+	Message string
+	Error   string
+}
+
+// makeTrashHandler lists the user's tapes in the trash and deletes one for
+// good before its time is up.
+func makeTrashHandler(db *database.Database, userDir string, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeTrashHandler", r.URL.String())
+			defer log.Println("exit MakeTrashHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			page := trashPage{Csrf: csrfToken(r)}
+
+			if r.Method == http.MethodPost {
+				t := getTapeForUser(w, r, db, u, r.FormValue("id"), tape.AccessOwner, true)
+				if t == nil {
+					return
+				}
+
+				err := removeTape(r.Context(), db, userDir, t)
+				if err != nil {
+					page.Error = err.Error()
+				} else {
+					page.Message = fmt.Sprintf("Deleted %s", t.Title)
+				}
+			}
+
+			tapes, err := tape.GetTrash(db, u.Id)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Tapes = tapes
+
+			bytes, evalErr := tmplEngine.eval("trash.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
+
+// removeTape deletes the tape with its sources, tracks and audio files.
+// The files are moved aside while the transaction is open and moved back
+// when it fails, so the database and userDir stay in step.
+func removeTape(ctx context.Context, db *database.Database, userDir string, t *tape.Tape) error {
+	log.Println("enter removeTape", t)
+	defer log.Println("exit removeTape")
+
+	// never touch anything outside the user directory
+	dir := ""
+	if t.FsPath != "" && filepath.IsLocal(t.FsPath) {
+		dir = filepath.Join(userDir, t.FsPath)
+	}
+	trash := filepath.Join(filepath.Dir(dir), ".deleted-"+filepath.Base(dir))
+
+	moved := false
+	err := db.WithTx(ctx, func(tx *database.Tx) error {
+		err := tape.Delete(tx, t.Id)
+		if err != nil || dir == "" {
+			return err
+		}
+
+		// removed once committed, a rename can be undone
+		err = os.Rename(dir, trash)
+		if errors.Is(err, os.ErrNotExist) {
+			// nothing was captured yet
+			return nil
+		}
+		moved = err == nil
+		return err
+	})
+
+	if err != nil {
+		if moved {
+			if err := os.Rename(trash, dir); err != nil {
+				log.Println("could not move back", trash, err)
+			}
+		}
+		return err
+	}
+
+	if moved {
+		err = os.RemoveAll(trash)
+		if err != nil {
+			return fmt.Errorf("deleted %v but not the files in %s: %w", t, trash, err)
+		}
+	}
+
+	return nil
+}
+
+// purgeTrash deletes every tape that has been in the trash for longer
+// than [tape.TrashTtl] at now.  It returns the number of tapes deleted.
+func purgeTrash(ctx context.Context, db *database.Database, userDir string, now time.Time) (int, error) {
+	tapes, err := tape.GetExpired(db, now)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, t := range tapes {
+		err = removeTape(ctx, db, userDir, t)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(tapes), nil
+}
+
+// startTrashPurge runs [purgeTrash] every interval until ctx is done.
+// The returned channel is closed once the last purge has finished.
+func startTrashPurge(ctx context.Context, db *database.Database, userDir string, interval time.Duration) <-chan struct{} {
+	log.Println("purging the trash every", interval)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				count, err := purgeTrash(ctx, db, userDir, now)
+				if err != nil {
+					log.Println("trash purge failed:", err)
+				} else if count > 0 {
+					log.Println("trash purge deleted", count, "tapes")
+				}
+			}
+		}
+	}()
+
+	return done
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+	"time"
+)
+
+func TestEditAndTrashTape(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err = tape.Insert(db, &tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a tape of someone else
+	err = user.Insert(db, user.New("listener@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := user.GetByEmail(db, "listener@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs := tape.New(other.Id, other.Uuid, s.Id, "Hillbilly at Harvard", "2026-10-18")
+	err = tape.Insert(db, &theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio := filepath.Join(userDir, tp.FsPath, "a.mp3")
+	err = os.MkdirAll(filepath.Dir(audio), 0o750)
+	if err == nil {
+		err = os.WriteFile(audio, []byte("mp3"), 0o640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := makeUserLookup(db, &authSettings{trust: trust}, tmplEngine)
+	edit := chain(lookup, makeEditTapeHandler(db, tmplEngine))
+	del := chain(lookup, makeDeleteTapeHandler(db, tmplEngine))
+	restore := chain(lookup, makeRestoreTapeHandler(db, tmplEngine))
+	trash := chain(lookup, makeTrashHandler(db, userDir, tmplEngine))
+
+	id := tp.PublicId
+	form := url.Values{"id": {id}, "title": {"Late Risers"}, "desc": {"jazz"}, "airDate": {"2026-10-16"}, "station": {fmt.Sprint(s.Id)}}
+
+	w := postForm(edit, "/s/edit", form)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("edit: expected %d, actual %d %q", http.StatusSeeOther, w.Code, w.Body.String())
+	}
+	edited, err := tape.GetTape(tp.Id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Title != "Late Risers" || edited.AirDate != "2026-10-16" || edited.Updated == "" {
+		t.Fatalf("unexpected edited tape %+v", edited)
+	}
+
+	form.Set("airDate", "yesterday")
+	w = postForm(edit, "/s/edit", form)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "is not YYYY-MM-DD") {
+		t.Fatalf("edit: expected bad air date, actual %d %q", w.Code, w.Body.String())
+	}
+
+	// other users' tapes look missing
+	for _, handler := range []http.HandlerFunc{edit, del, restore, trash} {
+		w = postForm(handler, "/s/delete", url.Values{"id": {theirs.PublicId}})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected %d for another user's tape, actual %d", http.StatusNotFound, w.Code)
+		}
+	}
+
+	// playback finds the tape by its public id only
+	playback := chain(lookup, makePlaybackHandler(db, userDir, tmplEngine))
+	for query, code := range map[string]int{id: http.StatusOK, fmt.Sprint(tp.Id): http.StatusNotFound, theirs.PublicId: http.StatusNotFound} {
+		r := newRequest("127.0.0.1:5000", testEmail)
+		r.URL.Path = "/s/playback"
+		r.URL.RawQuery = url.Values{"id": {query}}.Encode()
+		w = httptest.NewRecorder()
+		playback.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("playback %q: expected %d, actual %d", query, code, w.Code)
+		}
+	}
+
+	// htmx swaps the row for an undo button and back
+	w = postHtmx(del, "/s/delete", url.Values{"id": {id}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Undo") {
+		t.Fatalf("delete: expected undo row, actual %d %q", w.Code, w.Body.String())
+	}
+	w = postHtmx(restore, "/s/restore", url.Values{"id": {id}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Late Risers") {
+		t.Fatalf("restore: expected tape row, actual %d %q", w.Code, w.Body.String())
+	}
+
+	w = postForm(del, "/s/delete", url.Values{"id": {id}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("delete: expected %d, actual %d", http.StatusSeeOther, w.Code)
+	}
+
+	// nothing expires until the time is up
+	count, err := purgeTrash(context.Background(), db, userDir, time.Now())
+	if err != nil || count != 0 {
+		t.Fatalf("expected nothing purged, actual %d %v", count, err)
+	}
+
+	count, err = purgeTrash(context.Background(), db, userDir, time.Now().Add(tape.TrashTtl+time.Minute))
+	if err != nil || count != 1 {
+		t.Fatalf("expected one tape purged, actual %d %v", count, err)
+	}
+
+	gone, err := tape.GetTape(tp.Id, db)
+	if err != nil || gone != nil {
+		t.Fatalf("expected tape to be deleted, actual %v %v", gone, err)
+	}
+	if _, err := os.Stat(filepath.Join(userDir, tp.FsPath)); !os.IsNotExist(err) {
+		t.Fatalf("expected audio to be deleted, actual %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(userDir, u.Uuid)); len(entries) != 0 {
+		t.Fatalf("expected nothing left behind, actual %v", entries)
+	}
+}
+
+// postHtmx posts the form as testEmail the way htmx does.
+func postHtmx(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-EMAIL", testEmail)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("HX-Request", "true")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
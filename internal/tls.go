@@ -0,0 +1,149 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes.
+const certCheckInterval = time.Minute
+
+// defaultHstsSeconds is one year, the value recommended for the HSTS preload list.
+const defaultHstsSeconds = 365 * 24 * 60 * 60
+
+// certReloader serves a TLS certificate from files and loads it again when
+// the files change, so a certbot renewal does not need a server restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (c *certReloader) String() string {
+	return fmt.Sprintf("cert reloader %q %q loaded %v", c.certFile, c.keyFile, c.modTime)
+}
+
+// newCertReloader loads the certificate and key or returns an error.
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// latestModTime returns the newer modification time of the two files.
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %q and key %q: %w", c.certFile, c.keyFile, err)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	log.Println("loaded", c)
+	return nil
+}
+
+// getCertificate is used as [tls.Config.GetCertificate].  A certificate that
+// fails to load is logged and the previous one is kept, as certbot may be
+// part way through replacing the files.
+func (c *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) >= certCheckInterval {
+		c.lastCheck = time.Now()
+
+		modTime, err := c.latestModTime()
+		if err != nil {
+			log.Println("failed to check certificate files, keeping current:", err)
+		} else if !modTime.Equal(c.modTime) {
+			err = c.load(modTime)
+			if err != nil {
+				log.Println("failed to reload certificate, keeping current:", err)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+// newTlsConfig returns the TLS settings for the HTTPS listener.
+func newTlsConfig(reloader *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+}
+
+// withHsts adds the Strict-Transport-Security header to every response.
+func withHsts(next http.Handler, maxAgeSeconds int) http.Handler {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", maxAgeSeconds)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRedirectHandler sends plain HTTP requests to the HTTPS listener at tlsAddr.
+// When acmeWebroot is set, certbot's http-01 challenge files are served from it
+// so certificates can be renewed with "certbot certonly --webroot".
+func newRedirectHandler(tlsAddr string, acmeWebroot string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+
+	mux := http.NewServeMux()
+
+	if acmeWebroot != "" {
+		challengeDir := filepath.Join(acmeWebroot, ".well-known", "acme-challenge")
+		mux.Handle("/.well-known/acme-challenge/", http.StripPrefix("/.well-known/acme-challenge/", http.FileServer(http.Dir(challengeDir))))
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	return mux
+}
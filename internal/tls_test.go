@@ -0,0 +1,173 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self signed certificate for commonName and its key.
+func writeCert(t *testing.T, certFile string, keyFile string, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{certFile, keyFile} {
+		err = os.Chtimes(name, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, reloader *certReloader) string {
+	cert, err := reloader.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "fullchain.pem")
+	keyFile := filepath.Join(dir, "privkey.pem")
+	start := time.Now().Add(-time.Hour)
+
+	_, err := newCertReloader(certFile, keyFile)
+	if err == nil {
+		t.Fatalf("expected error for missing files")
+	}
+
+	writeCert(t, certFile, keyFile, "first", start)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := commonName(t, reloader); name != "first" {
+		t.Fatalf("expected first certificate, actual %q", name)
+	}
+
+	// renewal is noticed on the next check
+	writeCert(t, certFile, keyFile, "second", start.Add(time.Minute))
+	reloader.lastCheck = time.Time{}
+
+	if name := commonName(t, reloader); name != "second" {
+		t.Fatalf("expected second certificate, actual %q", name)
+	}
+
+	// a broken renewal keeps the current certificate
+	err = os.WriteFile(certFile, []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.lastCheck = time.Time{}
+
+	if name := commonName(t, reloader); name != "second" {
+		t.Fatalf("expected second certificate to be kept, actual %q", name)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		tlsAddr  string
+		host     string
+		expected string
+	}{
+		{":443", "tapedeck.us", "https://tapedeck.us/s/list?id=1"},
+		{":443", "tapedeck.us:80", "https://tapedeck.us/s/list?id=1"},
+		{"0.0.0.0:8443", "localhost:8080", "https://localhost:8443/s/list?id=1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/s/list?id=1", nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+
+		newRedirectHandler(test.tlsAddr, "").ServeHTTP(w, r)
+
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.expected {
+			t.Errorf("%s %s: expected redirect to %q, actual %d %q", test.tlsAddr, test.host, test.expected, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRedirectHandlerAcme(t *testing.T) {
+	webroot := t.TempDir()
+	challengeDir := filepath.Join(webroot, ".well-known", "acme-challenge")
+
+	err := os.MkdirAll(challengeDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(challengeDir, "abc"), []byte("proof"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/abc", nil)
+	w := httptest.NewRecorder()
+	newRedirectHandler(":443", webroot).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != "proof" {
+		t.Fatalf("expected challenge file, actual %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHsts(t *testing.T) {
+	handler := withHsts(http.NotFoundHandler(), 60)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Header().Get("Strict-Transport-Security") != "max-age=60; includeSubDomains" {
+		t.Fatalf("unexpected header %q", w.Header().Get("Strict-Transport-Security"))
+	}
+}
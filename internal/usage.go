@@ -0,0 +1,75 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"tapedeck/internal/database"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+)
+
+// checkQuota refuses another capture once u has used up their quota.
+// defaultQuota is the server's quota in bytes, 0 is unlimited.
+func checkQuota(u *user.User, defaultQuota int64) error {
+	quota := u.Quota(defaultQuota)
+	if quota > 0 && u.BytesUsed >= quota {
+		return fmt.Errorf("%s of %s used, delete recordings before capturing more", formatBytes(u.BytesUsed), formatBytes(quota))
+	}
+	return nil
+}
+
+// settingsPage is the data for settings.html.
+type settingsPage struct {
+	Email string
+	Used  string
+	// Quota is empty when the user may store as much as they like.
+	Quota   string
+	Percent int64
+	Over    bool
+	Tapes   int
+	Trashed int
+}
+
+// makeSettingsHandler shows the user's account with how much of their
+// quota their tapes use.
+func makeSettingsHandler(db *database.Database, defaultQuota int64, tmplEngine *templateEngine) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Println("enter MakeSettingsHandler", r.URL.String())
+			defer log.Println("exit MakeSettingsHandler")
+
+			u := getUserFromRequest(w, r)
+			if u == nil {
+				return
+			}
+
+			page := settingsPage{Email: u.Email, Used: formatBytes(u.BytesUsed), Over: checkQuota(u, defaultQuota) != nil}
+			if quota := u.Quota(defaultQuota); quota > 0 {
+				page.Quota = formatBytes(quota)
+				page.Percent = min(100, u.BytesUsed*100/quota)
+			}
+
+			tapes, err := tape.GetTapesForUser(u.Id, db)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			trashed, err := tape.GetTrash(db, u.Id)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			page.Tapes, page.Trashed = len(tapes), len(trashed)
+
+			bytes, evalErr := tmplEngine.eval("settings.html", page)
+			if evalErr != nil {
+				http.Error(w, evalErr.Error(), 500)
+				return
+			}
+
+			log.Println("write bytes to response")
+			w.Write(bytes)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tapedeck/internal/database/station"
+	"tapedeck/internal/database/tape"
+	"tapedeck/internal/database/user"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	db := setupDb(t)
+	userDir := t.TempDir()
+
+	u, err := user.GetByEmail(db, testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := station.Station{CallLetters: "WMBR", Freq: "88.1", HomepageUrl: "https://wmbr.org"}
+	err = station.Insert(db, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := tape.New(u.Id, u.Uuid, s.Id, "Late Risers Club", "2026-10-17")
+	err = tape.Insert(db, &tp)
+	if err == nil {
+		err = tape.SetSize(db, tp.Id, 3<<20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := newProxyTrust(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &authSettings{trust: trust}
+
+	tmplEngine := newTemplateEngine("../templates", true)
+	if err := tmplEngine.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	const quota = 4 << 20
+	settings := chain(makeUserLookup(db, auth, tmplEngine), makeSettingsHandler(db, quota, tmplEngine))
+	mux := http.NewServeMux()
+	registerApiRoutes(mux, db, auth, userDir, quota)
+
+	create := func() *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"stationId": %d, "title": "Hillbilly at Harvard", "airDate": "2026-10-18"}`, s.Id)
+		r := httptest.NewRequest(http.MethodPost, apiPrefix+"/tapes", strings.NewReader(body))
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-EMAIL", testEmail)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	settings.ServeHTTP(w, newRequest("127.0.0.1:5000", testEmail))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "3.0 MiB of 4.0 MiB (75%)") {
+		t.Fatalf("expected the usage, actual %d %q", w.Code, w.Body.String())
+	}
+
+	if w = create(); w.Code != http.StatusCreated {
+		t.Fatalf("create: expected %d under the quota, actual %d %q", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	err = tape.SetSize(db, tp.Id, quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = create(); w.Code != http.StatusInsufficientStorage || !strings.Contains(w.Body.String(), "delete recordings") {
+		t.Fatalf("create: expected %d over the quota, actual %d %q", http.StatusInsufficientStorage, w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	settings.ServeHTTP(w, newRequest("127.0.0.1:5000", testEmail))
+	if !strings.Contains(w.Body.String(), "storage is full") {
+		t.Fatalf("expected a full storage, actual %q", w.Body.String())
+	}
+
+	// a quota of their own overrides the server's
+	err = user.SetQuota(db, u.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = create(); w.Code != http.StatusCreated {
+		t.Fatalf("create: expected %d without a quota, actual %d %q", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// deleting frees the space
+	err = user.SetQuota(db, u.Id, user.QuotaDefault)
+	if err == nil {
+		err = removeTape(context.Background(), db, userDir, &tp)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = create(); w.Code != http.StatusCreated {
+		t.Fatalf("create: expected %d after the delete, actual %d %q", http.StatusCreated, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,33 @@
+// Plays the links of each .player one after the other in its <audio>.
+// Without JavaScript the links still open the files one at a time.
+document.querySelectorAll(".player").forEach((player) => {
+  const audio = player.querySelector("audio");
+  const links = Array.from(player.querySelectorAll("ol a"));
+  let current = -1;
+
+  const play = (i) => {
+    links.forEach((link, j) => link.classList.toggle("playing", i === j));
+    current = i;
+    audio.src = links[i].href;
+    audio.play();
+  };
+
+  links.forEach((link, i) => {
+    link.addEventListener("click", (event) => {
+      event.preventDefault();
+      play(i);
+    });
+  });
+
+  audio.addEventListener("play", () => {
+    if (current < 0 && links.length > 0) {
+      play(0);
+    }
+  });
+
+  audio.addEventListener("ended", () => {
+    if (current + 1 < links.length) {
+      play(current + 1);
+    }
+  });
+});